@@ -0,0 +1,19 @@
+// Command server runs FHE_ADD as a server, with its keys loaded at
+// startup, /healthz and /readyz, key reloads, the gRPC API and a drain on
+// SIGTERM. The Dockerfile builds it for Cloud Run.
+package main
+
+import (
+	"log/slog"
+	"os"
+
+	"example.com/add"
+	"example.com/fhe/service"
+)
+
+func main() {
+	if err := add.Server().Run(service.Addr); err != nil {
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,67 @@
+package add
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"example.com/fhe"
+	"example.com/fhe/fhetest"
+	"github.com/ldsec/lattigo/bfv"
+)
+
+func FuzzFHE_ADD(f *testing.F) {
+	_, pk := bfv.NewKeyGenerator(fhe.DefaultParams()).GenKeyPair()
+	x := fhetest.Ciphertext(f, pk, 3)
+	y := fhetest.Ciphertext(f, pk, 2)
+	for _, seed := range []struct{ x, y []byte }{
+		{x, y},
+		{x[:2], y},
+		{[]byte{}, []byte{63, 1}},
+	} {
+		f.Add(seed.x, seed.y)
+	}
+
+	f.Fuzz(func(t *testing.T, x []byte, y []byte) {
+		fhetest.Call(t, FHE_ADD, "", []interface{}{x, y})
+	})
+}
+
+func TestSum(t *testing.T) {
+	params := fhe.DefaultParams()
+	sk, pk := bfv.NewKeyGenerator(params).GenKeyPair()
+	var xs [][]byte
+	for _, v := range []int64{3, -5, 7} {
+		xs = append(xs, fhetest.Ciphertext(t, pk, v))
+	}
+
+	resp := fhetest.Call(t, FHE_ADD, "sum", []interface{}{xs})
+	if resp.ErrorMessage != "" {
+		t.Fatal(resp.ErrorMessage)
+	}
+	b, err := base64.StdEncoding.DecodeString(resp.Replies[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := fhe.UnmarshalCiphertext(params, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := fhe.DecodeValueChecked(bfv.NewEncoder(params), bfv.NewDecryptor(params, sk).DecryptNew(ct))
+	if err != nil || got != 5 {
+		t.Errorf("fhe_sum([3, -5, 7]) = %d, %v, want 5", got, err)
+	}
+
+	for _, c := range []struct {
+		xs   [][]byte
+		want string
+	}{
+		{[][]byte{}, "nothing to sum"},
+		{[][]byte{xs[0], xs[1][:2]}, "element 1"},
+	} {
+		resp := fhetest.Call(t, FHE_ADD, "sum", []interface{}{c.xs})
+		if !strings.Contains(resp.ErrorMessage, c.want) {
+			t.Errorf("fhe_sum of %d elements = %q, want an error saying %q", len(c.xs), resp.ErrorMessage, c.want)
+		}
+	}
+}
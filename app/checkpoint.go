@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// checkpoint records how far a file command got. Output past Offset was
+// written after the last checkpoint and is thrown away on resume.
+type checkpoint struct {
+	Input   string   `json:"input"`
+	Output  string   `json:"output"`
+	Format  string   `json:"format"`
+	Columns []string `json:"columns"`
+
+	// the schema of Avro output and the header of CSV output, which come
+	// from the first chunk
+	Fields []avroField `json:"fields,omitempty"`
+	Header []string    `json:"header,omitempty"`
+
+	Rows   int64 `json:"rows"`
+	Offset int64 `json:"offset"`
+}
+
+func loadCheckpoint(path string) (*checkpoint, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cp := new(checkpoint)
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// save replaces the checkpoint file atomically.
+func (cp *checkpoint) save(path string) error {
+	b, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// matches reports whether a resumed run is doing the same job.
+func (cp *checkpoint) matches(other *checkpoint) error {
+	if cp.Input != other.Input || cp.Output != other.Output || cp.Format != other.Format {
+		return errors.New("checkpoint is for a different input, output or format")
+	}
+	if len(cp.Columns) != len(other.Columns) {
+		return errors.New("checkpoint is for different columns")
+	}
+	for i := range cp.Columns {
+		if cp.Columns[i] != other.Columns[i] {
+			return errors.New("checkpoint is for different columns")
+		}
+	}
+	return nil
+}
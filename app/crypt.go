@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"example.com/fhe"
+)
+
+func pubFlag(fs *flag.FlagSet) *string {
+	return fs.String("pub", envOr(fhe.PublicKeyURLEnv, "pub.bin"), "public key file or URL, raw or base64 (default $"+fhe.PublicKeyURLEnv+")")
+}
+
+func secFlag(fs *flag.FlagSet) *string {
+	return fs.String("sec", envOr(fhe.SecretKeyURLEnv, "sec.bin"), "secret key file or URL, raw or base64 (default $"+fhe.SecretKeyURLEnv+")")
+}
+
+func runEncrypt(args []string) error {
+	fs := newFlagSet("encrypt", "[integer ...]")
+	pub := pubFlag(fs)
+	pack := fs.Bool("pack", false, "encrypt all the integers into one ciphertext, one per slot, for fhe_dot")
+	onehot := fs.Int64("onehot", 0, "encrypt each integer as a one-hot category out of this many buckets, for fhe_sum and fhe_masked_sum")
+	fs.Parse(args)
+	if *pack && *onehot != 0 {
+		return errors.New("-pack and -onehot don't mix")
+	}
+
+	s, err := newSession(*pub, "")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	var vs []int64
+	err = eachInput(fs.Args(), os.Stdin, func(in string) error {
+		v, err := strconv.ParseInt(in, 10, 64)
+		if err != nil {
+			return err
+		}
+		if *pack {
+			vs = append(vs, v)
+			return nil
+		}
+		var ct []byte
+		if *onehot != 0 {
+			ct, err = s.encryptOneHot(v, *onehot)
+		} else {
+			ct, err = s.encrypt(v)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, base64.StdEncoding.EncodeToString(ct))
+		return nil
+	})
+	if err != nil || !*pack {
+		return err
+	}
+	ct, err := s.encryptSlots(vs)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, base64.StdEncoding.EncodeToString(ct))
+	return nil
+}
+
+func runDecrypt(args []string) error {
+	fs := newFlagSet("decrypt", "[base64 ciphertext | @file ...]")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `usage: fhe decrypt [flags] [base64 ciphertext | @file ...]
+
+Prints each value as an integer, or for fhe_aggregate results the count,
+sum, sum of squares, mean and variances as JSON.
+
+`)
+		fs.PrintDefaults()
+	}
+	sec := secFlag(fs)
+	slots := fs.Int("slots", 0, "print the first n slots as a JSON array, for packed results like fhe_nearest's")
+	fs.Parse(args)
+	if *slots < 0 || *slots > 1<<fhe.DefaultParams().LogN {
+		return fmt.Errorf("-slots must be between 0 and %d", 1<<fhe.DefaultParams().LogN)
+	}
+
+	s, err := newSession("", *sec)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	return eachInput(fs.Args(), os.Stdin, func(in string) error {
+		ct, err := readCiphertext(in)
+		if err != nil {
+			return err
+		}
+		if *slots > 0 {
+			v, err := s.decryptSlots(ct, *slots)
+			if err != nil {
+				return err
+			}
+			b, _ := json.Marshal(v)
+			fmt.Fprintln(w, string(b))
+			return nil
+		}
+		if raw, err := s.mac.Open(ct); err == nil && fhe.IsAggregate(raw) {
+			st, err := s.aggregateStats(raw)
+			if err != nil {
+				return err
+			}
+			b, _ := json.Marshal(st)
+			fmt.Fprintln(w, string(b))
+			return nil
+		}
+		v, err := s.decrypt(ct)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, v)
+		return nil
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// cellError is a column of a row that failed to decrypt.
+type cellError struct {
+	Column string
+	Err    error
+}
+
+// cellErrors are the failed columns of one row; the rest of it is fine.
+type cellErrors []cellError
+
+func (e cellErrors) Error() string {
+	var s []string
+	for _, c := range e {
+		s = append(s, fmt.Sprintf("column %s: %v", c.Column, c.Err))
+	}
+	return strings.Join(s, "; ")
+}
+
+func runDecryptFile(args []string) error {
+	fs := newFlagSet("decrypt-file", "")
+	sec := secFlag(fs)
+	j := &fileJob{}
+	fs.StringVar(&j.in, "in", "", "CSV, NDJSON or Avro export to read (required)")
+	fs.StringVar(&j.inFormat, "in-format", "", "csv, ndjson or avro (default from the -in extension)")
+	fs.StringVar(&j.out, "out", "", "CSV, NDJSON or Avro file to write (required)")
+	fs.StringVar(&j.outFormat, "out-format", "", "csv, ndjson or avro (default from the -out extension)")
+	columns := fs.String("columns", "", "comma separated ciphertext columns to decrypt (required)")
+	id := fs.String("id", "", "column to name rows by in the failure report, eg uid")
+	errorsPath := fs.String("errors", "", "append failures to this file as NDJSON instead of printing them")
+	workers := fs.Int("workers", runtime.NumCPU(), "rows decrypted in parallel")
+	fs.IntVar(&j.chunk, "chunk", 1000, "rows per checkpoint")
+	fs.StringVar(&j.checkpoint, "checkpoint", "", "checkpoint file (default -out with .checkpoint appended)")
+	fs.BoolVar(&j.resume, "resume", false, "continue the run recorded in the checkpoint")
+	fs.BoolVar(&j.force, "force", false, "start over, replacing -out, -errors and any checkpoint")
+	fs.Parse(args)
+
+	if j.in == "" || j.out == "" || *columns == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	for _, c := range strings.Split(*columns, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			j.columns = append(j.columns, c)
+		}
+	}
+
+	s, err := newSession("", *sec)
+	if err != nil {
+		return err
+	}
+
+	report := &failureReport{w: os.Stderr, id: *id}
+	if *errorsPath != "" {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		if j.force {
+			flags |= os.O_TRUNC
+		}
+		f, err := os.OpenFile(*errorsPath, flags, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		report.w = f
+		report.json = true
+	}
+
+	j.pool = newPool(s, *workers)
+	j.row = func(s *session, r row) error {
+		var errs cellErrors
+		for _, c := range j.columns {
+			v, err := decryptCell(s, r[c])
+			if err != nil {
+				errs = append(errs, cellError{Column: c, Err: err})
+				r[c] = nil
+			} else if v == nil {
+				r[c] = nil
+			} else {
+				r[c] = *v
+			}
+		}
+		if errs != nil {
+			return errs
+		}
+		return nil
+	}
+	j.fail = report.add
+
+	stats, err := j.run()
+	if stats != nil {
+		fmt.Fprintf(os.Stderr, "decrypted %d rows into %s", stats.Rows, j.out)
+		if stats.Resumed > 0 {
+			fmt.Fprintf(os.Stderr, " after %d from an earlier run", stats.Resumed)
+		}
+		if stats.Failed > 0 {
+			fmt.Fprintf(os.Stderr, ", %d with failures", stats.Failed)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+	if err == nil && stats.Failed > 0 {
+		err = fmt.Errorf("%d rows failed to decrypt, their cells are null", stats.Failed)
+	}
+	return err
+}
+
+// decryptCell decrypts a base64 (CSV and JSON exports) or raw (Avro
+// exports) ciphertext; null and empty cells give nil.
+func decryptCell(s *session, v interface{}) (*int64, error) {
+	var ct []byte
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		ct = v
+	case string:
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return nil, nil
+		}
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, err
+		}
+		ct = b
+	default:
+		return nil, fmt.Errorf("want BYTES, got %T", v)
+	}
+	if len(ct) == 0 {
+		return nil, nil
+	}
+	n, err := s.decrypt(ct)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// failureReport writes one line per failed cell, as text or as NDJSON.
+type failureReport struct {
+	mu   sync.Mutex
+	w    io.Writer
+	json bool
+	id   string
+}
+
+type failure struct {
+	Row    int64       `json:"row"`
+	ID     interface{} `json:"id,omitempty"`
+	Column string      `json:"column"`
+	Error  string      `json:"error"`
+}
+
+func (f *failureReport) add(n int64, r row, err error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	errs, ok := err.(cellErrors)
+	if !ok {
+		errs = cellErrors{{Err: err}}
+	}
+	for _, e := range errs {
+		fl := failure{Row: n, Column: e.Column, Error: e.Err.Error()}
+		if f.id != "" {
+			fl.ID = r[f.id]
+		}
+		if f.json {
+			b, _ := json.Marshal(fl)
+			b = append(b, '\n')
+			if _, err := f.w.Write(b); err != nil {
+				return err
+			}
+			continue
+		}
+		name := fmt.Sprintf("row %d", n)
+		if fl.ID != nil {
+			name = fmt.Sprintf("row %d (%v)", n, fl.ID)
+		}
+		fmt.Fprintf(f.w, "%s column %s: %s\n", name, e.Column, fl.Error)
+	}
+	return nil
+}
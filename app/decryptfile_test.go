@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecryptFile(t *testing.T) {
+	dir := t.TempDir()
+	newTestSession(t, dir)
+	other := t.TempDir()
+	newTestSession(t, other)
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	os.WriteFile(path("in.csv"), []byte("uid,x,y\nu1,1,-1\nu2,2,-2\nu3,,-3\nu4,4,-4\n"), 0600)
+	if err := runEncryptFile([]string{"-pub", path("pub.bin"), "-in", path("in.csv"), "-out", path("enc.avro"), "-columns", "x,y", "-chunk", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	// a row encrypted under another key, the way a mixed up export would be
+	os.WriteFile(path("other.csv"), []byte("uid,x,y\nu5,5,-5\n"), 0600)
+	if err := runEncryptFile([]string{"-pub", filepath.Join(other, "pub.bin"), "-in", path("other.csv"), "-out", path("other.json"), "-columns", "x,y"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the Avro export, then rows appended as JSON
+	if err := runDecryptFile([]string{"-sec", path("sec.bin"), "-in", path("enc.avro"), "-out", path("dec.json"), "-columns", "x,y", "-workers", "3", "-chunk", "3"}); err != nil {
+		t.Fatal(err)
+	}
+	exported, _ := os.ReadFile(path("dec.json"))
+	want := `{"uid":"u1","x":1,"y":-1}
+{"uid":"u2","x":2,"y":-2}
+{"uid":"u3","x":null,"y":-3}
+{"uid":"u4","x":4,"y":-4}
+`
+	if string(exported) != want {
+		t.Errorf("decrypted Avro export:\n%s\nwant\n%s", exported, want)
+	}
+
+	enc, _ := os.ReadFile(path("other.json"))
+	bad := string(enc) + `{"uid":"u6","x":"bm90IGEgY2lwaGVydGV4dA==","y":null}` + "\n"
+	os.WriteFile(path("bad.json"), []byte(bad), 0600)
+	err := runDecryptFile([]string{"-sec", path("sec.bin"), "-in", path("bad.json"), "-out", path("bad.csv"), "-columns", "x,y", "-id", "uid", "-errors", path("errors.json")})
+	if err == nil || !strings.Contains(err.Error(), "2 rows failed") {
+		t.Errorf("runDecryptFile() = %v, want 2 failed rows", err)
+	}
+
+	f, _ := os.Open(path("bad.csv"))
+	recs, _ := csv.NewReader(f).ReadAll()
+	f.Close()
+	if len(recs) != 3 || recs[1][0] != "u5" || recs[1][1] != "" || recs[2][0] != "u6" {
+		t.Errorf("bad.csv = %v", recs)
+	}
+
+	b, _ := os.ReadFile(path("errors.json"))
+	var got []failure
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var f failure
+		if err := json.Unmarshal([]byte(line), &f); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, f)
+	}
+	if len(got) != 3 || got[0].ID != "u5" || got[2].Row != 2 || got[2].Column != "x" {
+		t.Errorf("failures = %+v", got)
+	}
+}
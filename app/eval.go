@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"example.com/fhe"
+	"example.com/fhe/expr"
+)
+
+func runEval(args []string) error {
+	fs := newFlagSet("eval", "expression [column=value ...]")
+	pub := fs.String("pub", "", "public key file or URL, needed for encrypt()")
+	sec := fs.String("sec", "", "secret key file or URL, needed for decrypt()")
+	rlk := fs.String("rlk", "", "relinearization key file or URL, needed for distance2()")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `usage: fhe eval [flags] expression [column=value ...]
+
+Evaluates an expression written like the README queries, for example
+  fhe.fhe_decrypt(fhe.fhe_add(fhe.fhe_encrypt(4), ecd1.x))
+Column values are integers, @file for a raw or base64 ciphertext file, or
+base64 ciphertexts (most shells cap an argument well below the size of one,
+so use @file, eg from fhe encrypt 3 > x.b64). Without any column=value arguments each line of stdin is
+read as a JSON object of columns and evaluated in turn. Ciphertext results
+are printed in base64, decrypted ones as integers.
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	n, err := expr.Parse(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	s, err := newSession(*pub, *sec)
+	if err != nil {
+		return err
+	}
+	if *rlk != "" {
+		if err := s.loadRelinKey(*rlk); err != nil {
+			return err
+		}
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	if fs.NArg() > 1 || len(n.Columns()) == 0 {
+		row := make(map[string]interface{})
+		for _, b := range fs.Args()[1:] {
+			i := strings.Index(b, "=")
+			if i < 0 {
+				return fmt.Errorf("%q is not column=value", b)
+			}
+			v, err := parseValue(b[i+1:])
+			if err != nil {
+				return fmt.Errorf("column %s: %v", b[:i], err)
+			}
+			row[b[:i]] = v
+		}
+		return evalRow(w, s, n, row)
+	}
+
+	dec := json.NewDecoder(os.Stdin)
+	dec.UseNumber()
+	for line := 1; ; line++ {
+		var obj map[string]interface{}
+		if err := dec.Decode(&obj); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("row %d: %v", line, err)
+		}
+		row := make(map[string]interface{}, len(obj))
+		for k, v := range obj {
+			switch v := v.(type) {
+			case json.Number:
+				i, err := v.Int64()
+				if err != nil {
+					return fmt.Errorf("row %d: column %s: %v", line, k, err)
+				}
+				row[k] = i
+			case string:
+				b, err := base64.StdEncoding.DecodeString(v)
+				if err != nil {
+					return fmt.Errorf("row %d: column %s: %v", line, k, err)
+				}
+				row[k] = b
+			}
+		}
+		if err := evalRow(w, s, n, row); err != nil {
+			return fmt.Errorf("row %d: %v", line, err)
+		}
+	}
+}
+
+func parseValue(s string) (interface{}, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, nil
+	}
+	return readCiphertext(s)
+}
+
+func evalRow(w io.Writer, s *session, n *expr.Node, row map[string]interface{}) error {
+	v, err := s.eval(n, row)
+	if err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case []byte:
+		fmt.Fprintln(w, base64.StdEncoding.EncodeToString(v))
+	default:
+		fmt.Fprintln(w, v)
+	}
+	return nil
+}
+
+// eval returns a tagged ciphertext ([]byte) or a plain int64 for n.
+func (s *session) eval(n *expr.Node, row map[string]interface{}) (interface{}, error) {
+	switch n.Kind {
+	case expr.Lit:
+		return n.Lit, nil
+	case expr.Col:
+		v, ok := row[n.Col]
+		if !ok {
+			return nil, fmt.Errorf("no value for column %s", n.Col)
+		}
+		return v, nil
+	}
+
+	args := make([]interface{}, len(n.Args))
+	for i, a := range n.Args {
+		v, err := s.eval(a, row)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	name := strings.TrimPrefix(expr.FuncName(n.Fn), "fhe_")
+	switch name {
+	case "encrypt":
+		if len(args) != 1 {
+			return nil, errors.New("encrypt takes 1 argument")
+		}
+		v, ok := args[0].(int64)
+		if !ok {
+			return nil, errors.New("encrypt takes an integer")
+		}
+		return s.encrypt(v)
+
+	case "decrypt":
+		if len(args) != 1 {
+			return nil, errors.New("decrypt takes 1 argument")
+		}
+		ct, ok := args[0].([]byte)
+		if !ok {
+			return nil, errors.New("decrypt takes a ciphertext")
+		}
+		return s.decrypt(ct)
+
+	case "safe_convert_bytes_to_string":
+		// decrypt already returns an integer
+		if len(args) != 1 {
+			return nil, errors.New("safe_convert_bytes_to_string takes 1 argument")
+		}
+		return args[0], nil
+	}
+
+	if _, ok := fhe.Ops[name]; !ok {
+		return nil, fmt.Errorf("unknown function %s", n.Fn)
+	}
+	cts := make([][]byte, len(args))
+	for i, a := range args {
+		ct, ok := a.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("%s argument %d is not a ciphertext, wrap it in encrypt()", name, i+1)
+		}
+		cts[i] = ct
+	}
+	return s.apply(name, cts)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// fileJob streams the rows of one file through a per-row transform into
+// another, a chunk at a time. After each chunk the output is synced and a
+// checkpoint saved, so a run that dies can be resumed from the last chunk.
+type fileJob struct {
+	in, inFormat   string
+	out, outFormat string
+	checkpoint     string
+	resume, force  bool
+	chunk          int
+
+	// columns the transform works on; they must exist in CSV input and are
+	// typed binary in Avro output when binary is set
+	columns []string
+	binary  bool
+
+	pool *pool
+	row  func(s *session, r row) error
+
+	// fail is called for every row the transform failed on, in order; a
+	// non-nil return stops the job
+	fail func(n int64, r row, err error) error
+}
+
+// fileStats is what a fileJob did.
+type fileStats struct {
+	Resumed int64
+	Rows    int64
+	Failed  int64
+}
+
+func (j *fileJob) run() (*fileStats, error) {
+	var err error
+	if j.inFormat, err = fileFormat(j.inFormat, j.in); err != nil {
+		return nil, err
+	}
+	if j.outFormat, err = fileFormat(j.outFormat, j.out); err != nil {
+		return nil, err
+	}
+	if j.checkpoint == "" {
+		j.checkpoint = j.out + ".checkpoint"
+	}
+	if j.chunk <= 0 {
+		j.chunk = 1000
+	}
+
+	inAbs, _ := filepath.Abs(j.in)
+	outAbs, _ := filepath.Abs(j.out)
+	cp := &checkpoint{Input: inAbs, Output: outAbs, Format: j.outFormat, Columns: j.columns}
+
+	resumeFrom, err := loadCheckpoint(j.checkpoint)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		resumeFrom = nil
+	case err != nil:
+		return nil, err
+	case j.force:
+		resumeFrom = nil
+	case !j.resume:
+		return nil, fmt.Errorf("%s exists, use -resume to continue that run or -force to start over", j.checkpoint)
+	default:
+		if err := resumeFrom.matches(cp); err != nil {
+			return nil, fmt.Errorf("%s: %v", j.checkpoint, err)
+		}
+	}
+	if j.force {
+		if err := os.Remove(j.checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	} else if resumeFrom == nil {
+		if _, err := os.Stat(j.out); err == nil {
+			return nil, fmt.Errorf("%s exists, use -force to replace it", j.out)
+		}
+	}
+
+	inFile, err := os.Open(j.in)
+	if err != nil {
+		return nil, err
+	}
+	defer inFile.Close()
+	rr, err := newRowReader(inFile, j.inFormat)
+	if err != nil {
+		return nil, err
+	}
+	if cols := rr.Columns(); cols != nil {
+		have := make(map[string]bool)
+		for _, c := range cols {
+			have[c] = true
+		}
+		for _, c := range j.columns {
+			if !have[c] {
+				return nil, fmt.Errorf("%s has no column %s", j.in, c)
+			}
+		}
+	}
+
+	stats := &fileStats{}
+	var f *os.File
+	if resumeFrom != nil {
+		cp = resumeFrom
+		for ; stats.Resumed < cp.Rows; stats.Resumed++ {
+			if _, err := rr.Read(); err != nil {
+				return nil, fmt.Errorf("skipping to row %d: %v", cp.Rows+1, err)
+			}
+		}
+		if f, err = os.OpenFile(j.out, os.O_RDWR, 0); err != nil {
+			return nil, err
+		}
+		if fi, err := f.Stat(); err != nil || fi.Size() < cp.Offset {
+			f.Close()
+			return nil, fmt.Errorf("%s is shorter than %s says, start over with -force", j.out, j.checkpoint)
+		}
+		if err := f.Truncate(cp.Offset); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if _, err := f.Seek(cp.Offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+	} else if f, err = os.Create(j.out); err != nil {
+		return nil, err
+	}
+	// the writers close f too, a second Close is harmless
+	defer f.Close()
+
+	// Avro and CSV writers need the columns, which come from the first chunk
+	var w rowWriter
+	newWriter := func(rows []row) (rowWriter, error) {
+		switch j.outFormat {
+		case formatNDJSON:
+			return newNDJSONWriter(f), nil
+		case formatCSV:
+			header := cp.Header == nil
+			if header {
+				cp.Header = rowColumns(rows, rr.Columns())
+			}
+			return newCSVWriter(f, cp.Header, header)
+		}
+		if cp.Fields == nil {
+			var binary []string
+			if j.binary {
+				binary = j.columns
+			}
+			fields, err := inferAvroFields(rows, rr.Columns(), binary)
+			if err != nil {
+				return nil, err
+			}
+			cp.Fields = fields
+		} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+			// goavro reads the existing header and scans to the end itself
+			return nil, err
+		}
+		return newAvroWriter(f, cp.Fields)
+	}
+
+	n := cp.Rows
+	rows := make([]row, 0, j.chunk)
+	for done := false; !done; {
+		rows = rows[:0]
+		for len(rows) < j.chunk {
+			r, err := rr.Read()
+			if err == io.EOF {
+				done = true
+				break
+			}
+			if err != nil {
+				return stats, fmt.Errorf("row %d: %v", n+int64(len(rows))+1, err)
+			}
+			rows = append(rows, r)
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		errs := j.pool.run(len(rows), func(s *session, i int) error {
+			return j.row(s, rows[i])
+		})
+		for i, err := range errs {
+			if err == nil {
+				continue
+			}
+			stats.Failed++
+			if err := j.fail(n+int64(i)+1, rows[i], err); err != nil {
+				return stats, err
+			}
+		}
+
+		if w == nil {
+			if w, err = newWriter(rows); err != nil {
+				return stats, err
+			}
+		}
+		if err := w.Write(rows); err != nil {
+			return stats, err
+		}
+		if cp.Offset, err = w.Sync(); err != nil {
+			return stats, err
+		}
+		n += int64(len(rows))
+		stats.Rows += int64(len(rows))
+		cp.Rows = n
+		if err := cp.save(j.checkpoint); err != nil {
+			return stats, err
+		}
+	}
+
+	if w == nil {
+		// nothing (left) to do, still leave a valid file behind
+		if w, err = newWriter(nil); err != nil {
+			return stats, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return stats, err
+	}
+	if err := os.Remove(j.checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return stats, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"example.com/fhe"
+	"github.com/ldsec/lattigo/bfv"
+	"github.com/linkedin/goavro/v2"
+)
+
+// newTestSession writes a fresh key pair to dir and loads it.
+func newTestSession(t *testing.T, dir string) *session {
+	t.Helper()
+	sk, pk := bfv.NewKeyGenerator(fhe.DefaultParams()).GenKeyPair()
+	pub, _ := pk.MarshalBinary()
+	sec, _ := sk.MarshalBinary()
+	os.WriteFile(filepath.Join(dir, "pub.bin"), pub, 0600)
+	os.WriteFile(filepath.Join(dir, "sec.bin"), sec, 0600)
+	s, err := newSession(filepath.Join(dir, "pub.bin"), filepath.Join(dir, "sec.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// readOutput returns the rows of an NDJSON or Avro file with ciphertext
+// columns as []byte.
+func readOutput(t *testing.T, path string, cols []string) []map[string]interface{} {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var rows []map[string]interface{}
+	if strings.HasSuffix(path, ".avro") {
+		r, err := goavro.NewOCFReader(bufio.NewReader(f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for r.Scan() {
+			rec, err := r.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := make(map[string]interface{})
+			for k, v := range rec.(map[string]interface{}) {
+				// non-null union values decode as {"type": value}
+				if u, ok := v.(map[string]interface{}); ok {
+					for _, uv := range u {
+						v = uv
+					}
+				}
+				m[k] = v
+			}
+			rows = append(rows, m)
+		}
+		return rows
+	}
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, maxLine)
+	for sc.Scan() {
+		var m map[string]interface{}
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range cols {
+			if s, ok := m[c].(string); ok {
+				m[c], _ = base64.StdEncoding.DecodeString(s)
+			}
+		}
+		rows = append(rows, m)
+	}
+	return rows
+}
+
+func TestEncryptFileResume(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestSession(t, dir)
+
+	in := filepath.Join(dir, "in.csv")
+	var csv strings.Builder
+	csv.WriteString("uid,x,y\n")
+	for i := 1; i <= 25; i++ {
+		fmt.Fprintf(&csv, "u%d,%d,%d\n", i, i, -i)
+	}
+	csv.WriteString("u26,,7\n")
+	os.WriteFile(in, []byte(csv.String()), 0600)
+
+	for _, out := range []string{"out.ndjson", "out.avro"} {
+		out := filepath.Join(dir, out)
+		job := func(crashAt int64) *fileJob {
+			j := &fileJob{in: in, out: out, chunk: 10, columns: []string{"x", "y"}, binary: true, pool: newPool(s, 3)}
+			j.row = func(s *session, r row) error {
+				if r["uid"] == "u"+strconv.FormatInt(crashAt, 10) {
+					return errors.New("crash")
+				}
+				for _, c := range j.columns {
+					ct, err := encryptCell(s, r[c])
+					if err != nil {
+						return err
+					}
+					if ct == nil {
+						r[c] = nil
+					} else {
+						r[c] = ct
+					}
+				}
+				return nil
+			}
+			j.fail = func(n int64, r row, err error) error { return err }
+			return j
+		}
+
+		if _, err := job(15).run(); err == nil {
+			t.Fatalf("%s: first run did not fail", out)
+		}
+		if _, err := job(0).run(); err == nil {
+			t.Fatalf("%s: second run ignored the checkpoint", out)
+		}
+		j := job(0)
+		j.resume = true
+		stats, err := j.run()
+		if err != nil {
+			t.Fatalf("%s: resume: %v", out, err)
+		}
+		if stats.Resumed != 10 || stats.Rows != 16 {
+			t.Errorf("%s: resumed %d and did %d rows, want 10 and 16", out, stats.Resumed, stats.Rows)
+		}
+		if _, err := os.Stat(j.checkpoint); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: checkpoint left behind", out)
+		}
+
+		rows := readOutput(t, out, j.columns)
+		if len(rows) != 26 {
+			t.Fatalf("%s: %d rows, want 26", out, len(rows))
+		}
+		for i, r := range rows {
+			if r["uid"] != fmt.Sprintf("u%d", i+1) {
+				t.Fatalf("%s: row %d is %v", out, i, r["uid"])
+			}
+			if i == 25 {
+				if r["x"] != nil {
+					t.Errorf("%s: empty cell encrypted", out)
+				}
+				continue
+			}
+			for c, want := range map[string]int64{"x": int64(i + 1), "y": -int64(i + 1)} {
+				b, _ := r[c].([]byte)
+				got, err := s.decrypt(b)
+				if err != nil || got != want {
+					t.Errorf("%s: row %d %s = %d, %v, want %d", out, i, c, got, err, want)
+				}
+			}
+		}
+	}
+}
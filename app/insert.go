@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+func runInsert(args []string) error {
+	fs := newFlagSet("insert", "[column=value ...]")
+	sf := newSinkFlags(fs)
+	pub := pubFlag(fs)
+	create := fs.Bool("create", false, "create the dataset and table, or file, first")
+	in := fs.String("in", "", "CSV or NDJSON file of rows to insert instead of column=value arguments")
+	inFormat := fs.String("in-format", "", "csv or ndjson (default from the -in extension)")
+	batch := fs.Int("batch", 500, "rows per request")
+	workers := fs.Int("workers", runtime.NumCPU(), "rows encrypted in parallel")
+	fs.Parse(args)
+
+	cfg, err := sf.load()
+	if err != nil {
+		return err
+	}
+	s, err := newSession(*pub, "")
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	sk, err := cfg.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer sk.Close()
+	if *create {
+		if err := sk.Create(ctx); err != nil {
+			return err
+		}
+	}
+
+	var rr rowReader
+	if *in != "" {
+		format, err := fileFormat(*inFormat, *in)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if rr, err = newRowReader(f, format); err != nil {
+			return err
+		}
+	} else {
+		r := make(row)
+		for _, a := range fs.Args() {
+			i := strings.Index(a, "=")
+			if i < 0 {
+				return fmt.Errorf("%q is not column=value", a)
+			}
+			r[a[:i]] = a[i+1:]
+		}
+		rr = &singleRow{r: r}
+	}
+
+	p := newPool(s, *workers)
+	var n int
+	for {
+		rows, err := readRows(rr, *batch)
+		if err != nil {
+			return fmt.Errorf("row %d: %v", n+len(rows)+1, err)
+		}
+		if len(rows) == 0 {
+			break
+		}
+		errs := p.run(len(rows), func(s *session, i int) error {
+			r := rows[i]
+			if hasColumn(cfg.Schema, "uid") && (r["uid"] == nil || r["uid"] == "") {
+				r["uid"] = uuid.NewString()
+			}
+			return prepareRow(s, cfg.Schema, r)
+		})
+		for i, err := range errs {
+			if err != nil {
+				return fmt.Errorf("row %d: %v", n+i+1, err)
+			}
+		}
+		if err := sk.Put(ctx, rows); err != nil {
+			return err
+		}
+		n += len(rows)
+	}
+	fmt.Fprintf(os.Stderr, "inserted %d rows\n", n)
+	return nil
+}
+
+// readRows reads up to max rows; it returns no rows at the end.
+func readRows(rr rowReader, max int) ([]row, error) {
+	var rows []row
+	for len(rows) < max {
+		r, err := rr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return rows, err
+		}
+		rows = append(rows, r)
+	}
+	return rows, nil
+}
+
+// singleRow is the row given as column=value arguments.
+type singleRow struct {
+	r    row
+	done bool
+}
+
+func (s *singleRow) Columns() []string { return nil }
+
+func (s *singleRow) Read() (row, error) {
+	if s.done {
+		return nil, io.EOF
+	}
+	s.done = true
+	return s.r, nil
+}
+
+func runCreateTable(args []string) error {
+	fs := newFlagSet("create-table", "")
+	sf := newSinkFlags(fs)
+	fs.Parse(args)
+
+	cfg, err := sf.load()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	sk, err := cfg.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer sk.Close()
+	if err := sk.Create(ctx); err != nil {
+		return err
+	}
+	if cfg.Sink == sinkBigQuery {
+		fmt.Fprintf(os.Stderr, "created %s.%s.%s\n", cfg.Project, cfg.Dataset, cfg.Table)
+	} else {
+		fmt.Fprintf(os.Stderr, "created %s\n", cfg.Path)
+	}
+	return nil
+}
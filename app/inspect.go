@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"example.com/fhe"
+)
+
+// ciphertextInfo is printed by inspect, one JSON object per ciphertext.
+type ciphertextInfo struct {
+	Bytes int `json:"bytes"`
+
+	Tagged   bool   `json:"tagged"`
+	MACKeyID string `json:"macKeyId,omitempty"`
+	// only set when FHE_MAC_KEY or FHE_MAC_VERIFY_KEYS is
+	Verified *bool `json:"verified,omitempty"`
+
+	Degree int    `json:"degree"`
+	NTT    bool   `json:"ntt"`
+	LogN   int    `json:"logN"`
+	Moduli int    `json:"moduli"`
+	Params string `json:"params,omitempty"`
+
+	// with -sec
+	Value *int64 `json:"value,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+func runInspect(args []string) error {
+	fs := newFlagSet("inspect", "[base64 ciphertext | @file ...]")
+	sec := fs.String("sec", "", "secret key file or URL to also decrypt the value")
+	fs.Parse(args)
+
+	s, err := newSession("", *sec)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	return eachInput(fs.Args(), os.Stdin, func(in string) error {
+		data, err := readCiphertext(in)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(s.inspect(data))
+	})
+}
+
+func (s *session) inspect(data []byte) *ciphertextInfo {
+	info := &ciphertextInfo{Bytes: len(data)}
+	keyID, ct, tagged := fhe.ParseTag(data)
+	info.Tagged = tagged
+	info.MACKeyID = keyID
+	if s.mac != nil {
+		_, err := s.mac.Open(data)
+		ok := err == nil
+		info.Verified = &ok
+	}
+
+	h, err := fhe.ParseCiphertextHeader(ct)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.Degree = h.Degree
+	info.NTT = h.NTT
+	info.LogN = h.LogN
+	info.Moduli = h.Moduli
+
+	if _, err := fhe.UnmarshalCiphertext(s.params, ct); err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.Params = fhe.DefaultParamsName
+
+	if s.decryptor != nil {
+		v, err := s.decryptRaw(ct)
+		if err != nil {
+			info.Error = err.Error()
+			return info
+		}
+		info.Value = &v
+	}
+	return info
+}
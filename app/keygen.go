@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"example.com/fhe"
+	"github.com/ldsec/lattigo/bfv"
+)
+
+type keyFile struct {
+	name string
+	data []byte
+	perm os.FileMode
+}
+
+func runKeygen(args []string) error {
+	fs := newFlagSet("keygen", "")
+	out := fs.String("out", ".", "directory to write pub.bin, pub.b64, sec.bin and sec.b64 to")
+	eval := fs.Bool("eval", true, "also write the relinearization (rlk) and rotation (rot) keys fhe_distance2 and fhe_nearest need")
+	eq := fs.Bool("eq", false, "write the "+fhe.EqParamsName+" keys fhe_eq needs instead, as eq-pub, eq-sec and eq-rlk")
+	force := fs.Bool("force", false, "overwrite existing keys")
+	fs.Parse(args)
+
+	params, paramsName, prefix := fhe.DefaultParams(), fhe.DefaultParamsName, ""
+	if *eq {
+		params, paramsName, prefix = fhe.EqParams(), fhe.EqParamsName, "eq-"
+	}
+	names := []string{"pub.bin", "pub.b64", "sec.bin", "sec.b64"}
+	if *eval || *eq {
+		names = append(names, "rlk.bin", "rlk.b64")
+	}
+	if *eval && !*eq {
+		names = append(names, "rot.bin", "rot.b64")
+	}
+	files := map[string]string{}
+	for _, name := range names {
+		files[name] = filepath.Join(*out, prefix+name)
+		if _, err := os.Stat(files[name]); err == nil && !*force {
+			return fmt.Errorf("%s exists, use -force to replace it", files[name])
+		}
+	}
+
+	kg := bfv.NewKeyGenerator(params)
+	sk, pk := kg.GenKeyPair()
+	pub, err := pk.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	sec, err := sk.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	id, err := fhe.KeyID(pk)
+	if err != nil {
+		return err
+	}
+
+	write := []keyFile{
+		{"pub.bin", pub, 0644},
+		{"pub.b64", []byte(base64.StdEncoding.EncodeToString(pub)), 0644},
+		{"sec.bin", sec, 0600},
+		{"sec.b64", []byte(base64.StdEncoding.EncodeToString(sec)), 0600},
+	}
+	if *eq {
+		rlk, err := kg.GenRelinKey(sk, fhe.RelinDegree-1).MarshalBinary()
+		if err != nil {
+			return err
+		}
+		write = append(write, []keyFile{
+			{"rlk.bin", rlk, 0644},
+			{"rlk.b64", []byte(base64.StdEncoding.EncodeToString(rlk)), 0644},
+		}...)
+	} else if *eval {
+		keys := fhe.GenEvalKeys(params, sk)
+		rlk, err := keys.Relin.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		rot, err := keys.Rotation.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		write = append(write, []keyFile{
+			{"rlk.bin", rlk, 0644},
+			{"rlk.b64", []byte(base64.StdEncoding.EncodeToString(rlk)), 0644},
+			{"rot.bin", rot, 0644},
+			{"rot.b64", []byte(base64.StdEncoding.EncodeToString(rot)), 0644},
+		}...)
+	}
+	for _, f := range write {
+		if err := os.WriteFile(files[f.name], f.data, f.perm); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("params  %s\n", paramsName)
+	fmt.Printf("logN    %d\n", params.LogN)
+	fmt.Printf("T       %d\n", params.T)
+	fmt.Printf("qi      %v\n", params.Qi)
+	fmt.Printf("pi      %v\n", params.Pi)
+	fmt.Printf("logQP   %d\n", params.LogQP())
+	fmt.Printf("sigma   %v\n", params.Sigma)
+	fmt.Printf("key id  %s\n", id)
+	fmt.Printf("public  %s %s\n", files["pub.bin"], files["pub.b64"])
+	fmt.Printf("secret  %s %s\n", files["sec.bin"], files["sec.b64"])
+	if *eval || *eq {
+		fmt.Printf("relin   %s %s\n", files["rlk.bin"], files["rlk.b64"])
+	}
+	if *eval && !*eq {
+		fmt.Printf("rotate  %s %s\n", files["rot.bin"], files["rot.b64"])
+	}
+	return nil
+}
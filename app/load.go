@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/fhe"
+	"example.com/fhe/bq"
+	"github.com/google/uuid"
+)
+
+func runLoad(args []string) error {
+	fs := newFlagSet("load", "")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `usage: fhe load [flags]
+
+Sends requests shaped like BigQuery's to a remote function at -url, -batch
+rows each with -concurrency in flight, until -requests have been sent or
+-duration has passed, and prints the latency percentiles and throughput.
+The rows are ciphertexts encrypted under -pub, or integers for -mode
+encrypt, so the function does what it would for a real query. For Cloud Run
+set -token to the output of gcloud auth print-identity-token.
+
+`)
+		fs.PrintDefaults()
+	}
+	url := fs.String("url", "http://localhost:8080", "URL of the remote function")
+	mode := fs.String("mode", "add", "mode to send in the userDefinedContext, eg add, mul, encrypt or decrypt")
+	nargs := fs.Int("args", 0, "arguments per row, for a mode fhe load doesn't know")
+	udc := fs.String("context", "", "JSON object of more userDefinedContext entries, eg the lookup table for lut")
+	batch := fs.Int("batch", 50, "rows per request, like max_batching_rows")
+	concurrency := fs.Int("concurrency", 4, "requests in flight")
+	requests := fs.Int("requests", 0, "stop after this many requests, 0 for no limit")
+	duration := fs.Duration("duration", 30*time.Second, "stop after this long, 0 for no limit")
+	distinct := fs.Int("distinct", 16, "distinct ciphertexts to fill the rows with")
+	token := fs.String("token", "", "bearer token for the Authorization header")
+	pub := pubFlag(fs)
+	fs.Parse(args)
+	if *batch < 1 || *concurrency < 1 || *distinct < 1 {
+		return errors.New("-batch, -concurrency and -distinct must be positive")
+	}
+	if *requests <= 0 && *duration <= 0 {
+		return errors.New("set -requests or -duration, or it runs forever")
+	}
+	if *nargs == 0 {
+		var ok bool
+		if *nargs, ok = modeArgs(*mode); !ok {
+			return fmt.Errorf("unknown mode %s, set -args", *mode)
+		}
+	}
+
+	cfg := &loadConfig{
+		url:         *url,
+		token:       *token,
+		udc:         map[string]string{},
+		concurrency: *concurrency,
+		requests:    *requests,
+		duration:    *duration,
+	}
+	if *udc != "" {
+		if err := json.Unmarshal([]byte(*udc), &cfg.udc); err != nil {
+			return fmt.Errorf("-context: %v", err)
+		}
+	}
+	cfg.udc[bq.ModeContextKey] = *mode
+
+	var s *session
+	if *mode != "encrypt" {
+		var err error
+		if s, err = newSession(*pub, ""); err != nil {
+			return err
+		}
+	}
+	calls, err := loadCalls(s, *mode == "encrypt", *nargs, *batch, *distinct)
+	if err != nil {
+		return err
+	}
+	if cfg.calls, err = json.Marshal(calls); err != nil {
+		return err
+	}
+	cfg.rows = len(calls)
+
+	fmt.Printf("sending %s requests of %d rows to %s, %d at a time\n", *mode, *batch, *url, *concurrency)
+	res := runLoadTest(cfg)
+	res.print(os.Stdout, cfg.rows)
+	if res.ok() == 0 {
+		return errors.New("no request succeeded")
+	}
+	return nil
+}
+
+// modeArgs is how many arguments a row of mode takes, if fhe load knows.
+func modeArgs(mode string) (int, bool) {
+	if op, ok := fhe.Ops[mode]; ok {
+		return op.Args, true
+	}
+	switch mode {
+	case "encrypt", "decrypt", "refresh", "rerandomize", "lut", "aggregate":
+		return 1, true
+	}
+	return 0, false
+}
+
+// loadCalls returns batch rows of args each. They are integers if plain,
+// which is what fhe_encrypt takes, or else base64 ciphertexts of small
+// integers encrypted with s, distinct of them used in turn.
+func loadCalls(s *session, plain bool, args, batch, distinct int) ([][]interface{}, error) {
+	values := make([]interface{}, distinct)
+	for i := range values {
+		v := int64(i%7 + 1)
+		if plain {
+			values[i] = v
+			continue
+		}
+		ct, err := s.encrypt(v)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = base64.StdEncoding.EncodeToString(ct)
+	}
+	calls := make([][]interface{}, batch)
+	for i := range calls {
+		calls[i] = make([]interface{}, args)
+		for j := range calls[i] {
+			calls[i][j] = values[(i*args+j)%distinct]
+		}
+	}
+	return calls, nil
+}
+
+// loadConfig is what fhe load sends and for how long.
+type loadConfig struct {
+	url   string
+	token string
+	udc   map[string]string
+	// the JSON of every request's calls, which would take longer to
+	// marshal each time than many functions take to answer
+	calls       []byte
+	rows        int
+	concurrency int
+	requests    int
+	duration    time.Duration
+}
+
+// loadResult is what the requests of a run got back.
+type loadResult struct {
+	elapsed time.Duration
+	// of the requests answered with every reply, sorted
+	latencies []time.Duration
+	// the other requests by HTTP status, or "error" if they got none
+	failed map[string]int
+	// the last reason a request failed
+	lastErr error
+}
+
+func (r *loadResult) ok() int {
+	return len(r.latencies)
+}
+
+// runLoadTest sends requests until cfg says to stop. Requests still in
+// flight at the end of cfg.duration are cancelled and left out.
+func runLoadTest(cfg *loadConfig) *loadResult {
+	ctx := context.Background()
+	if cfg.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.duration)
+		defer cancel()
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = cfg.concurrency
+	client := &http.Client{Transport: transport}
+	caller := "//bigquery.googleapis.com/projects/fhe-load/jobs/" + uuid.NewString()
+
+	var (
+		sent atomic.Int64
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		res  = &loadResult{failed: make(map[string]int)}
+	)
+	start := time.Now()
+	for i := 0; i < cfg.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil && (cfg.requests <= 0 || sent.Add(1) <= int64(cfg.requests)) {
+				t := time.Now()
+				status, err := sendLoad(ctx, client, cfg, caller)
+				latency := time.Since(t)
+				if ctx.Err() != nil {
+					return
+				}
+				mu.Lock()
+				if err != nil {
+					res.failed[status]++
+					res.lastErr = err
+				} else {
+					res.latencies = append(res.latencies, latency)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	res.elapsed = time.Since(start)
+	sort.Slice(res.latencies, func(i, j int) bool { return res.latencies[i] < res.latencies[j] })
+	return res
+}
+
+// sendLoad sends one request and checks it got a reply for every row. The
+// status names the failure, if there is one.
+func sendLoad(ctx context.Context, client *http.Client, cfg *loadConfig, caller string) (status string, _ error) {
+	head, err := json.Marshal(&bq.Request{
+		RequestId:          uuid.NewString(),
+		Caller:             caller,
+		SessionUser:        "load@example.com",
+		UserDefinedContext: cfg.udc,
+	})
+	if err != nil {
+		return "error", err
+	}
+	// the calls go in place of the null ones head ends with
+	head = bytes.TrimSuffix(head, []byte("null}"))
+	body := io.MultiReader(bytes.NewReader(head), bytes.NewReader(cfg.calls), strings.NewReader("}"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.url, body)
+	if err != nil {
+		return "error", err
+	}
+	req.ContentLength = int64(len(head) + len(cfg.calls) + 1)
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "error", err
+	}
+	defer resp.Body.Close()
+	status = strconv.Itoa(resp.StatusCode)
+	var bqResp bq.Response
+	if err := json.NewDecoder(resp.Body).Decode(&bqResp); err != nil {
+		return status, fmt.Errorf("%s: %v", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || bqResp.ErrorMessage != "" {
+		return status, fmt.Errorf("%s: %s", resp.Status, bqResp.ErrorMessage)
+	}
+	if len(bqResp.Replies) != cfg.rows {
+		return status, fmt.Errorf("%d replies for %d rows", len(bqResp.Replies), cfg.rows)
+	}
+	return status, nil
+}
+
+// percentile returns the p-th percentile of sorted by the nearest rank.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p/100*float64(len(sorted))+0.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+func (r *loadResult) print(w io.Writer, batch int) {
+	secs := r.elapsed.Seconds()
+	fmt.Fprintf(w, "%d requests of %d rows succeeded in %v\n", r.ok(), batch, r.elapsed.Round(time.Millisecond))
+	if len(r.failed) > 0 {
+		var failed []string
+		for status, n := range r.failed {
+			failed = append(failed, fmt.Sprintf("%d with %s", n, status))
+		}
+		sort.Strings(failed)
+		fmt.Fprintf(w, "failed: %s, the last with %v\n", strings.Join(failed, ", "), r.lastErr)
+	}
+	fmt.Fprintf(w, "throughput: %.2f requests/s, %.1f rows/s\n", float64(r.ok())/secs, float64(r.ok()*batch)/secs)
+	fmt.Fprintf(w, "latency: p50 %v, p90 %v, p99 %v, max %v\n",
+		percentile(r.latencies, 50).Round(time.Millisecond),
+		percentile(r.latencies, 90).Round(time.Millisecond),
+		percentile(r.latencies, 99).Round(time.Millisecond),
+		percentile(r.latencies, 100).Round(time.Millisecond))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/fhe/bq"
+)
+
+func TestLoad(t *testing.T) {
+	s := newTestSession(t, t.TempDir())
+	calls, err := loadCalls(s, false, 2, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(calls)
+
+	// every third request is shed, as a function over its row budget does
+	var (
+		n  atomic.Int64
+		mu sync.Mutex // s is used by one request at a time
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req bq.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		if req.UserDefinedContext[bq.ModeContextKey] != "add" || req.RequestId == "" || r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("request %s with context %v", req.RequestId, req.UserDefinedContext)
+		}
+		if n.Add(1)%3 == 0 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(bq.Response{ErrorMessage: "shed"})
+			return
+		}
+		resp := bq.Response{}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, row := range req.Calls {
+			ct, err := base64.StdEncoding.DecodeString(row[0].(string))
+			if err != nil {
+				t.Error(err)
+			}
+			v, err := s.decrypt(ct)
+			if err != nil {
+				t.Error(err)
+			}
+			resp.Replies = append(resp.Replies, strconv.FormatInt(v, 10))
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	res := runLoadTest(&loadConfig{
+		url:         srv.URL,
+		token:       "token",
+		udc:         map[string]string{bq.ModeContextKey: "add"},
+		calls:       body,
+		rows:        len(calls),
+		concurrency: 3,
+		requests:    9,
+		duration:    time.Minute,
+	})
+	if res.ok() != 6 || res.failed["429"] != 3 || !strings.Contains(res.lastErr.Error(), "shed") {
+		t.Errorf("%d succeeded, %v failed with %v; want 6 and 3 with 429", res.ok(), res.failed, res.lastErr)
+	}
+	var out strings.Builder
+	res.print(&out, len(calls))
+	if !strings.Contains(out.String(), "6 requests of 5 rows") || !strings.Contains(out.String(), "3 with 429") {
+		t.Errorf("printed\n%s", out.String())
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+	for p, want := range map[float64]time.Duration{50: 50, 90: 90, 99: 99, 100: 100, 0: 1} {
+		if got := percentile(sorted, p); got != want {
+			t.Errorf("p%v = %d, want %d", p, got, want)
+		}
+	}
+	if got := percentile(sorted[:1], 99); got != 1 {
+		t.Errorf("p99 of one = %d", got)
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("p50 of none = %d", got)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import "sync"
+
+// pool runs per-row work on a fixed set of sessions, one goroutine each.
+type pool struct {
+	sessions []*session
+}
+
+func newPool(s *session, workers int) *pool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &pool{sessions: []*session{s}}
+	for len(p.sessions) < workers {
+		p.sessions = append(p.sessions, s.fork())
+	}
+	return p
+}
+
+// run calls f for every i in [0, n) and returns the error for each, nil if
+// all succeeded.
+func (p *pool) run(n int, f func(s *session, i int) error) []error {
+	var (
+		errs   []error
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		next   = make(chan int)
+		failed bool
+	)
+	for _, s := range p.sessions {
+		wg.Add(1)
+		go func(s *session) {
+			defer wg.Done()
+			for i := range next {
+				if err := f(s, i); err != nil {
+					mu.Lock()
+					if errs == nil {
+						errs = make([]error, n)
+					}
+					errs[i] = err
+					failed = true
+					mu.Unlock()
+				}
+			}
+		}(s)
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	if !failed {
+		return nil
+	}
+	return errs
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// row is one record of a file. Values are strings from CSV, whatever
+// encoding/json decodes with UseNumber from NDJSON, and []byte for
+// ciphertexts.
+type row map[string]interface{}
+
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+	formatAvro   = "avro"
+)
+
+// fileFormat returns format, or guesses it from the extension of path.
+func fileFormat(format string, path string) (string, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			format = formatCSV
+		case ".avro":
+			format = formatAvro
+		case ".json", ".ndjson", ".jsonl":
+			format = formatNDJSON
+		default:
+			return "", fmt.Errorf("can't tell the format of %q, set it with a flag", path)
+		}
+	}
+	switch format {
+	case formatCSV, formatNDJSON, formatAvro:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown format %q", format)
+}
+
+type rowReader interface {
+	// Read returns io.EOF after the last row.
+	Read() (row, error)
+	// Columns lists the columns known up front, nil for NDJSON.
+	Columns() []string
+}
+
+func newRowReader(r io.Reader, format string) (rowReader, error) {
+	switch format {
+	case formatCSV:
+		cr := csv.NewReader(r)
+		cr.ReuseRecord = true
+		header, err := cr.Read()
+		if err != nil {
+			return nil, fmt.Errorf("reading CSV header: %v", err)
+		}
+		return &csvReader{r: cr, header: append([]string(nil), header...)}, nil
+	case formatNDJSON:
+		dec := json.NewDecoder(bufio.NewReaderSize(r, 1<<20))
+		dec.UseNumber()
+		return &ndjsonReader{dec: dec}, nil
+	case formatAvro:
+		return newAvroReader(r)
+	}
+	return nil, fmt.Errorf("can't read %s", format)
+}
+
+type csvReader struct {
+	r      *csv.Reader
+	header []string
+}
+
+func (c *csvReader) Columns() []string { return c.header }
+
+func (c *csvReader) Read() (row, error) {
+	rec, err := c.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(rec) != len(c.header) {
+		return nil, fmt.Errorf("%d fields for %d columns", len(rec), len(c.header))
+	}
+	r := make(row, len(rec))
+	for i, v := range rec {
+		r[c.header[i]] = v
+	}
+	return r, nil
+}
+
+type ndjsonReader struct {
+	dec *json.Decoder
+}
+
+func (n *ndjsonReader) Columns() []string { return nil }
+
+func (n *ndjsonReader) Read() (row, error) {
+	var r row
+	if err := n.dec.Decode(&r); err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, errors.New("row is null")
+	}
+	return r, nil
+}
+
+// avroReader reads an Avro object container file such as a BigQuery
+// export. Nullable columns are unions, whose values are unwrapped.
+type avroReader struct {
+	ocf     *goavro.OCFReader
+	columns []string
+	unions  map[string]bool
+}
+
+func newAvroReader(r io.Reader) (*avroReader, error) {
+	ocf, err := goavro.NewOCFReader(bufio.NewReaderSize(r, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	var schema struct {
+		Type   string `json:"type"`
+		Fields []struct {
+			Name string          `json:"name"`
+			Type json.RawMessage `json:"type"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(ocf.Codec().Schema()), &schema); err != nil || schema.Type != "record" {
+		return nil, errors.New("Avro schema is not a record")
+	}
+	a := &avroReader{ocf: ocf, unions: make(map[string]bool)}
+	for _, f := range schema.Fields {
+		a.columns = append(a.columns, f.Name)
+		a.unions[f.Name] = len(f.Type) > 0 && f.Type[0] == '['
+	}
+	return a, nil
+}
+
+func (a *avroReader) Columns() []string { return a.columns }
+
+func (a *avroReader) Read() (row, error) {
+	if !a.ocf.Scan() {
+		if err := a.ocf.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	v, err := a.ocf.Read()
+	if err != nil {
+		return nil, err
+	}
+	rec, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("record is not a map")
+	}
+	r := make(row, len(rec))
+	for k, v := range rec {
+		if u, ok := v.(map[string]interface{}); ok && a.unions[k] {
+			for _, uv := range u {
+				v = uv
+			}
+		}
+		r[k] = v
+	}
+	return r, nil
+}
+
+type rowWriter interface {
+	Write(rows []row) error
+	// Sync flushes everything written to disk and returns the size of the
+	// output, which is where a resumed run truncates it to.
+	Sync() (int64, error)
+	Close() error
+}
+
+// ndjsonWriter writes one JSON object per line; []byte values come out as
+// base64 strings, which is how bq load reads BYTES from JSON.
+type ndjsonWriter struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+func newNDJSONWriter(f *os.File) *ndjsonWriter {
+	return &ndjsonWriter{f: f, w: bufio.NewWriterSize(f, 1<<20)}
+}
+
+func (n *ndjsonWriter) Write(rows []row) error {
+	for _, r := range rows {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		n.w.Write(b)
+		if err := n.w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *ndjsonWriter) Sync() (int64, error) {
+	if err := n.w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := n.f.Sync(); err != nil {
+		return 0, err
+	}
+	return n.f.Seek(0, io.SeekCurrent)
+}
+
+func (n *ndjsonWriter) Close() error {
+	if err := n.w.Flush(); err != nil {
+		n.f.Close()
+		return err
+	}
+	return n.f.Close()
+}
+
+// csvWriter writes a header and then one record per row.
+type csvWriter struct {
+	f       *os.File
+	w       *bufio.Writer
+	c       *csv.Writer
+	columns []string
+}
+
+// newCSVWriter writes the header unless f is being appended to.
+func newCSVWriter(f *os.File, columns []string, header bool) (*csvWriter, error) {
+	w := bufio.NewWriterSize(f, 1<<20)
+	c := &csvWriter{f: f, w: w, c: csv.NewWriter(w), columns: columns}
+	if header {
+		if err := c.c.Write(columns); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *csvWriter) Write(rows []row) error {
+	rec := make([]string, len(c.columns))
+	for _, r := range rows {
+		for i, col := range c.columns {
+			s, err := cellString(r[col])
+			if err != nil {
+				return fmt.Errorf("column %s: %v", col, err)
+			}
+			rec[i] = s
+		}
+		if len(r) > len(c.columns) {
+			for col := range r {
+				if !c.has(col) {
+					return fmt.Errorf("column %s is not in the CSV header", col)
+				}
+			}
+		}
+		if err := c.c.Write(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *csvWriter) has(col string) bool {
+	for _, h := range c.columns {
+		if h == col {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *csvWriter) Sync() (int64, error) {
+	c.c.Flush()
+	if err := c.c.Error(); err != nil {
+		return 0, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := c.f.Sync(); err != nil {
+		return 0, err
+	}
+	return c.f.Seek(0, io.SeekCurrent)
+}
+
+func (c *csvWriter) Close() error {
+	if _, err := c.Sync(); err != nil {
+		c.f.Close()
+		return err
+	}
+	return c.f.Close()
+}
+
+// rowColumns returns columns if the input format has a header or schema, and
+// otherwise every column that appears in rows, sorted.
+func rowColumns(rows []row, columns []string) []string {
+	if columns != nil {
+		return columns
+	}
+	seen := make(map[string]bool)
+	for _, r := range rows {
+		for c := range r {
+			if !seen[c] {
+				seen[c] = true
+				columns = append(columns, c)
+			}
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// avroField is a nullable column of an Avro record.
+type avroField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+var avroName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// inferAvroFields picks a type for every column of rows: bytes for the
+// columns in binary, and for the rest whatever the first non-null value
+// looks like. CSV values are all strings.
+func inferAvroFields(rows []row, columns []string, binary []string) ([]avroField, error) {
+	columns = rowColumns(rows, columns)
+	isBinary := make(map[string]bool)
+	for _, c := range binary {
+		isBinary[c] = true
+	}
+
+	fields := make([]avroField, 0, len(columns))
+	for _, c := range columns {
+		if !avroName.MatchString(c) {
+			return nil, fmt.Errorf("column %q is not a valid Avro name", c)
+		}
+		typ := "string"
+		if isBinary[c] {
+			typ = "bytes"
+		} else {
+			for _, r := range rows {
+				if v := r[c]; v != nil {
+					typ = avroType(v)
+					break
+				}
+			}
+		}
+		fields = append(fields, avroField{Name: c, Type: typ})
+	}
+	return fields, nil
+}
+
+func avroType(v interface{}) string {
+	switch v := v.(type) {
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "long"
+		}
+		return "double"
+	case int64, int32, int:
+		return "long"
+	case float64, float32:
+		return "double"
+	case []byte:
+		return "bytes"
+	}
+	return "string"
+}
+
+func avroSchema(fields []avroField) string {
+	type unionField struct {
+		Name    string      `json:"name"`
+		Type    []string    `json:"type"`
+		Default interface{} `json:"default"`
+	}
+	s := struct {
+		Type   string       `json:"type"`
+		Name   string       `json:"name"`
+		Fields []unionField `json:"fields"`
+	}{Type: "record", Name: "row"}
+	for _, f := range fields {
+		s.Fields = append(s.Fields, unionField{Name: f.Name, Type: []string{"null", f.Type}})
+	}
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// avroWriter writes an Avro object container file, one block per Write.
+// bq load takes bytes fields as BYTES.
+type avroWriter struct {
+	f      *os.File
+	fields []avroField
+	ocf    *goavro.OCFWriter
+}
+
+// newAvroWriter appends to f if it already holds an OCF header, which must
+// have been written with fields.
+func newAvroWriter(f *os.File, fields []avroField) (*avroWriter, error) {
+	codec, err := goavro.NewCodec(avroSchema(fields))
+	if err != nil {
+		return nil, err
+	}
+	ocf, err := goavro.NewOCFWriter(goavro.OCFConfig{
+		W:               f,
+		Codec:           codec,
+		CompressionName: goavro.CompressionSnappyLabel,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &avroWriter{f: f, fields: fields, ocf: ocf}, nil
+}
+
+func (a *avroWriter) Write(rows []row) error {
+	data := make([]interface{}, len(rows))
+	for i, r := range rows {
+		rec := make(map[string]interface{}, len(a.fields))
+		for _, f := range a.fields {
+			v, err := avroValue(f, r[f.Name])
+			if err != nil {
+				return err
+			}
+			rec[f.Name] = v
+		}
+		for c := range r {
+			if _, ok := rec[c]; !ok {
+				return fmt.Errorf("column %s is not in the Avro schema", c)
+			}
+		}
+		data[i] = rec
+	}
+	return a.ocf.Append(data)
+}
+
+func avroValue(f avroField, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	var native interface{}
+	var err error
+	switch f.Type {
+	case "bytes":
+		b, ok := v.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("column %s: want bytes, got %T", f.Name, v)
+		}
+		native = b
+	case "long":
+		switch v := v.(type) {
+		case json.Number:
+			native, err = v.Int64()
+		case int64:
+			native = v
+		case int32:
+			native = int64(v)
+		case int:
+			native = int64(v)
+		default:
+			err = fmt.Errorf("want an integer, got %T", v)
+		}
+	case "double":
+		switch v := v.(type) {
+		case json.Number:
+			native, err = v.Float64()
+		case float64:
+			native = v
+		case float32:
+			native = float64(v)
+		default:
+			err = fmt.Errorf("want a number, got %T", v)
+		}
+	case "boolean":
+		b, ok := v.(bool)
+		if !ok {
+			err = fmt.Errorf("want a boolean, got %T", v)
+		}
+		native = b
+	default:
+		native, err = cellString(v)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("column %s: %v", f.Name, err)
+	}
+	return goavro.Union(f.Type, native), nil
+}
+
+// cellString formats v for a string column. Bytes are base64 as in
+// BigQuery's CSV and JSON exports, objects and arrays are kept as JSON.
+func cellString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v), nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (a *avroWriter) Sync() (int64, error) {
+	if err := a.f.Sync(); err != nil {
+		return 0, err
+	}
+	return a.f.Seek(0, io.SeekCurrent)
+}
+
+func (a *avroWriter) Close() error {
+	return a.f.Close()
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"example.com/fhe"
+	"github.com/ldsec/lattigo/bfv"
+)
+
+// session holds the keys and lattigo objects for one command. Every step
+// does what the matching remote function does, including opening and sealing
+// the MAC envelope, so its output is interchangeable with theirs.
+type session struct {
+	params    *bfv.Parameters
+	encoder   bfv.Encoder
+	evaluator bfv.Evaluator
+	mac       *fhe.MAC
+
+	// nil unless a key was given
+	pk        *bfv.PublicKey
+	sk        *bfv.SecretKey
+	encryptor bfv.Encryptor
+	decryptor bfv.Decryptor
+	keys      *fhe.EvalKeys
+}
+
+// newSession loads the keys at pub and sec; either may be empty.
+func newSession(pub string, sec string) (*session, error) {
+	params := fhe.DefaultParams()
+	mac, err := fhe.NewMACFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	s := &session{
+		params:    params,
+		encoder:   bfv.NewEncoder(params),
+		evaluator: bfv.NewEvaluator(params),
+		mac:       mac,
+		keys:      &fhe.EvalKeys{},
+	}
+
+	if pub != "" {
+		b, err := fhe.ReadKey(context.Background(), pub)
+		if err != nil {
+			return nil, err
+		}
+		pk, err := fhe.UnmarshalPublicKey(params, b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", pub, err)
+		}
+		s.pk = pk
+		s.encryptor = bfv.NewEncryptorFromPk(params, pk)
+	}
+	if sec != "" {
+		b, err := fhe.ReadKey(context.Background(), sec)
+		if err != nil {
+			return nil, err
+		}
+		sk, err := fhe.UnmarshalSecretKey(params, b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", sec, err)
+		}
+		s.sk = sk
+		s.decryptor = bfv.NewDecryptor(params, sk)
+	}
+	return s, nil
+}
+
+// fork returns a session with the same keys and its own lattigo objects,
+// which keep scratch space and can't be shared between goroutines.
+func (s *session) fork() *session {
+	f := &session{
+		params:    s.params,
+		encoder:   bfv.NewEncoder(s.params),
+		evaluator: bfv.NewEvaluator(s.params),
+		mac:       s.mac,
+		pk:        s.pk,
+		sk:        s.sk,
+		keys:      s.keys,
+	}
+	if s.pk != nil {
+		f.encryptor = bfv.NewEncryptorFromPk(s.params, s.pk)
+	}
+	if s.sk != nil {
+		f.decryptor = bfv.NewDecryptor(s.params, s.sk)
+	}
+	return f
+}
+
+// loadRelinKey loads the relinearization key distance2 needs.
+func (s *session) loadRelinKey(location string) error {
+	b, err := fhe.ReadKey(context.Background(), location)
+	if err != nil {
+		return err
+	}
+	if s.keys.Relin, err = fhe.UnmarshalRelinKey(s.params, b); err != nil {
+		return fmt.Errorf("%s: %v", location, err)
+	}
+	return nil
+}
+
+func (s *session) encrypt(v int64) ([]byte, error) {
+	return s.encryptSlots([]int64{v})
+}
+
+// encryptSlots encrypts vs one per slot, a vector for fhe_dot.
+func (s *session) encryptSlots(vs []int64) ([]byte, error) {
+	if s.encryptor == nil {
+		return nil, errors.New("encrypt needs a public key, set -pub")
+	}
+	if n := 1 << s.params.LogN; len(vs) > n {
+		return nil, fmt.Errorf("%d values don't fit in %d slots", len(vs), n)
+	}
+	ct := s.encryptor.EncryptNew(fhe.EncodeSlots(s.params, s.encoder, vs))
+	b, err := ct.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return s.mac.Seal(b)
+}
+
+// encryptOneHot encrypts category v out of buckets as fhe_encrypt_onehot
+// does.
+func (s *session) encryptOneHot(v, buckets int64) ([]byte, error) {
+	if s.encryptor == nil {
+		return nil, errors.New("encrypt needs a public key, set -pub")
+	}
+	pt, err := fhe.EncodeOneHot(s.params, s.encoder, v, buckets)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.encryptor.EncryptNew(pt).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return s.mac.Seal(b)
+}
+
+func (s *session) decrypt(data []byte) (int64, error) {
+	data, err := s.mac.Open(data)
+	if err != nil {
+		return 0, err
+	}
+	return s.decryptRaw(data)
+}
+
+// decryptSlots decrypts the first n slots of a ciphertext that packs one
+// value per slot, like fhe_nearest's.
+func (s *session) decryptSlots(data []byte, n int) ([]int64, error) {
+	if s.decryptor == nil {
+		return nil, errors.New("decrypt needs a secret key, set -sec")
+	}
+	data, err := s.mac.Open(data)
+	if err != nil {
+		return nil, err
+	}
+	ct, err := fhe.UnmarshalCiphertext(s.params, data)
+	if err != nil {
+		return nil, err
+	}
+	return fhe.DecodeSlots(s.encoder, s.decryptor.DecryptNew(ct), n), nil
+}
+
+// aggregateStats decrypts an fhe_aggregate envelope whose MAC is already
+// open.
+func (s *session) aggregateStats(data []byte) (*fhe.Stats, error) {
+	if s.decryptor == nil {
+		return nil, errors.New("decrypt needs a secret key, set -sec")
+	}
+	a, err := fhe.UnmarshalAggregate(s.params, data)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := fhe.DecodeValueChecked(s.encoder, s.decryptor.DecryptNew(a.Sum))
+	if err != nil {
+		return nil, fmt.Errorf("sum: %v", err)
+	}
+	sumSq, err := fhe.DecodeValueChecked(s.encoder, s.decryptor.DecryptNew(a.SumSq))
+	if err != nil {
+		return nil, fmt.Errorf("sum of squares: %v", err)
+	}
+	return fhe.NewStats(a.Count, sum, sumSq), nil
+}
+
+// decryptRaw decrypts a ciphertext that has no MAC envelope.
+func (s *session) decryptRaw(data []byte) (int64, error) {
+	if s.decryptor == nil {
+		return 0, errors.New("decrypt needs a secret key, set -sec")
+	}
+	ct, err := fhe.UnmarshalCiphertext(s.params, data)
+	if err != nil {
+		return 0, err
+	}
+	return fhe.DecodeValueChecked(s.encoder, s.decryptor.DecryptNew(ct))
+}
+
+// apply runs one of fhe.Ops on tagged ciphertexts.
+func (s *session) apply(name string, args [][]byte) ([]byte, error) {
+	op, ok := fhe.Ops[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	if len(args) != op.Args {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", name, op.Args, len(args))
+	}
+	cts := make([]*bfv.Ciphertext, len(args))
+	for i, a := range args {
+		a, err := s.mac.Open(a)
+		if err != nil {
+			return nil, err
+		}
+		if cts[i], err = fhe.UnmarshalCiphertext(s.params, a); err != nil {
+			return nil, err
+		}
+	}
+	ct, err := op.Eval(s.evaluator, s.keys, cts)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ct.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return s.mac.Seal(b)
+}
+
+// maxLine is the longest stdin line accepted, enough for a base64
+// ciphertext of fhe.MaxCiphertextDegree.
+const maxLine = 4 << 20
+
+// eachInput calls f with every argument, or with every non-blank line of r
+// if there are no arguments.
+func eachInput(args []string, r io.Reader, f func(s string) error) error {
+	if len(args) > 0 {
+		for i, a := range args {
+			if err := f(a); err != nil {
+				return fmt.Errorf("argument %d: %v", i+1, err)
+			}
+		}
+		return nil
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), maxLine)
+	for line := 1; sc.Scan(); line++ {
+		s := strings.TrimSpace(sc.Text())
+		if s == "" {
+			continue
+		}
+		if err := f(s); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return sc.Err()
+}
+
+// readCiphertext decodes a base64 ciphertext, or reads one from a file if s
+// is @path. Files may be raw or base64.
+func readCiphertext(s string) ([]byte, error) {
+	if strings.HasPrefix(s, "@") {
+		b, err := os.ReadFile(s[1:])
+		if err != nil {
+			return nil, err
+		}
+		if d, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b))); err == nil {
+			return d, nil
+		}
+		return b, nil
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"example.com/decrypt"
+	"example.com/fhe"
+	"example.com/fhe/bq"
+)
+
+// A ciphertext past fhe.MulDepth decrypts to noise. The CLI and the decrypt
+// service both refuse it rather than print a random number, and fhe_refresh
+// rather than encrypt one.
+func TestDecryptNoise(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestSession(t, dir)
+	ct := s.encryptor.EncryptNew(fhe.EncodeValue(s.params, s.encoder, 3))
+	for i := 0; i <= fhe.MulDepth; i++ {
+		ct = s.evaluator.MulNew(ct, ct)
+	}
+	noisy, err := ct.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := s.decrypt(noisy); !errors.Is(err, fhe.ErrNoise) {
+		t.Errorf("CLI decrypt = %d, %v, want ErrNoise", v, err)
+	}
+
+	t.Setenv(fhe.SecretKeyURLEnv, filepath.Join(dir, "sec.bin"))
+	t.Setenv(fhe.MACDisabledEnv, "1")
+	body, _ := json.Marshal(&bq.Request{Calls: [][]interface{}{{base64.StdEncoding.EncodeToString(noisy)}}})
+	rec := httptest.NewRecorder()
+	decrypt.FHE_DECRYPT(rec, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), fhe.ErrNoise.Error()) {
+		t.Errorf("fhe_decrypt = %d %s, want ErrNoise", rec.Code, rec.Body)
+	}
+
+	body, _ = json.Marshal(&bq.Request{
+		Calls:              [][]interface{}{{base64.StdEncoding.EncodeToString(noisy)}},
+		UserDefinedContext: map[string]string{"mode": "refresh"},
+	})
+	rec = httptest.NewRecorder()
+	decrypt.FHE_DECRYPT(rec, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), fhe.ErrNoise.Error()) {
+		t.Errorf("fhe_refresh = %d %s, want ErrNoise", rec.Code, rec.Body)
+	}
+}
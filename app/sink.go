@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sink is where insert sends rows: a BigQuery table or a local file ready for
+// bq load.
+type sink interface {
+	// Create makes the table or file for the schema, and is a no-op if it
+	// already exists.
+	Create(ctx context.Context) error
+	Put(ctx context.Context, rows []row) error
+	Close() error
+}
+
+const (
+	sinkBigQuery = "bigquery"
+	sinkNDJSON   = "ndjson"
+	sinkAvro     = "avro"
+)
+
+// column is one column of the destination. Encrypted columns take integers
+// and are stored as BYTES ciphertexts.
+type column struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+	Required  bool   `json:"required,omitempty"`
+}
+
+// sinkConfig says where rows go and what they look like. It is read from
+// the -config JSON file, and flags override it.
+type sinkConfig struct {
+	Sink string `json:"sink"`
+
+	// bigquery
+	Project string `json:"project"`
+	Dataset string `json:"dataset"`
+	Table   string `json:"table"`
+	// API endpoint, eg http://localhost:9050 for a local BigQuery emulator;
+	// requests to it are not authenticated
+	Endpoint string `json:"endpoint"`
+
+	// ndjson and avro
+	Path string `json:"path"`
+
+	Schema []column `json:"schema"`
+}
+
+// defaultSinkConfig is the fhe.xy table from the README.
+func defaultSinkConfig() *sinkConfig {
+	return &sinkConfig{
+		Sink:    sinkBigQuery,
+		Dataset: "fhe",
+		Table:   "xy",
+		Schema: []column{
+			{Name: "uid", Type: "STRING"},
+			{Name: "x", Type: "INTEGER", Encrypted: true},
+			{Name: "y", Type: "INTEGER", Encrypted: true},
+		},
+	}
+}
+
+// sinkFlags registers the flags that override a sinkConfig.
+type sinkFlags struct {
+	config                              *string
+	sink, project, dataset, table, path *string
+	endpoint                            *string
+}
+
+func newSinkFlags(fs *flag.FlagSet) *sinkFlags {
+	return &sinkFlags{
+		config:   fs.String("config", "", "JSON file with the sink and schema (default the fhe.xy table)"),
+		sink:     fs.String("sink", "", "bigquery, ndjson or avro"),
+		project:  fs.String("projectID", "", "BigQuery project"),
+		dataset:  fs.String("dataset", "", "BigQuery dataset"),
+		table:    fs.String("table", "", "BigQuery table"),
+		endpoint: fs.String("endpoint", "", "BigQuery API endpoint, eg a local emulator"),
+		path:     fs.String("out", "", "file for the ndjson and avro sinks"),
+	}
+}
+
+func (f *sinkFlags) load() (*sinkConfig, error) {
+	cfg := defaultSinkConfig()
+	if *f.config != "" {
+		b, err := os.ReadFile(*f.config)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Schema = nil
+		if err := json.Unmarshal(b, cfg); err != nil {
+			return nil, fmt.Errorf("%s: %v", *f.config, err)
+		}
+	}
+	for _, o := range []struct {
+		flag *string
+		cfg  *string
+	}{
+		{f.sink, &cfg.Sink}, {f.project, &cfg.Project}, {f.dataset, &cfg.Dataset},
+		{f.table, &cfg.Table}, {f.endpoint, &cfg.Endpoint}, {f.path, &cfg.Path},
+	} {
+		if *o.flag != "" {
+			*o.cfg = *o.flag
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *sinkConfig) validate() error {
+	if len(c.Schema) == 0 {
+		return errors.New("schema has no columns")
+	}
+	seen := make(map[string]bool)
+	for i, col := range c.Schema {
+		if col.Name == "" {
+			return fmt.Errorf("schema column %d has no name", i+1)
+		}
+		if seen[col.Name] {
+			return fmt.Errorf("schema has column %s twice", col.Name)
+		}
+		seen[col.Name] = true
+		t, err := bigQueryType(col.Type)
+		if err != nil {
+			return fmt.Errorf("column %s: %v", col.Name, err)
+		}
+		if col.Encrypted && t != "INTEGER" {
+			return fmt.Errorf("column %s: only INTEGER columns can be encrypted", col.Name)
+		}
+		c.Schema[i].Type = t
+	}
+
+	switch c.Sink {
+	case sinkBigQuery:
+		if c.Project == "" || c.Dataset == "" || c.Table == "" {
+			return errors.New("the bigquery sink needs a project, dataset and table")
+		}
+	case sinkNDJSON, sinkAvro:
+		if c.Path == "" {
+			return fmt.Errorf("the %s sink needs a path", c.Sink)
+		}
+	default:
+		return fmt.Errorf("unknown sink %q", c.Sink)
+	}
+	return nil
+}
+
+func (c *sinkConfig) open(ctx context.Context) (sink, error) {
+	switch c.Sink {
+	case sinkBigQuery:
+		return newBigQuerySink(ctx, c)
+	case sinkNDJSON, sinkAvro:
+		return newFileSink(c)
+	}
+	return nil, fmt.Errorf("unknown sink %q", c.Sink)
+}
+
+// bigQueryType normalizes the standard SQL aliases of the types a column
+// may have.
+func bigQueryType(t string) (string, error) {
+	switch strings.ToUpper(t) {
+	case "STRING":
+		return "STRING", nil
+	case "BYTES":
+		return "BYTES", nil
+	case "INTEGER", "INT64":
+		return "INTEGER", nil
+	case "FLOAT", "FLOAT64":
+		return "FLOAT", nil
+	case "BOOLEAN", "BOOL":
+		return "BOOLEAN", nil
+	}
+	return "", fmt.Errorf("unsupported type %q", t)
+}
+
+// storedType is the type of col in the table, BYTES once encrypted.
+func (col column) storedType() string {
+	if col.Encrypted {
+		return "BYTES"
+	}
+	return col.Type
+}
+
+// prepareRow encrypts the encrypted columns of r and converts the rest to
+// the Go type of their column, so every sink gets int64, float64, bool,
+// string or []byte. Columns not in the schema are an error.
+func prepareRow(s *session, schema []column, r row) error {
+	for _, col := range schema {
+		v, ok := r[col.Name]
+		if !ok || v == nil || v == "" {
+			if col.Required {
+				return fmt.Errorf("column %s is required", col.Name)
+			}
+			r[col.Name] = nil
+			continue
+		}
+		if col.Encrypted {
+			ct, err := encryptCell(s, v)
+			if err != nil {
+				return fmt.Errorf("column %s: %v", col.Name, err)
+			}
+			r[col.Name] = ct
+			continue
+		}
+		v, err := convertCell(col.Type, v)
+		if err != nil {
+			return fmt.Errorf("column %s: %v", col.Name, err)
+		}
+		r[col.Name] = v
+	}
+	if len(r) > len(schema) {
+		for c := range r {
+			if !hasColumn(schema, c) {
+				return fmt.Errorf("column %s is not in the schema", c)
+			}
+		}
+	}
+	return nil
+}
+
+func hasColumn(schema []column, name string) bool {
+	for _, col := range schema {
+		if col.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func convertCell(typ string, v interface{}) (interface{}, error) {
+	s, isString := v.(string)
+	switch typ {
+	case "INTEGER":
+		switch v := v.(type) {
+		case json.Number:
+			return v.Int64()
+		case int64:
+			return v, nil
+		}
+		if isString {
+			return strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		}
+	case "FLOAT":
+		switch v := v.(type) {
+		case json.Number:
+			return v.Float64()
+		case float64:
+			return v, nil
+		}
+		if isString {
+			return strconv.ParseFloat(strings.TrimSpace(s), 64)
+		}
+	case "BOOLEAN":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		if isString {
+			return strconv.ParseBool(strings.TrimSpace(s))
+		}
+	case "BYTES":
+		if b, ok := v.([]byte); ok {
+			return b, nil
+		}
+		if isString {
+			return base64.StdEncoding.DecodeString(s)
+		}
+	case "STRING":
+		return cellString(v)
+	}
+	return nil, fmt.Errorf("can't store %T as %s", v, typ)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// bigQuerySink streams rows into a table with the Inserter.
+type bigQuerySink struct {
+	cfg    *sinkConfig
+	client *bigquery.Client
+	table  *bigquery.Table
+}
+
+func newBigQuerySink(ctx context.Context, cfg *sinkConfig) (*bigQuerySink, error) {
+	var opts []option.ClientOption
+	if cfg.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(cfg.Endpoint), option.WithoutAuthentication())
+	}
+	client, err := bigquery.NewClient(ctx, cfg.Project, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &bigQuerySink{
+		cfg:    cfg,
+		client: client,
+		table:  client.Dataset(cfg.Dataset).Table(cfg.Table),
+	}, nil
+}
+
+func (b *bigQuerySink) schema() bigquery.Schema {
+	var s bigquery.Schema
+	for _, col := range b.cfg.Schema {
+		s = append(s, &bigquery.FieldSchema{
+			Name:     col.Name,
+			Type:     bigquery.FieldType(col.storedType()),
+			Required: col.Required,
+		})
+	}
+	return s
+}
+
+func alreadyExists(err error) bool {
+	var e *googleapi.Error
+	return errors.As(err, &e) && e.Code == http.StatusConflict
+}
+
+func (b *bigQuerySink) Create(ctx context.Context) error {
+	err := b.client.Dataset(b.cfg.Dataset).Create(ctx, &bigquery.DatasetMetadata{})
+	if err != nil && !alreadyExists(err) {
+		return err
+	}
+	err = b.table.Create(ctx, &bigquery.TableMetadata{Schema: b.schema()})
+	if err != nil && !alreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// rowSaver hands one row to the Inserter under the schema's column names.
+type rowSaver struct {
+	schema []column
+	r      row
+}
+
+func (s *rowSaver) Save() (map[string]bigquery.Value, string, error) {
+	m := make(map[string]bigquery.Value, len(s.schema))
+	for _, col := range s.schema {
+		m[col.Name] = s.r[col.Name]
+	}
+	insertID := ""
+	if id, ok := s.r["uid"].(string); ok {
+		// lets BigQuery drop duplicates if a batch is retried
+		insertID = id
+	}
+	return m, insertID, nil
+}
+
+func (b *bigQuerySink) Put(ctx context.Context, rows []row) error {
+	savers := make([]*rowSaver, len(rows))
+	for i, r := range rows {
+		savers[i] = &rowSaver{schema: b.cfg.Schema, r: r}
+	}
+	return b.table.Inserter().Put(ctx, savers)
+}
+
+func (b *bigQuerySink) Close() error {
+	return b.client.Close()
+}
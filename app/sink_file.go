@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// fileSink appends rows to a local NDJSON or Avro file for bq load.
+type fileSink struct {
+	cfg *sinkConfig
+	f   *os.File
+	w   rowWriter
+}
+
+func newFileSink(cfg *sinkConfig) (*fileSink, error) {
+	return &fileSink{cfg: cfg}, nil
+}
+
+func (s *fileSink) fields() []avroField {
+	fields := make([]avroField, len(s.cfg.Schema))
+	for i, col := range s.cfg.Schema {
+		t := "string"
+		switch col.storedType() {
+		case "BYTES":
+			t = "bytes"
+		case "INTEGER":
+			t = "long"
+		case "FLOAT":
+			t = "double"
+		case "BOOLEAN":
+			t = "boolean"
+		}
+		fields[i] = avroField{Name: col.Name, Type: t}
+	}
+	return fields
+}
+
+// Create opens the file, keeping what is already in it. Put calls it too.
+func (s *fileSink) Create(ctx context.Context) error {
+	if s.w != nil {
+		return nil
+	}
+	f, err := os.OpenFile(s.cfg.Path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if s.cfg.Sink == sinkAvro {
+		// appends after the existing header and blocks, if any
+		s.w, err = newAvroWriter(f, s.fields())
+	} else {
+		_, err = f.Seek(0, io.SeekEnd)
+		s.w = newNDJSONWriter(f)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	return nil
+}
+
+func (s *fileSink) Put(ctx context.Context, rows []row) error {
+	if err := s.Create(ctx); err != nil {
+		return err
+	}
+	if err := s.w.Write(rows); err != nil {
+		return err
+	}
+	_, err := s.w.Sync()
+	return err
+}
+
+func (s *fileSink) Close() error {
+	if s.w == nil {
+		return nil
+	}
+	return s.w.Close()
+}
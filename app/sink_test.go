@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var testSchema = []column{
+	{Name: "uid", Type: "STRING", Required: true},
+	{Name: "dept", Type: "STRING"},
+	{Name: "salary", Type: "INT64", Encrypted: true},
+}
+
+func TestFileSink(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestSession(t, dir)
+
+	for _, format := range []string{sinkNDJSON, sinkAvro} {
+		cfg := &sinkConfig{Sink: format, Path: filepath.Join(dir, "out."+format), Schema: testSchema}
+		if err := cfg.validate(); err != nil {
+			t.Fatal(err)
+		}
+		// two runs append to the same file
+		for i, batch := range [][]row{
+			{{"uid": "a", "dept": "eng", "salary": "100"}, {"uid": "b", "salary": "-7"}},
+			{{"uid": "c", "dept": "ops", "salary": "12"}},
+		} {
+			sk, err := cfg.open(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i == 0 {
+				if err := sk.Create(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, r := range batch {
+				if err := prepareRow(s, cfg.Schema, r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := sk.Put(context.Background(), batch); err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			if err := sk.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		rows := readOutput(t, cfg.Path, []string{"salary"})
+		want := []struct {
+			uid, dept string
+			salary    int64
+		}{{"a", "eng", 100}, {"b", "", -7}, {"c", "ops", 12}}
+		if len(rows) != len(want) {
+			t.Fatalf("%s: got %d rows, want %d", format, len(rows), len(want))
+		}
+		for i, w := range want {
+			r := rows[i]
+			dept, _ := r["dept"].(string)
+			if r["uid"] != w.uid || dept != w.dept {
+				t.Errorf("%s: row %d is %v", format, i+1, r)
+			}
+			v, err := s.decrypt(r["salary"].([]byte))
+			if err != nil || v != w.salary {
+				t.Errorf("%s: row %d salary = %d, %v, want %d", format, i+1, v, err, w.salary)
+			}
+		}
+	}
+}
+
+func TestPrepareRow(t *testing.T) {
+	s := newTestSession(t, t.TempDir())
+	for _, r := range []row{
+		{"dept": "eng", "salary": "1"},
+		{"uid": "a", "salary": "x"},
+		{"uid": "a", "salary": "1", "bonus": "2"},
+	} {
+		if err := prepareRow(s, testSchema, r); err == nil {
+			t.Errorf("prepareRow(%v) did not fail", r)
+		}
+	}
+}
+
+// fakeBigQuery records the REST calls the client makes.
+type fakeBigQuery struct {
+	mu       sync.Mutex
+	datasets map[string]bool
+	tables   map[string]json.RawMessage
+	rows     []map[string]interface{}
+}
+
+func (f *fakeBigQuery) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var body map[string]json.RawMessage
+	json.NewDecoder(r.Body).Decode(&body)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	conflict := func() {
+		http.Error(w, `{"error":{"code":409,"message":"Already Exists"}}`, http.StatusConflict)
+	}
+	switch {
+	case r.Method == "POST" && len(parts) == 3 && parts[2] == "datasets":
+		var ref struct{ DatasetID string }
+		json.Unmarshal(body["datasetReference"], &ref)
+		if f.datasets[ref.DatasetID] {
+			conflict()
+			return
+		}
+		f.datasets[ref.DatasetID] = true
+	case r.Method == "POST" && len(parts) == 5 && parts[4] == "tables":
+		var ref struct{ TableID string }
+		json.Unmarshal(body["tableReference"], &ref)
+		name := parts[3] + "." + ref.TableID
+		if _, ok := f.tables[name]; ok {
+			conflict()
+			return
+		}
+		f.tables[name] = body["schema"]
+	case r.Method == "POST" && len(parts) == 7 && parts[6] == "insertAll":
+		var rows []struct {
+			InsertID string
+			JSON     map[string]interface{}
+		}
+		json.Unmarshal(body["rows"], &rows)
+		for _, row := range rows {
+			f.rows = append(f.rows, row.JSON)
+		}
+	default:
+		http.Error(w, `{"error":{"code":404,"message":"Not found"}}`, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{}`))
+}
+
+func TestBigQuerySinkEndpoint(t *testing.T) {
+	s := newTestSession(t, t.TempDir())
+	fake := &fakeBigQuery{datasets: map[string]bool{}, tables: map[string]json.RawMessage{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	cfg := &sinkConfig{Sink: sinkBigQuery, Project: "p", Dataset: "fhe", Table: "pay", Endpoint: srv.URL, Schema: testSchema}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	sk, err := cfg.open(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sk.Close()
+	// the second Create finds both and is a no-op
+	for i := 0; i < 2; i++ {
+		if err := sk.Create(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var schema struct {
+		Fields []struct{ Name, Type, Mode string }
+	}
+	json.Unmarshal(fake.tables["fhe.pay"], &schema)
+	var got []string
+	for _, f := range schema.Fields {
+		got = append(got, f.Name+":"+f.Type+":"+f.Mode)
+	}
+	if want := "uid:STRING:REQUIRED dept:STRING: salary:BYTES:"; strings.Join(got, " ") != want {
+		t.Errorf("table schema is %q, want %q", strings.Join(got, " "), want)
+	}
+
+	rows := []row{{"uid": "a", "dept": "eng", "salary": "42"}}
+	if err := prepareRow(s, cfg.Schema, rows[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := sk.Put(ctx, rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.rows) != 1 {
+		t.Fatalf("inserted %d rows, want 1", len(fake.rows))
+	}
+	ct, err := base64.StdEncoding.DecodeString(fake.rows[0]["salary"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.decrypt(ct); err != nil || v != 42 {
+		t.Errorf("salary decrypts to %d, %v, want 42", v, err)
+	}
+}
@@ -6,179 +6,491 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"strconv"
 	"sync"
 
+	"example.com/fhe"
+	"example.com/fhe/bq"
+	"example.com/fhe/keyring"
+	"example.com/fhe/logging"
+	"example.com/fhe/metrics"
+	"example.com/fhe/service"
+	"example.com/fhe/tracing"
 	"github.com/ldsec/lattigo/bfv"
-	"golang.org/x/net/http2"
 )
 
-type bqRequest struct {
-	RequestId          string            `json:"requestId"`
-	Caller             string            `json:"caller"`
-	SessionUser        string            `json:"sessionUser"`
-	UserDefinedContext map[string]string `json:"userDefinedContext"`
-	Calls              [][]interface{}   `json:"calls"`
-}
-
-type bqResponse struct {
-	Replies      []string `json:"replies,omitempty"`
-	ErrorMessage string   `json:"errorMessage,omitempty"`
-}
-
 const (
 	// of course you should load this from some secure source
 	// GCP Secrets Engine does now allow large values so you may need to load this by some other way (eg, store encrypted using KMS keyref)
 	secretKeyURL = "https://raw.githubusercontent.com/salrashid123/bq_fhe/main/app/sec.b64"
+
+	// overrides secretKeyURL; may also be a file path
+	secretKeyURLEnv = fhe.SecretKeyURLEnv
 )
 
+// secretKeys is one generation of the secret keys. A reload swaps in a new
+// one and the batches in flight finish with the one they were pinned to.
+type secretKeys struct {
+	id string // KeyID of the public key made from the secret key
+	sk *bfv.SecretKey
+
+	// fhe_refresh encrypts under a public key made from the secret key
+	pk *bfv.PublicKey
+
+	// the EqParams secret key, fetched by the first fhe_eq result decrypted
+	// unless FHE_EQ_SECRET_KEY_URL was set when the generation loaded
+	eqMu sync.Mutex
+	eqSk *bfv.SecretKey
+	eqPk *bfv.PublicKey
+}
+
 var (
-	pk          bfv.PublicKey
-	sk          bfv.SecretKey
-	decryptorSk bfv.Decryptor
+	// verifies inputs; nil when neither FHE_MAC_KEY nor FHE_MAC_VERIFY_KEYS is set
+	mac *fhe.MAC
+
+	// the secret keys, fetched on first use and reloaded without a restart
+	ring = keyring.New(loadKeys)
+
+	handler = bq.NewModes("decrypt", map[string]*bq.Handler{
+		"decrypt": bq.NewHandler(1, func(ctx context.Context, row []interface{}) (string, error) {
+			e, err := bq.Bytes(row, 0)
+			if err != nil {
+				return "", err
+			}
+			ec, err := decrypt(ctx, e)
+			if err != nil {
+				return "", err
+			}
+			return base64.StdEncoding.EncodeToString(ec), nil
+		}),
+
+		// fhe_decrypt_packed(BYTES, INT64 n) returns the first n slots as a
+		// JSON array, eg the distances from fhe_nearest
+		"decrypt_packed": bq.NewHandler(2, func(ctx context.Context, row []interface{}) (string, error) {
+			e, err := bq.Bytes(row, 0)
+			if err != nil {
+				return "", err
+			}
+			n, err := bq.Number(row, 1)
+			if err != nil {
+				return "", err
+			}
+			return decryptPacked(ctx, e, n)
+		}),
+
+		// fhe_decrypt_histogram(BYTES, INT64 buckets) returns the counts
+		// or totals per category of a one-hot sum as a JSON array
+		"decrypt_histogram": bq.NewHandler(2, func(ctx context.Context, row []interface{}) (string, error) {
+			e, err := bq.Bytes(row, 0)
+			if err != nil {
+				return "", err
+			}
+			n, err := bq.Number(row, 1)
+			if err != nil {
+				return "", err
+			}
+			return decryptHistogram(ctx, e, n)
+		}),
+
+		// fhe_refresh(BYTES) returns a fresh encryption of the same value,
+		// for pipelines longer than the noise allows
+		"refresh": bq.NewHandler(1, func(ctx context.Context, row []interface{}) (string, error) {
+			e, err := bq.Bytes(row, 0)
+			if err != nil {
+				return "", err
+			}
+			ec, err := refresh(ctx, e)
+			if err != nil {
+				return "", err
+			}
+			return base64.StdEncoding.EncodeToString(ec), nil
+		}),
+
+		// fhe_decrypt_stats(BYTES) returns the count, sum, sum of squares,
+		// mean and variances of an fhe_aggregate envelope as JSON, or an
+		// error if the sums wrapped modulo T
+		"decrypt_stats": bq.NewHandler(1, func(ctx context.Context, row []interface{}) (string, error) {
+			e, err := bq.Bytes(row, 0)
+			if err != nil {
+				return "", err
+			}
+			return decryptStats(ctx, e)
+		}),
+	})
 )
 
-func decrypt(encrypted []byte) ([]byte, error) {
+// decryptPlaintext decrypts a DefaultParams ciphertext, or an EqParams one
+// with the fhe_eq secret key, with the decryptor of a Workspace of its
+// own. Decode the plaintext with the encoder of w, then put w back.
+func decryptPlaintext(ctx context.Context, encrypted []byte) (*fhe.Workspace, *bfv.Plaintext, error) {
+
+	g, err := ring.From(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	encrypted, err = open(encrypted)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	var XcipherT bfv.Ciphertext
-	err := XcipherT.UnmarshalBinary(encrypted)
+	k, err := g.keysFor(ctx, encrypted)
 	if err != nil {
+		return nil, nil, err
+	}
+	w := fhe.GetWorkspace(k.params)
+	XcipherT, err := w.UnmarshalCiphertext(encrypted)
+	if err != nil {
+		w.Put()
+		return nil, nil, err
+	}
+	XplainT := bfv.NewPlaintext(k.params)
+	done := metrics.Time(metrics.Decrypt)
+	w.Decryptor(k.sk).Decrypt(XcipherT, XplainT)
+	done()
+	return w, XplainT, nil
+}
+
+// open verifies the tag of a ciphertext. Without a MAC key it refuses every
+// ciphertext unless FHE_MAC_DISABLED is set, rather than decrypt whatever
+// it is given.
+func open(x []byte) ([]byte, error) {
+	if err := fhe.RequireMAC(mac); err != nil {
 		return nil, err
 	}
-	params := bfv.DefaultParams[bfv.PN12QP109]
-	encoder := bfv.NewEncoder(params)
-	XplainT := bfv.NewPlaintext(params)
-	decryptorSk.Decrypt(&XcipherT, XplainT)
-	x := encoder.DecodeInt(XplainT)
+	return mac.Open(x)
+}
 
-	// b := make([]byte, 8)
-	// binary.LittleEndian.PutUint64(b, x[0<<1])
+// keys are what decrypting and refreshing under one parameter set need.
+type keys struct {
+	params *bfv.Parameters
+	sk     *bfv.SecretKey
+	pk     *bfv.PublicKey
+}
 
-	//return x[0<<1], nil
-	s := fmt.Sprintf("%v", x[0<<1])
-	return []byte(s), nil
+// keysFor returns the DefaultParams keys of g, or the fhe_eq ones for an
+// EqParams ciphertext.
+func (g *secretKeys) keysFor(ctx context.Context, data []byte) (*keys, error) {
+	if fhe.IsEqCiphertext(data) {
+		if err := g.loadEq(ctx); err != nil {
+			return nil, err
+		}
+		return &keys{fhe.EqParams(), g.eqSk, g.eqPk}, nil
+	}
+	return &keys{fhe.DefaultParams(), g.sk, g.pk}, nil
 }
 
-func init() {
+// refresh decrypts x and encrypts it again, resetting its degree and noise,
+// so the result takes the full fhe.MulDepth of multiplications again. It
+// answers to the same MAC check as decrypt and the value never leaves the
+// service. Both sums of an fhe_aggregate envelope are refreshed. A
+// ciphertext that is already noise is refused as decrypt refuses it.
+func refresh(ctx context.Context, x []byte) ([]byte, error) {
 
-	var client http.Client
-	resp, err := client.Get(secretKeyURL)
+	g, err := ring.From(ctx)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	defer resp.Body.Close()
-	var s []byte
-	if resp.StatusCode == http.StatusOK {
-		s, err = io.ReadAll(resp.Body)
+	x, err = open(x)
+	if err != nil {
+		return nil, err
+	}
+	k, err := g.keysFor(ctx, x)
+	if err != nil {
+		return nil, err
+	}
+	w := fhe.GetWorkspace(k.params)
+	defer w.Put()
+	encoder, decryptor, encryptor := w.Encoder, w.Decryptor(k.sk), w.Encryptor(k.pk)
+
+	if fhe.IsAggregate(x) {
+		agg, err := fhe.UnmarshalAggregate(k.params, x)
 		if err != nil {
-			panic(err)
+			return nil, err
+		}
+		done := metrics.Time(metrics.Decrypt)
+		defer done()
+		if agg.Sum, err = fhe.Refresh(k.params, encoder, decryptor, encryptor, agg.Sum); err != nil {
+			return nil, err
 		}
-	} else {
-		panic(errors.New("unable to get secret key from url"))
+		if agg.SumSq, err = fhe.Refresh(k.params, encoder, decryptor, encryptor, agg.SumSq); err != nil {
+			return nil, err
+		}
+		return mac.Marshal(agg)
 	}
 
+	ct, err := fhe.UnmarshalCiphertext(k.params, x)
+	if err != nil {
+		return nil, err
+	}
+	done := metrics.Time(metrics.Decrypt)
+	ct, err = fhe.Refresh(k.params, encoder, decryptor, encryptor, ct)
+	done()
+	if err != nil {
+		return nil, err
+	}
+	return mac.Marshal(ct)
+}
+
+func decryptStats(ctx context.Context, envelope []byte) (string, error) {
+
+	g, err := ring.From(ctx)
+	if err != nil {
+		return "", err
+	}
+	envelope, err = open(envelope)
+	if err != nil {
+		return "", err
+	}
 	params := bfv.DefaultParams[bfv.PN12QP109]
-	secBytes, err := base64.StdEncoding.DecodeString(string(s))
+	if !fhe.IsAggregate(envelope) {
+		return "", errors.New("not an fhe_aggregate result")
+	}
+	agg, err := fhe.UnmarshalAggregate(params, envelope)
+	if err != nil {
+		return "", err
+	}
+	var maxAbs int64
+	if v := bq.UserDefinedContext(ctx)[fhe.StatsMaxAbsContextKey]; v != "" {
+		if maxAbs, err = strconv.ParseInt(v, 10, 64); err != nil || maxAbs <= 0 {
+			return "", fmt.Errorf("invalid %s %q in user_defined_context", fhe.StatsMaxAbsContextKey, v)
+		}
+	}
+	w := fhe.GetWorkspace(params)
+	defer w.Put()
+	decryptor := w.Decryptor(g.sk)
+	done := metrics.Time(metrics.Decrypt)
+	sum, err := fhe.DecodeValueChecked(w.Encoder, decryptor.DecryptNew(agg.Sum))
 	if err != nil {
-		fmt.Printf("Invalid secret Key decoding %v\n", err)
+		done()
+		return "", fmt.Errorf("sum: %w", err)
 	}
-	err = sk.UnmarshalBinary(secBytes)
+	sumSq, err := fhe.DecodeValueChecked(w.Encoder, decryptor.DecryptNew(agg.SumSq))
+	done()
 	if err != nil {
-		fmt.Printf("Invalid secret Key %v\n", err)
+		return "", fmt.Errorf("sum of squares: %w", err)
+	}
+	if err := fhe.CheckStats(params, agg.Count, sum, sumSq, maxAbs); err != nil {
+		return "", err
 	}
-	decryptorSk = bfv.NewDecryptor(params, &sk)
 
+	b, err := json.Marshal(fhe.NewStats(agg.Count, sum, sumSq))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
 }
 
-func FHE_DECRYPT(w http.ResponseWriter, r *http.Request) {
+func decryptPacked(ctx context.Context, encrypted []byte, n float64) (string, error) {
 
-	bqReq := &bqRequest{}
-	bqResp := &bqResponse{}
+	params := bfv.DefaultParams[bfv.PN12QP109]
+	if n < 1 || n > float64(uint64(1)<<params.LogN) || n != float64(int(n)) {
+		return "", fmt.Errorf("invalid argument 1: %v slots", n)
+	}
+	w, XplainT, err := decryptPlaintext(ctx, encrypted)
+	if err != nil {
+		return "", err
+	}
+	defer w.Put()
+	b, err := json.Marshal(fhe.DecodeSlots(w.Encoder, XplainT, int(n)))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&bqReq); err != nil {
-		bqResp.ErrorMessage = fmt.Sprintf("External Function error: can't read POST body %v", err)
-	} else {
+func decryptHistogram(ctx context.Context, encrypted []byte, buckets float64) (string, error) {
 
-		fmt.Printf("caller %s\n", bqReq.Caller)
-		fmt.Printf("sessionUser %s\n", bqReq.SessionUser)
-		fmt.Printf("userDefinedContext %v\n", bqReq.UserDefinedContext)
+	if buckets != float64(int(buckets)) {
+		return "", fmt.Errorf("invalid argument 1: %v buckets", buckets)
+	}
+	w, XplainT, err := decryptPlaintext(ctx, encrypted)
+	if err != nil {
+		return "", err
+	}
+	defer w.Put()
+	h, err := fhe.DecodeHistogram(w.Encoder, XplainT, int(buckets))
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
 
-		wait := new(sync.WaitGroup)
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+func decrypt(ctx context.Context, encrypted []byte) ([]byte, error) {
 
-		objs := make([]string, len(bqReq.Calls))
+	w, XplainT, err := decryptPlaintext(ctx, encrypted)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Put()
+	// checked like the CLI's decrypt, so noise is an error and not a value
+	x, err := fhe.DecodeValueChecked(w.Encoder, XplainT)
+	if err != nil {
+		return nil, err
+	}
 
-		for i, r := range bqReq.Calls {
-			if len(r) != 1 {
-				bqResp.ErrorMessage = fmt.Sprintf("Invalid number of input fields provided.  expected 1, got  %d", len(r))
-			}
+	s := fmt.Sprintf("%v", x)
+	return []byte(s), nil
+}
 
-			estr, ok := r[0].(string)
-			if !ok {
-				bqResp.ErrorMessage = "Invalid key type. expected string"
-				bqResp.Replies = nil
-				break
-			}
+// loadKeys fetches a generation of secret keys: on first use, and again on
+// every reload. It used to run in init() where an unreachable URL panicked
+// the whole instance. A key that doesn't decrypt what its own public key
+// encrypts is never swapped in.
+func loadKeys(ctx context.Context) (_ *secretKeys, err error) {
 
-			e, err := base64.StdEncoding.DecodeString(estr)
-			if err != nil {
-				bqResp.ErrorMessage = "Invalid key type. expected string"
-				bqResp.Replies = nil
-				break
-			}
+	defer func() { metrics.KeyLoad("secret", err) }()
 
-			//  use goroutines heres but keep the order
-			wait.Add(1)
-			go func(j int) {
-				defer wait.Done()
-				for {
-					select {
-					case <-ctx.Done():
-						return
-					default:
-						ec, err := decrypt(e)
-						if err != nil {
-							bqResp.ErrorMessage = fmt.Sprintf("Error decrypting row %d", j)
-							bqResp.Replies = nil
-							cancel()
-							return
-						}
-						objs[j] = base64.StdEncoding.EncodeToString(ec)
-						return
-					}
-				}
-			}(i)
-		}
+	url := secretKeyURL
+	if u := os.Getenv(secretKeyURLEnv); u != "" {
+		url = u
+	}
+	secBytes, err := fhe.ReadKey(ctx, url)
+	if err != nil {
+		return nil, err
+	}
 
-		wait.Wait()
-		if bqResp.ErrorMessage != "" {
-			bqResp.Replies = nil
-		} else {
-			bqResp.Replies = objs
+	params := bfv.DefaultParams[bfv.PN12QP109]
+	key, err := fhe.UnmarshalSecretKey(params, secBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid secret Key %v", err)
+	}
+	pk := bfv.NewKeyGenerator(params).GenPublicKey(key)
+	g := &secretKeys{sk: key, pk: pk}
+	if g.id, err = fhe.KeyID(pk); err != nil {
+		return nil, err
+	}
+	if err := g.check(); err != nil {
+		return nil, err
+	}
+	if os.Getenv(fhe.EqSecretKeyURLEnv) != "" {
+		if err := g.loadEq(ctx); err != nil {
+			return nil, err
 		}
 	}
+	slog.Info("secret key loaded", "keyId", g.id)
+	return g, nil
+}
 
-	b, err := json.Marshal(bqResp)
+// check encrypts a known value under the public key made from the secret
+// key and decrypts it back.
+func (g *secretKeys) check() error {
+	params := fhe.DefaultParams()
+	return fhe.CheckKeys(params, bfv.NewEncoder(params), bfv.NewEncryptorFromPk(params, g.pk), bfv.NewDecryptor(params, g.sk))
+}
+
+// loadEq fetches the EqParams secret key FHE_EQ_SECRET_KEY_URL points at,
+// on the first fhe_eq result decrypted.
+func (g *secretKeys) loadEq(ctx context.Context) (err error) {
+
+	g.eqMu.Lock()
+	defer g.eqMu.Unlock()
+	if g.eqSk != nil {
+		return nil
+	}
+	defer func() { metrics.KeyLoad("eq_secret", err) }()
+
+	url := os.Getenv(fhe.EqSecretKeyURLEnv)
+	if url == "" {
+		return fmt.Errorf("%s is not set", fhe.EqSecretKeyURLEnv)
+	}
+	secBytes, err := fhe.ReadKey(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	params := fhe.EqParams()
+	key, err := fhe.UnmarshalSecretKey(params, secBytes)
+	if err != nil {
+		return fmt.Errorf("Invalid secret Key %v", err)
+	}
+	g.eqSk, g.eqPk = key, bfv.NewKeyGenerator(params).GenPublicKey(key)
+	return nil
+}
+
+func init() {
+
+	var err error
+	if err := logging.InitFromEnv(); err != nil {
+		panic(err)
+	}
+	mac, err = fhe.NewMACFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	if err := fhe.RequireMAC(mac); err != nil {
+		slog.Error("every ciphertext will be refused", "error", err)
+	} else if mac == nil {
+		slog.Warn("ciphertext integrity checks are disabled", "set", fhe.MACDisabledEnv)
+	}
+	if err := tracing.InitFromEnv("fhe-decrypt"); err != nil {
+		panic(err)
+	}
+}
+
+func FHE_DECRYPT(w http.ResponseWriter, r *http.Request) {
+
+	ctx, err := pin(r.Context())
 	if err != nil {
-		http.Error(w, fmt.Sprintf("can't convert response to JSON %v", err), http.StatusInternalServerError)
+		bq.WriteError(w, fmt.Errorf("External Function error: can't load secret key %w", err))
 		return
 	}
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// pin pins a batch to the current keys and labels its spans with their
+// KeyID.
+func pin(ctx context.Context) (context.Context, error) {
+	ctx, err := ring.Pin(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	k, err := ring.From(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	return bq.WithKeyID(ctx, k.id), nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(b)
+// Batch runs a batch of the gRPC API pinned to the current keys, as
+// FHE_DECRYPT does one from BigQuery.
+func Batch(ctx context.Context, bqReq *bq.Request) *bq.Response {
+	ctx, err := pin(ctx)
+	if err != nil {
+		return bq.ErrorResponse(fmt.Errorf("External Function error: can't load secret key %w", err))
+	}
+	return handler.Do(ctx, bqReq)
 }
 
-func main() {
+// startup fetches the secret keys and checks that a known value encrypted
+// under the public key made from the secret key decrypts back. It fails,
+// and so does /readyz, while there is no MAC key to verify ciphertexts with.
+func startup() error {
+	if err := fhe.RequireMAC(mac); err != nil {
+		return err
+	}
+	g, err := ring.Get(context.Background())
+	if err != nil {
+		return err
+	}
+	return g.check()
+}
 
-	http.HandleFunc("/", FHE_DECRYPT)
-	server := &http.Server{
-		Addr: ":8080",
+// Server is FHE_DECRYPT run as a server by cmd/server, eg on Cloud Run: it
+// loads the keys at startup, serves the probes and the gRPC API, reloads the
+// keys and drains on SIGTERM. Deployed as a function, none of that runs.
+func Server() *service.Service {
+	return &service.Service{
+		Handler:  http.HandlerFunc(FHE_DECRYPT),
+		Batch:    Batch,
+		Startup:  startup,
+		Reload:   ring.Reload,
+		WatchEnv: []string{secretKeyURLEnv, fhe.EqSecretKeyURLEnv},
 	}
-	http2.ConfigureServer(server, &http2.Server{})
-	log.Println("Starting Server..")
-	err := server.ListenAndServe()
-	log.Fatalf("Unable to start Server %v", err)
 }
@@ -0,0 +1,175 @@
+package decrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"example.com/fhe"
+	"example.com/fhe/bq"
+	"github.com/ldsec/lattigo/bfv"
+)
+
+func TestMain(m *testing.M) {
+	// the tests send untagged ciphertexts unless they set mac
+	os.Setenv(fhe.MACDisabledEnv, "1")
+	os.Exit(m.Run())
+}
+
+// useTestKey installs a fresh key pair in place of the one loadKeys fetches.
+func useTestKey() *bfv.PublicKey {
+	params := bfv.DefaultParams[bfv.PN12QP109]
+	testSk, testPk := bfv.NewKeyGenerator(params).GenKeyPair()
+	ring.Set(&secretKeys{sk: testSk, pk: testPk})
+	return testPk
+}
+
+func testCiphertext(t testing.TB, pk *bfv.PublicKey, v uint64) []byte {
+	t.Helper()
+	params := bfv.DefaultParams[bfv.PN12QP109]
+	pt := bfv.NewPlaintext(params)
+	bfv.NewEncoder(params).EncodeUint([]uint64{v}, pt)
+	b, err := bfv.NewEncryptorFromPk(params, pk).EncryptNew(pt).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func FuzzFHE_DECRYPT(f *testing.F) {
+	pk := useTestKey()
+	x := testCiphertext(f, pk, 3)
+	f.Add(x)
+	f.Add(x[:2])
+	f.Add([]byte{63, 1})
+
+	f.Fuzz(func(t *testing.T, x []byte) {
+		body, err := json.Marshal(&bq.Request{
+			Calls: [][]interface{}{{base64.StdEncoding.EncodeToString(x)}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		FHE_DECRYPT(rec, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+
+		resp := &bq.Response{}
+		if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+			t.Fatalf("response is not JSON: %v", err)
+		}
+		if resp.ErrorMessage == "" && len(resp.Replies) != 1 {
+			t.Fatalf("got %d replies, want 1", len(resp.Replies))
+		}
+	})
+}
+
+func TestStartup(t *testing.T) {
+	useTestKey()
+	if err := startup(); err != nil {
+		t.Fatalf("startup() = %v", err)
+	}
+
+	// a secret key that doesn't match the public key fails the self-test
+	params := bfv.DefaultParams[bfv.PN12QP109]
+	kg := bfv.NewKeyGenerator(params)
+	ring.Set(&secretKeys{sk: kg.GenSecretKey(), pk: kg.GenPublicKey(kg.GenSecretKey())})
+	defer useTestKey()
+	if err := startup(); err == nil {
+		t.Errorf("startup() passed with mismatched keys")
+	}
+}
+
+// Without a MAC key decrypt refuses every ciphertext, and fails its startup,
+// unless FHE_MAC_DISABLED says to take them untagged.
+func TestMACRequired(t *testing.T) {
+	pk := useTestKey()
+	x := testCiphertext(t, pk, 3)
+	decrypt := func(ct []byte) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(&bq.Request{Calls: [][]interface{}{{base64.StdEncoding.EncodeToString(ct)}}})
+		rec := httptest.NewRecorder()
+		FHE_DECRYPT(rec, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+		return rec
+	}
+
+	t.Setenv(fhe.MACDisabledEnv, "")
+	if err := startup(); !errors.Is(err, fhe.ErrMACRequired) {
+		t.Errorf("startup() without a MAC key = %v, want ErrMACRequired", err)
+	}
+	if rec := decrypt(x); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), fhe.MACDisabledEnv) {
+		t.Errorf("untagged ciphertext without a MAC key: %d %s", rec.Code, rec.Body)
+	}
+
+	key := bytes.Repeat([]byte{7}, fhe.MinMACKeyLen)
+	m, err := fhe.NewMAC(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac = m
+	defer func() { mac = nil }()
+	sealed, err := m.Seal(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := decrypt(sealed); rec.Code != http.StatusOK {
+		t.Errorf("tagged ciphertext: %d %s", rec.Code, rec.Body)
+	}
+	if rec := decrypt(x); rec.Code != http.StatusBadRequest {
+		t.Errorf("untagged ciphertext with a MAC key: %d %s", rec.Code, rec.Body)
+	}
+	if err := startup(); err != nil {
+		t.Errorf("startup() with a MAC key = %v", err)
+	}
+}
+
+// fhe_decrypt_stats refuses sums that wrapped modulo T, and counts of
+// values up to max_abs that could have.
+func TestDecryptStats(t *testing.T) {
+	pk := useTestKey()
+	params := fhe.DefaultParams()
+	envelope := func(count int64, sum, sumSq uint64) string {
+		a := &fhe.Aggregate{Count: count}
+		var err error
+		if a.Sum, err = fhe.UnmarshalCiphertext(params, testCiphertext(t, pk, sum)); err != nil {
+			t.Fatal(err)
+		}
+		if a.SumSq, err = fhe.UnmarshalCiphertext(params, testCiphertext(t, pk, sumSq)); err != nil {
+			t.Fatal(err)
+		}
+		b, err := a.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	stats := func(x string, udc map[string]string) *bq.Response {
+		udc["mode"] = "decrypt_stats"
+		body, _ := json.Marshal(&bq.Request{Calls: [][]interface{}{{x}}, UserDefinedContext: udc})
+		rec := httptest.NewRecorder()
+		FHE_DECRYPT(rec, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+		resp := &bq.Response{}
+		if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+			t.Fatalf("response is not JSON: %v", err)
+		}
+		return resp
+	}
+
+	// 1 and 2
+	if resp := stats(envelope(2, 3, 5), map[string]string{}); resp.ErrorMessage != "" || !strings.Contains(resp.Replies[0], `"mean":1.5`) {
+		t.Errorf("stats of 1 and 2: %+v", resp)
+	}
+	// 200 squares to 40000, past T/2, and decodes as a negative sum of squares
+	if resp := stats(envelope(1, 200, 40000), map[string]string{}); !strings.Contains(resp.ErrorMessage, "wrap") {
+		t.Errorf("wrapped sum of squares: %+v", resp)
+	}
+	for v, want := range map[string]string{"200": "wrap", "x": fhe.StatsMaxAbsContextKey} {
+		if resp := stats(envelope(2, 3, 5), map[string]string{fhe.StatsMaxAbsContextKey: v}); !strings.Contains(resp.ErrorMessage, want) {
+			t.Errorf("max_abs %s: %+v", v, resp)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package emulator
+
+import (
+	"fmt"
+	"testing"
+)
+
+// batchSizes are the max_batching_rows the benchmarks send: a row at a time,
+// DefaultMaxBatchingRows and the most BigQuery sends with it unset.
+var batchSizes = []int{1, DefaultMaxBatchingRows, 1000}
+
+// BenchmarkFunctions sends batches of fhe_encrypt, fhe_decrypt and every
+// evaluator through the HTTP handlers, so anything added to Functions with a
+// Plain is benchmarked too. Run it, without a log line per batch, with
+//
+//	FHE_LOG_LEVEL=warn go test -run '^$' -bench Functions/add .
+func BenchmarkFunctions(b *testing.B) {
+	e := newEmulator(b)
+	rows := batchSizes[len(batchSizes)-1]
+	plain := make([][]interface{}, rows)
+	for i := range plain {
+		plain[i] = []interface{}{float64(i%3 + 1)}
+	}
+	// encrypted once and shared, which the functions can't tell apart from
+	// a column of distinct values
+	cts, err := e.Call("fhe_encrypt", plain)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, name := range append([]string{"fhe_encrypt", "fhe_decrypt"}, Evaluators()...) {
+		calls := plain
+		if name != "fhe_encrypt" {
+			calls = make([][]interface{}, rows)
+			for i := range calls {
+				for j := 0; j < Functions[name].Args; j++ {
+					calls[i] = append(calls[i], cts[(i+j)%len(cts)])
+				}
+			}
+		}
+		for _, size := range batchSizes {
+			b.Run(fmt.Sprintf("%s/rows=%d", name[len("fhe_"):], size), func(b *testing.B) {
+				e.MaxBatchingRows = size
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := e.Call(name, calls[:size]); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(b.N*size)/b.Elapsed().Seconds(), "rows/s")
+			})
+		}
+	}
+}
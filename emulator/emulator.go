@@ -0,0 +1,365 @@
+// Package emulator runs every remote function in-process behind httptest and
+// calls them the way BigQuery does, so queries from the README can be checked
+// without a GCP project or network access.
+package emulator
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"example.com/add"
+	"example.com/decrypt"
+	"example.com/encrypt"
+	"example.com/fhe"
+	"example.com/fhe/bq"
+	"example.com/mul"
+	"example.com/neg"
+	"example.com/sub"
+	"github.com/ldsec/lattigo/bfv"
+)
+
+// Function describes a remote function: the handler behind it and what it
+// computes over plaintext.
+type Function struct {
+	Handler http.HandlerFunc
+	Args    int
+
+	// Plain is the function over integers modulo T; nil for the identity
+	// (encrypt and decrypt) and for functions that don't map values to a
+	// value.
+	Plain func(args []int64) int64
+
+	// MulDepth is the multiplicative depth the function consumes.
+	MulDepth int
+
+	// Context is added to the user_defined_context, which otherwise only
+	// has the mode: the name without fhe_.
+	Context map[string]string
+}
+
+// Functions maps the remote function names used in the README to their
+// handlers. Anything added here is picked up by Plain and the property tests.
+var Functions = map[string]Function{
+	"fhe_encrypt": {Handler: encrypt.FHE_ENCRYPT, Args: 1},
+	"fhe_decrypt": {Handler: decrypt.FHE_DECRYPT, Args: 1},
+	// returns a JSON array of the first n slots
+	"fhe_decrypt_packed": {Handler: decrypt.FHE_DECRYPT, Args: 2},
+	"fhe_add": {Handler: add.FHE_ADD, Args: 2, Plain: func(a []int64) int64 {
+		return a[0] + a[1]
+	}},
+	"fhe_sub": {Handler: sub.FHE_SUB, Args: 2, Plain: func(a []int64) int64 {
+		return a[0] - a[1]
+	}},
+	"fhe_mul": {Handler: mul.FHE_MUL, Args: 2, MulDepth: 1, Plain: func(a []int64) int64 {
+		return a[0] * a[1]
+	}},
+	"fhe_neg": {Handler: neg.FHE_NEG, Args: 1, Plain: func(a []int64) int64 {
+		return -a[0]
+	}},
+	"fhe_distance2": {Handler: mul.FHE_MUL, Args: 4, MulDepth: 1, Plain: func(a []int64) int64 {
+		return (a[0]-a[2])*(a[0]-a[2]) + (a[1]-a[3])*(a[1]-a[3])
+	}},
+	// takes arrays and returns packed distances, so it has no Plain
+	"fhe_nearest": {Handler: mul.FHE_MUL, Args: 4, MulDepth: 1},
+	// public weights, arrays again
+	"fhe_dot":          {Handler: mul.FHE_MUL, Args: 2, MulDepth: 1},
+	"fhe_weighted_sum": {Handler: mul.FHE_MUL, Args: 2, MulDepth: 1},
+	// an envelope of sum, sum of squares and count for fhe_decrypt_stats
+	"fhe_aggregate":     {Handler: mul.FHE_MUL, Args: 1, MulDepth: 1},
+	"fhe_decrypt_stats": {Handler: decrypt.FHE_DECRYPT, Args: 1},
+	// one-hot categories and their sums, decrypted to a JSON array
+	"fhe_encrypt_onehot":    {Handler: encrypt.FHE_ENCRYPT, Args: 2},
+	"fhe_sum":               {Handler: add.FHE_ADD, Args: 1},
+	"fhe_masked_sum":        {Handler: mul.FHE_MUL, Args: 2, MulDepth: 1},
+	"fhe_decrypt_histogram": {Handler: decrypt.FHE_DECRYPT, Args: 2},
+	// a linear table works under DefaultParams; it interpolates to 2x+3
+	"fhe_lut": {Handler: mul.FHE_MUL, Args: 1, MulDepth: 1, Context: map[string]string{
+		fhe.LUTContextKey: `{"1": 5, "2": 7, "3": 9}`,
+	}, Plain: func(a []int64) int64 {
+		return 2*a[0] + 3
+	}},
+	// decrypted and encrypted again by the decrypt service, which resets
+	// the noise: it gives back a level of MulDepth that Plain can't show
+	"fhe_refresh": {Handler: decrypt.FHE_DECRYPT, Args: 1, Plain: func(a []int64) int64 {
+		return a[0]
+	}},
+	// a fresh encryption of zero added, by the encrypt service
+	"fhe_rerandomize": {Handler: encrypt.FHE_ENCRYPT, Args: 1, Plain: func(a []int64) int64 {
+		return a[0]
+	}},
+	// under EqParams, which the other functions only add and multiply; the
+	// keys come from UseEqKeys
+	"fhe_encrypt_eq": {Handler: encrypt.FHE_ENCRYPT, Args: 1},
+	"fhe_eq":         {Handler: mul.FHE_MUL, Args: 2, MulDepth: fhe.EqMulDepth - 1},
+}
+
+// Evaluators returns the names of the functions that compute on ciphertexts,
+// which is everything but encrypt and decrypt.
+func Evaluators() []string {
+	var names []string
+	for name, f := range Functions {
+		if f.Plain != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultMaxBatchingRows is how many rows go into one request unless
+// Emulator.MaxBatchingRows says otherwise.
+const DefaultMaxBatchingRows = 50
+
+// Retries is how many more times a request answered 429 or 503 is sent,
+// with a growing delay, as BigQuery retries them.
+const Retries = 3
+
+var (
+	keysOnce sync.Once
+	keysErr  error
+	keySrv   *httptest.Server
+
+	// the key pair encrypt and decrypt are given, and the evaluation keys
+	// for it
+	testSk   *bfv.SecretKey
+	testPk   *bfv.PublicKey
+	testKeys *fhe.EvalKeys
+)
+
+// useTestKeys generates one key pair per process and points encrypt and
+// decrypt at a local server for it. Those functions keep the first key they
+// load so every Emulator has to share it.
+func useTestKeys() error {
+	keysOnce.Do(func() {
+		testSk, testPk = bfv.NewKeyGenerator(fhe.DefaultParams()).GenKeyPair()
+		pub, err := testPk.MarshalBinary()
+		if err != nil {
+			keysErr = err
+			return
+		}
+		sec, err := testSk.MarshalBinary()
+		if err != nil {
+			keysErr = err
+			return
+		}
+		testKeys = fhe.GenEvalKeys(fhe.DefaultParams(), testSk)
+		rlk, err := testKeys.Relin.MarshalBinary()
+		if err != nil {
+			keysErr = err
+			return
+		}
+		rot, err := testKeys.Rotation.MarshalBinary()
+		if err != nil {
+			keysErr = err
+			return
+		}
+
+		mux := http.NewServeMux()
+		for path, key := range map[string][]byte{"/pub.b64": pub, "/sec.b64": sec, "/rlk.b64": rlk, "/rot.b64": rot} {
+			key := key
+			mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, base64.StdEncoding.EncodeToString(key))
+			})
+		}
+		keySrv = httptest.NewServer(mux)
+		os.Setenv(fhe.PublicKeyURLEnv, keySrv.URL+"/pub.b64")
+		os.Setenv(fhe.SecretKeyURLEnv, keySrv.URL+"/sec.b64")
+		os.Setenv(fhe.RelinKeyURLEnv, keySrv.URL+"/rlk.b64")
+		os.Setenv(fhe.RotationKeyURLEnv, keySrv.URL+"/rot.b64")
+		// the functions run without MAC keys, as the README examples do
+		os.Setenv(fhe.MACDisabledEnv, "1")
+	})
+	return keysErr
+}
+
+var (
+	eqKeysOnce sync.Once
+	eqKeysErr  error
+	eqKeySrv   *httptest.Server
+)
+
+// UseEqKeys generates an EqParams key pair and relinearization key and
+// points the fhe_eq functions at them. It is separate from the other keys as
+// making and serving them takes a while.
+func UseEqKeys() error {
+	eqKeysOnce.Do(func() {
+		params := fhe.EqParams()
+		kg := bfv.NewKeyGenerator(params)
+		sk, pk := kg.GenKeyPair()
+		keys := map[string]interface{ MarshalBinary() ([]byte, error) }{
+			"/eq-pub.bin": pk,
+			"/eq-sec.bin": sk,
+			"/eq-rlk.bin": kg.GenRelinKey(sk, fhe.RelinDegree-1),
+		}
+		mux := http.NewServeMux()
+		for path, key := range keys {
+			b, err := key.MarshalBinary()
+			if err != nil {
+				eqKeysErr = err
+				return
+			}
+			mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+				w.Write(b)
+			})
+		}
+		eqKeySrv = httptest.NewServer(mux)
+		os.Setenv(fhe.EqPublicKeyURLEnv, eqKeySrv.URL+"/eq-pub.bin")
+		os.Setenv(fhe.EqSecretKeyURLEnv, eqKeySrv.URL+"/eq-sec.bin")
+		os.Setenv(fhe.EqRelinKeyURLEnv, eqKeySrv.URL+"/eq-rlk.bin")
+	})
+	return eqKeysErr
+}
+
+// Emulator plays the part of BigQuery in front of the remote functions.
+type Emulator struct {
+	ProjectID   string
+	SessionUser string
+
+	// rows per request, like max_batching_rows on CREATE FUNCTION
+	MaxBatchingRows int
+
+	servers map[string]*httptest.Server
+	jobID   string
+
+	mu       sync.Mutex
+	requests int
+}
+
+// New starts every function in Functions on its own httptest server.
+func New() (*Emulator, error) {
+	if err := useTestKeys(); err != nil {
+		return nil, err
+	}
+
+	e := &Emulator{
+		ProjectID:       "fhe-emulator",
+		SessionUser:     "alice@example.com",
+		MaxBatchingRows: DefaultMaxBatchingRows,
+		servers:         make(map[string]*httptest.Server),
+		jobID:           newID(),
+	}
+	for name, f := range Functions {
+		e.servers[name] = httptest.NewServer(f.Handler)
+	}
+	return e, nil
+}
+
+// Close shuts the function servers down.
+func (e *Emulator) Close() {
+	for _, s := range e.servers {
+		s.Close()
+	}
+}
+
+// Requests returns how many batches have been sent so far.
+func (e *Emulator) Requests() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.requests
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Call sends calls to fn split into batches of MaxBatchingRows, the way
+// BigQuery does, and returns one reply per call.
+func (e *Emulator) Call(fn string, calls [][]interface{}) ([]interface{}, error) {
+	srv, ok := e.servers[fn]
+	if !ok {
+		return nil, fmt.Errorf("emulator: unknown function %s", fn)
+	}
+
+	size := e.MaxBatchingRows
+	if size <= 0 {
+		size = DefaultMaxBatchingRows
+	}
+
+	replies := make([]interface{}, 0, len(calls))
+	for start := 0; start < len(calls); start += size {
+		end := start + size
+		if end > len(calls) {
+			end = len(calls)
+		}
+		r, err := e.send(srv.URL, fn, calls[start:end])
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, r...)
+	}
+	return replies, nil
+}
+
+func (e *Emulator) send(url string, fn string, calls [][]interface{}) ([]interface{}, error) {
+	udc := map[string]string{bq.ModeContextKey: fn[len("fhe_"):]}
+	for k, v := range Functions[fn].Context {
+		udc[k] = v
+	}
+	body, err := json.Marshal(&bq.Request{
+		RequestId:          newID(),
+		Caller:             fmt.Sprintf("//bigquery.googleapis.com/projects/%s/jobs/%s", e.ProjectID, e.jobID),
+		SessionUser:        e.SessionUser,
+		UserDefinedContext: udc,
+		Calls:              calls,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		replies, retry, err := e.post(url, fn, body, len(calls))
+		if !retry || attempt == Retries {
+			return replies, err
+		}
+		time.Sleep(time.Duration(10<<attempt) * time.Millisecond)
+	}
+}
+
+// post sends one request and reports whether BigQuery would retry it.
+func (e *Emulator) post(url, fn string, body []byte, calls int) (_ []interface{}, retry bool, _ error) {
+	e.mu.Lock()
+	e.requests++
+	e.mu.Unlock()
+
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+
+	// replies are decoded loosely since BigQuery accepts any JSON value
+	var bqResp struct {
+		Replies      []interface{} `json:"replies"`
+		ErrorMessage string        `json:"errorMessage"`
+	}
+	b, _ := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(b, &bqResp); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, retry, fmt.Errorf("emulator: %s returned %s: %s", fn, resp.Status, b)
+		}
+		return nil, false, fmt.Errorf("emulator: %s returned invalid JSON: %v", fn, err)
+	}
+	if bqResp.ErrorMessage != "" {
+		return nil, retry, fmt.Errorf("emulator: %s: %s", fn, bqResp.ErrorMessage)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, retry, fmt.Errorf("emulator: %s returned %s", fn, resp.Status)
+	}
+	if len(bqResp.Replies) != calls {
+		return nil, false, fmt.Errorf("emulator: %s returned %d replies for %d calls", fn, len(bqResp.Replies), calls)
+	}
+	return bqResp.Replies, false, nil
+}
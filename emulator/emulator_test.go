@@ -0,0 +1,451 @@
+package emulator
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"example.com/fhe"
+	"example.com/fhe/bq"
+	"example.com/neg"
+	"github.com/ldsec/lattigo/bfv"
+)
+
+// the scenarios from the README, fhe.xy holds (x,y) -> (3,2)
+var readme = []string{
+	"SAFE_CONVERT_BYTES_TO_STRING(fhe.fhe_decrypt(fhe.fhe_encrypt(3)))",
+	"SAFE_CONVERT_BYTES_TO_STRING(fhe.fhe_decrypt(fhe.fhe_add(ecd1.x, ecd1.y)))",
+	"SAFE_CONVERT_BYTES_TO_STRING(fhe.fhe_decrypt(fhe.fhe_add(fhe.fhe_encrypt(4), fhe.fhe_encrypt(2))))",
+	"SAFE_CONVERT_BYTES_TO_STRING(fhe.fhe_decrypt(fhe.fhe_neg(fhe.fhe_encrypt(4))))",
+	"SAFE_CONVERT_BYTES_TO_STRING(fhe.fhe_decrypt(fhe.fhe_mul(fhe.fhe_encrypt(4), ecd1.y)))",
+	"SAFE_CONVERT_BYTES_TO_STRING(fhe.fhe_decrypt(fhe.fhe_mul(fhe.fhe_add(fhe.fhe_encrypt(4), ecd1.x), ecd1.y)))",
+	"SAFE_CONVERT_BYTES_TO_STRING(fhe.fhe_decrypt(fhe.fhe_sub(ecd1.x, ecd1.y)))",
+	"SAFE_CONVERT_BYTES_TO_STRING(fhe.fhe_decrypt(fhe.fhe_lut(ecd1.x)))",
+	"SAFE_CONVERT_BYTES_TO_STRING(fhe.fhe_decrypt(fhe.fhe_rerandomize(fhe.fhe_mul(ecd1.x, ecd1.y))))",
+}
+
+func newEmulator(t testing.TB) *Emulator {
+	t.Helper()
+	e, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	return e
+}
+
+func check(t *testing.T, e *Emulator, expr string, plain []map[string]int64, table Table) {
+	t.Helper()
+	want, err := Plain(expr, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := e.Query(expr, table)
+	if err != nil {
+		t.Fatalf("%s: %v", expr, err)
+	}
+	for i := range want {
+		if got[i] != strconv.FormatInt(want[i], 10) {
+			t.Errorf("%s row %d = %v, want %d", expr, i, got[i], want[i])
+		}
+	}
+}
+
+func TestREADME(t *testing.T) {
+	e := newEmulator(t)
+	plain := []map[string]int64{{"x": 3, "y": 2}}
+	table, err := e.EncryptTable(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expr := range readme {
+		check(t, e, expr, plain, table)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	e := newEmulator(t)
+	e.MaxBatchingRows = 7
+
+	var plain []map[string]int64
+	for i := int64(0); i < 30; i++ {
+		plain = append(plain, map[string]int64{"x": i, "y": 100 - 3*i})
+	}
+	table, err := e.EncryptTable(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := e.Requests()
+	check(t, e, "SAFE_CONVERT_BYTES_TO_STRING(decrypt(mul(add(encrypt(4), x), y)))", plain, table)
+	// encrypt, add, mul and decrypt each take ceil(30/7) batches
+	if got := e.Requests() - before; got != 4*5 {
+		t.Errorf("sent %d batches, want %d", got, 4*5)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	e := newEmulator(t)
+	for _, expr := range []string{
+		"decrypt(x)",
+		"fhe_nope(encrypt(1))",
+		"add(encrypt(1))",
+		"decrypt(encrypt(1)",
+	} {
+		if _, err := e.Query(expr, nil); err == nil {
+			t.Errorf("%s: expected an error", expr)
+		}
+	}
+}
+
+// a function answering 503 or 429 is retried like BigQuery retries it; a
+// 400 fails the query at once
+func TestRetry(t *testing.T) {
+	e := newEmulator(t)
+	for _, c := range []struct {
+		status, fails, sent int
+		ok                  bool
+	}{
+		{http.StatusServiceUnavailable, 2, 3, true},
+		{http.StatusTooManyRequests, 1, 2, true},
+		{http.StatusServiceUnavailable, Retries + 1, Retries + 1, false},
+		{http.StatusBadRequest, 1, 1, false},
+	} {
+		fails := c.fails
+		e.servers["fhe_neg"].Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if fails > 0 {
+				fails--
+				bq.WriteResponse(w, &bq.Response{ErrorMessage: "not now", Status: c.status})
+				return
+			}
+			neg.FHE_NEG(w, r)
+		})
+		before := e.Requests()
+		_, err := e.Query("SAFE_CONVERT_BYTES_TO_STRING(decrypt(neg(encrypt(4))))", nil)
+		if (err == nil) != c.ok {
+			t.Errorf("%d %d times: got %v", c.status, c.fails, err)
+		}
+		// encrypt, neg and, if neg answered, decrypt
+		want := 1 + c.sent
+		if c.ok {
+			want++
+		}
+		if got := e.Requests() - before; got != want {
+			t.Errorf("%d %d times: sent %d batches, want %d", c.status, c.fails, got, want)
+		}
+	}
+}
+
+// the ride-sharing query: squared distances from each rider to every driver,
+// packed into one ciphertext per rider
+func TestNearest(t *testing.T) {
+	e := newEmulator(t)
+	riders := []map[string]int64{{"x": 2, "y": 3}, {"x": -5, "y": 0}}
+	drivers := []map[string]int64{{"x": 0, "y": 0}, {"x": 3, "y": 4}, {"x": -1, "y": 10}, {"x": 7, "y": -2}}
+	rt, err := e.EncryptTable(riders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dt, err := e.EncryptTable(drivers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var xs, ys []interface{}
+	for _, d := range dt {
+		xs = append(xs, d["x"])
+		ys = append(ys, d["y"])
+	}
+
+	var calls [][]interface{}
+	for _, r := range rt {
+		calls = append(calls, []interface{}{r["x"], r["y"], xs, ys})
+	}
+	packed, err := e.Call("fhe_nearest", calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls = calls[:0]
+	for _, p := range packed {
+		calls = append(calls, []interface{}{p, float64(len(drivers))})
+	}
+	got, err := e.Call("fhe_decrypt_packed", calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range riders {
+		var want []string
+		for _, d := range drivers {
+			dx, dy := r["x"]-d["x"], r["y"]-d["y"]
+			want = append(want, strconv.FormatInt(dx*dx+dy*dy, 10))
+		}
+		if w := "[" + strings.Join(want, ",") + "]"; got[i] != w {
+			t.Errorf("rider %d: got %v, want %s", i, got[i], w)
+		}
+	}
+}
+
+// a linear score with public weights, over one ciphertext per feature and
+// over a vector packed into one ciphertext
+func TestScore(t *testing.T) {
+	e := newEmulator(t)
+	features := []map[string]int64{{"a": 3, "b": -1, "c": 7}, {"a": 0, "b": 12, "c": -2}}
+	weights := []interface{}{float64(2), float64(-5), float64(10)}
+	table, err := e.EncryptTable(features)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := fhe.DefaultParams()
+	encoder := bfv.NewEncoder(params)
+	encryptor := bfv.NewEncryptorFromPk(params, testPk)
+
+	var sums, dots [][]interface{}
+	var want []string
+	for i, r := range table {
+		sums = append(sums, []interface{}{[]interface{}{r["a"], r["b"], r["c"]}, weights})
+		f := features[i]
+		b, err := encryptor.EncryptNew(fhe.EncodeSlots(params, encoder, []int64{f["a"], f["b"], f["c"]})).MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dots = append(dots, []interface{}{base64.StdEncoding.EncodeToString(b), weights})
+		want = append(want, strconv.FormatInt(2*f["a"]-5*f["b"]+10*f["c"], 10))
+	}
+
+	for fn, calls := range map[string][][]interface{}{"fhe_weighted_sum": sums, "fhe_dot": dots} {
+		scores, err := e.Call(fn, calls)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dec [][]interface{}
+		for _, s := range scores {
+			dec = append(dec, []interface{}{s})
+		}
+		got, err := e.Call("fhe_decrypt", dec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range want {
+			s, _ := base64.StdEncoding.DecodeString(got[i].(string))
+			if string(s) != want[i] {
+				t.Errorf("%s row %d = %s, want %s", fn, i, s, want[i])
+			}
+		}
+	}
+}
+
+// AVG and VARIANCE of an encrypted column, aggregated in two parts and
+// merged the way a GROUP BY over buckets would
+func TestStats(t *testing.T) {
+	e := newEmulator(t)
+	var plain []map[string]int64
+	for _, v := range []int64{4, -2, 9, 0, 7, 12} {
+		plain = append(plain, map[string]int64{"x": v})
+	}
+	table, err := e.EncryptTable(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parts [][]interface{}
+	for _, rows := range []Table{table[:4], table[4:]} {
+		var xs []interface{}
+		for _, r := range rows {
+			xs = append(xs, r["x"])
+		}
+		parts = append(parts, []interface{}{xs})
+	}
+	aggs, err := e.Call("fhe_aggregate", parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agg, err := e.Call("fhe_aggregate", [][]interface{}{{aggs}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := e.Call("fhe_decrypt_stats", [][]interface{}{agg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"count":6,"sum":30,"sum_of_squares":294,"mean":5,"variance":24,"sample_variance":28.8}`
+	if got[0] != want {
+		t.Errorf("got %v, want %s", got[0], want)
+	}
+	if _, err := e.Call("fhe_decrypt_stats", [][]interface{}{{table[0]["x"]}}); err == nil {
+		t.Errorf("fhe_decrypt_stats of a ciphertext succeeded")
+	}
+}
+
+// WHERE category = 3 over an EqParams column, as a count and a masked sum
+func TestEq(t *testing.T) {
+	if testing.Short() {
+		t.Skip("EqParams ciphertexts take seconds per fhe_eq")
+	}
+	if err := UseEqKeys(); err != nil {
+		t.Fatal(err)
+	}
+	e := newEmulator(t)
+	// a row of two EqParams ciphertexts is 17 MB of base64
+	e.MaxBatchingRows = 1
+
+	encrypt := func(vs ...int64) []interface{} {
+		var calls [][]interface{}
+		for _, v := range vs {
+			calls = append(calls, []interface{}{float64(v)})
+		}
+		cts, err := e.Call("fhe_encrypt_eq", calls)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cts
+	}
+	category, salary := encrypt(3, 5), encrypt(100, 200)
+	bits, err := e.Call("fhe_eq", [][]interface{}{{category[0], float64(3)}, {category[1], category[0]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	masked, err := e.Call("fhe_mul", [][]interface{}{{bits[0], salary[0]}, {bits[1], salary[1]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ones := []interface{}{float64(1), float64(1)}
+	sums, err := e.Call("fhe_weighted_sum", [][]interface{}{{bits, ones}, {masked, ones}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := e.Call("fhe_decrypt", [][]interface{}{{bits[0]}, {bits[1]}, {sums[0]}, {sums[1]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"1", "0", "1", "100"} {
+		s, _ := base64.StdEncoding.DecodeString(got[i].(string))
+		if string(s) != want {
+			t.Errorf("result %d = %s, want %s", i, s, want)
+		}
+	}
+}
+
+// SELECT dept, COUNT(*), SUM(salary) GROUP BY dept with dept encrypted
+// one-hot, summed in two parts the way a GROUP BY over buckets would
+func TestHistogram(t *testing.T) {
+	e := newEmulator(t)
+	rows := []struct{ dept, salary int64 }{{0, 10}, {2, 30}, {2, 5}, {1, 7}, {0, -2}}
+	var onehot [][]interface{}
+	var plain []map[string]int64
+	for _, r := range rows {
+		onehot = append(onehot, []interface{}{float64(r.dept), float64(3)})
+		plain = append(plain, map[string]int64{"salary": r.salary})
+	}
+	depts, err := e.Call("fhe_encrypt_onehot", onehot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := e.EncryptTable(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var salaries []interface{}
+	for _, r := range table {
+		salaries = append(salaries, r["salary"])
+	}
+
+	counts, err := e.Call("fhe_sum", [][]interface{}{{depts[:3]}, {depts[3:]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts, err = e.Call("fhe_sum", [][]interface{}{{counts}}); err != nil {
+		t.Fatal(err)
+	}
+	totals, err := e.Call("fhe_masked_sum", [][]interface{}{{depts, salaries}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := e.Call("fhe_decrypt_histogram", [][]interface{}{{counts[0], float64(3)}, {totals[0], float64(3)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"[2,1,2]", "[8,7,35]"} {
+		if got[i] != want {
+			t.Errorf("histogram %d = %v, want %s", i, got[i], want)
+		}
+	}
+	if _, err := e.Call("fhe_decrypt_histogram", [][]interface{}{{counts[0], float64(2)}}); err == nil {
+		t.Errorf("fhe_decrypt_histogram with too few buckets succeeded")
+	}
+}
+
+// fhe_rerandomize changes the bytes of ciphertexts and aggregates but not
+// what they decrypt to
+func TestRerandomize(t *testing.T) {
+	e := newEmulator(t)
+	table, err := e.EncryptTable([]map[string]int64{{"x": 6}, {"x": -3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	product, err := e.Call("fhe_mul", [][]interface{}{{table[0]["x"], table[1]["x"]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	agg, err := e.Call("fhe_aggregate", [][]interface{}{{[]interface{}{table[0]["x"], table[1]["x"]}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := []interface{}{table[0]["x"], product[0], agg[0]}
+	out, err := e.Call("fhe_rerandomize", [][]interface{}{{in[0]}, {in[1]}, {in[2]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range in {
+		if out[i] == in[i] {
+			t.Errorf("fhe_rerandomize left input %d unchanged", i)
+		}
+	}
+
+	got, err := e.Call("fhe_decrypt", [][]interface{}{{out[0]}, {out[1]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"6", "-18"} {
+		s, _ := base64.StdEncoding.DecodeString(got[i].(string))
+		if string(s) != want {
+			t.Errorf("row %d decrypts to %s, want %s", i, s, want)
+		}
+	}
+	stats, err := e.Call("fhe_decrypt_stats", [][]interface{}{{out[2]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stats[0].(string), `{"count":2,"sum":3,"sum_of_squares":45,`) {
+		t.Errorf("fhe_decrypt_stats = %v", stats[0])
+	}
+}
+
+// fhe_refresh lets a pipeline multiply past fhe.MulDepth
+func TestRefresh(t *testing.T) {
+	e := newEmulator(t)
+	var plain []map[string]int64
+	for _, r := range [][3]int64{{3, -2, 5}, {7, 4, -1}} {
+		plain = append(plain, map[string]int64{"x": r[0], "y": r[1], "z": r[2]})
+	}
+	table, err := e.EncryptTable(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProperty(t, e, "fhe_mul(fhe_refresh(fhe_mul(fhe_refresh(fhe_mul(x, y)), z)), x)", plain, table)
+
+	agg, err := e.Call("fhe_aggregate", [][]interface{}{{[]interface{}{table[0]["x"], table[1]["x"]}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agg, err = e.Call("fhe_refresh", [][]interface{}{agg}); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := e.Call("fhe_decrypt_stats", [][]interface{}{agg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stats[0].(string), `{"count":2,"sum":10,"sum_of_squares":58,`) {
+		t.Errorf("fhe_decrypt_stats of a refreshed aggregate = %v", stats[0])
+	}
+}
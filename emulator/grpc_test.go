@@ -0,0 +1,112 @@
+package emulator
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"testing"
+
+	"example.com/add"
+	"example.com/decrypt"
+	"example.com/encrypt"
+	"example.com/fhe/rpc"
+	"example.com/fhe/rpc/fhepb"
+	"example.com/mul"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// newGRPCClient serves the gRPC API of a service on a local port.
+func newGRPCClient(t *testing.T, batch rpc.Batch) fhepb.FHEClient {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := rpc.NewGRPCServer(batch)
+	go g.Serve(l)
+	t.Cleanup(g.Stop)
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return fhepb.NewFHEClient(conn)
+}
+
+func arg(ct []byte) *fhepb.Arg {
+	return &fhepb.Arg{Value: &fhepb.Arg_Ciphertext{Ciphertext: ct}}
+}
+
+// The gRPC API computes what the remote functions do, on ciphertexts they
+// take too.
+func TestGRPC(t *testing.T) {
+	e := newEmulator(t)
+	ctx := context.Background()
+	enc := newGRPCClient(t, encrypt.Batch)
+	dec := newGRPCClient(t, decrypt.Batch)
+	adder := newGRPCClient(t, add.Batch)
+	muler := newGRPCClient(t, mul.Batch)
+
+	x, err := enc.Encrypt(ctx, &fhepb.EncryptRequest{Values: []int64{4, 2, -3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cts := x.Ciphertexts
+	sums, err := adder.Evaluate(ctx, &fhepb.EvaluateRequest{Mode: "add", Rows: []*fhepb.Row{
+		{Args: []*fhepb.Arg{arg(cts[0]), arg(cts[1])}},
+		{Args: []*fhepb.Arg{arg(cts[1]), arg(cts[2])}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prod, err := muler.Evaluate(ctx, &fhepb.EvaluateRequest{Mode: "mul", Rows: []*fhepb.Row{
+		{Args: []*fhepb.Arg{arg(sums.Ciphertexts[0]), arg(cts[2])}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := dec.Decrypt(ctx, &fhepb.DecryptRequest{Ciphertexts: append(sums.Ciphertexts, prod.Ciphertexts...)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Values) != 3 || got.Values[0] != 6 || got.Values[1] != -1 || got.Values[2] != -18 {
+		t.Errorf("decrypted %v, want [6 -1 -18]", got.Values)
+	}
+
+	// a gRPC ciphertext is a BigQuery one without the base64
+	replies, err := e.Call("fhe_decrypt", [][]interface{}{{base64.StdEncoding.EncodeToString(prod.Ciphertexts[0])}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replies[0] != base64.StdEncoding.EncodeToString([]byte("-18")) {
+		t.Errorf("fhe_decrypt of a gRPC product = %v", replies[0])
+	}
+
+	// the unary and streaming aggregates agree
+	agg, err := muler.Aggregate(ctx, &fhepb.AggregateRequest{Ciphertexts: cts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := muler.AggregateStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ct := range cts {
+		if err := st.Send(&fhepb.AggregateRequest{Ciphertexts: [][]byte{ct}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	streamed, err := st.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := dec.Decrypt(ctx, &fhepb.DecryptRequest{Mode: "decrypt_stats", Ciphertexts: [][]byte{agg.Ciphertext, streamed.Ciphertext}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"count":3,"sum":3,"sum_of_squares":29,"mean":1,"variance":8.666666666666666,"sample_variance":13}`
+	if len(stats.Json) != 2 || stats.Json[0] != want || stats.Json[1] != want {
+		t.Errorf("decrypt_stats = %q, want %s twice", stats.Json, want)
+	}
+}
@@ -0,0 +1,63 @@
+package emulator
+
+import (
+	"encoding/base64"
+	"strconv"
+	"testing"
+
+	"example.com/fhe"
+	"github.com/ldsec/lattigo/bfv"
+)
+
+// TestOfflineMatchesOnline checks that fhe.Ops, which the fhe command uses,
+// produce the same bytes as the remote functions.
+func TestOfflineMatchesOnline(t *testing.T) {
+	e := newEmulator(t)
+	params := fhe.DefaultParams()
+	encoder := bfv.NewEncoder(params)
+	encryptor := bfv.NewEncryptorFromPk(params, testPk)
+
+	var args []*bfv.Ciphertext
+	var b64 []interface{}
+	for _, v := range []int64{-7, 12, 3, -40} {
+		ct := encryptor.EncryptNew(fhe.EncodeValue(params, encoder, v))
+		b, err := ct.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, ct)
+		b64 = append(b64, base64.StdEncoding.EncodeToString(b))
+	}
+
+	for name, op := range fhe.Ops {
+		if _, ok := Functions["fhe_"+name]; !ok {
+			t.Errorf("fhe.Ops[%q] has no remote function", name)
+			continue
+		}
+		ct, err := op.Eval(bfv.NewEvaluator(params), testKeys, args[:op.Args])
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		b, err := ct.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		replies, err := e.Call("fhe_"+name, [][]interface{}{b64[:op.Args]})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if replies[0] != base64.StdEncoding.EncodeToString(b) {
+			t.Errorf("fhe.Ops[%q] differs from fhe_%s", name, name)
+		}
+
+		replies, err = e.Call("fhe_decrypt", [][]interface{}{{replies[0]}})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got := fhe.DecodeValue(encoder, bfv.NewDecryptor(params, testSk).DecryptNew(ct))
+		s, _ := base64.StdEncoding.DecodeString(replies[0].(string))
+		if string(s) != strconv.FormatInt(got, 10) {
+			t.Errorf("fhe_decrypt(%s) = %s, offline %d", name, s, got)
+		}
+	}
+}
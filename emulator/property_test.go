@@ -0,0 +1,128 @@
+package emulator
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/fhe"
+)
+
+var (
+	seed  = flag.Int64("seed", 0, "seed for the property tests, 0 picks one from the clock")
+	cases = flag.Int("cases", 32, "rows per property")
+)
+
+func newRand(t *testing.T) *rand.Rand {
+	s := *seed
+	if s == 0 {
+		s = time.Now().UnixNano()
+	}
+	t.Logf("-seed=%d", s)
+	return rand.New(rand.NewSource(s))
+}
+
+// randPlain returns rows of random values in [-T/2, T/2], the range
+// fhe_encrypt takes.
+func randPlain(r *rand.Rand, cols []string) []map[string]int64 {
+	plain := make([]map[string]int64, *cases)
+	for i := range plain {
+		plain[i] = make(map[string]int64)
+		for _, c := range cols {
+			plain[i][c] = randValue(r)
+		}
+	}
+	return plain
+}
+
+// randValue returns a random value in [-T/2, T/2].
+func randValue(r *rand.Rand) int64 {
+	t := int64(fhe.DefaultParams().T)
+	return r.Int63n(t) - t/2
+}
+
+func checkProperty(t *testing.T, e *Emulator, expr string, plain []map[string]int64, table Table) {
+	t.Helper()
+	want, err := Plain(expr, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := e.Query("SAFE_CONVERT_BYTES_TO_STRING(decrypt("+expr+"))", table)
+	if err != nil {
+		t.Fatalf("%s: %v", expr, err)
+	}
+	for i := range want {
+		if got[i] != strconv.FormatInt(want[i], 10) {
+			t.Fatalf("%s with %v = %v, want %d", expr, plain[i], got[i], want[i])
+		}
+	}
+}
+
+// decrypt(op(enc(a), enc(b))) == op(a, b) mod T for every evaluator.
+func TestHomomorphism(t *testing.T) {
+	e := newEmulator(t)
+	r := newRand(t)
+	cols := []string{"a", "b", "c", "d"}
+	plain := randPlain(r, cols)
+	table, err := e.EncryptTable(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range Evaluators() {
+		args := cols[:Functions[name].Args]
+		expr := fmt.Sprintf("%s(%s)", name, strings.Join(args, ", "))
+		t.Run(name, func(t *testing.T) {
+			checkProperty(t, e, expr, plain, table)
+		})
+	}
+}
+
+// randExpr builds a random expression over the columns and encrypted
+// literals that is height calls deep and uses at most muls multiplications
+// on any path.
+func randExpr(r *rand.Rand, ops []string, cols []string, height int, muls int) string {
+	if height == 0 || r.Intn(4) == 0 {
+		if r.Intn(3) == 0 {
+			return fmt.Sprintf("encrypt(%d)", randValue(r))
+		}
+		return cols[r.Intn(len(cols))]
+	}
+
+	for {
+		name := ops[r.Intn(len(ops))]
+		f := Functions[name]
+		if f.MulDepth > muls {
+			continue
+		}
+		args := make([]string, f.Args)
+		for i := range args {
+			args[i] = randExpr(r, ops, cols, height-1, muls-f.MulDepth)
+		}
+		return fmt.Sprintf("%s(%s)", name, strings.Join(args, ", "))
+	}
+}
+
+// random expression trees up to fhe.MulDepth multiplications deep decrypt to
+// the same thing they compute over plaintext
+func TestRandomExpressions(t *testing.T) {
+	e := newEmulator(t)
+	r := newRand(t)
+	cols := []string{"x", "y", "z"}
+	plain := randPlain(r, cols)
+	table, err := e.EncryptTable(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 8; i++ {
+		expr := randExpr(r, Evaluators(), cols, 4, fhe.MulDepth)
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			checkProperty(t, e, expr, plain, table)
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package emulator
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"example.com/fhe"
+	"example.com/fhe/expr"
+)
+
+// Table is a set of rows keyed by column name, like fhe.xy in the README.
+// Encrypted columns hold base64 strings, the way BigQuery sends BYTES.
+type Table []map[string]interface{}
+
+// safeConvert is evaluated locally, the rest are remote functions.
+const safeConvert = "safe_convert_bytes_to_string"
+
+// funcName maps fhe.fhe_add, fhe_add and add to fhe_add.
+func funcName(s string) string {
+	s = expr.FuncName(s)
+	if s == safeConvert {
+		return s
+	}
+	if !strings.HasPrefix(s, "fhe_") {
+		s = "fhe_" + s
+	}
+	return s
+}
+
+// Query evaluates query for every row of table the way BigQuery would: each
+// remote function in the expression receives all rows' arguments in
+// batches. A nil table evaluates query once, like a SELECT without FROM.
+func (e *Emulator) Query(query string, table Table) ([]interface{}, error) {
+	n, err := expr.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	rows := len(table)
+	if table == nil {
+		rows = 1
+	}
+	return e.eval(n, table, rows)
+}
+
+func (e *Emulator) eval(n *expr.Node, table Table, rows int) ([]interface{}, error) {
+	vals := make([]interface{}, rows)
+	switch n.Kind {
+	case expr.Lit:
+		for i := range vals {
+			// BigQuery sends numbers as JSON numbers
+			vals[i] = float64(n.Lit)
+		}
+		return vals, nil
+
+	case expr.Col:
+		for i := range vals {
+			if table == nil {
+				return nil, fmt.Errorf("emulator: column %s without a table", n.Col)
+			}
+			v, ok := table[i][n.Col]
+			if !ok {
+				return nil, fmt.Errorf("emulator: unknown column %s", n.Col)
+			}
+			vals[i] = v
+		}
+		return vals, nil
+	}
+
+	args := make([][]interface{}, len(n.Args))
+	for i, a := range n.Args {
+		v, err := e.eval(a, table, rows)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	fn := funcName(n.Fn)
+	if fn == safeConvert {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("emulator: %s takes 1 argument", safeConvert)
+		}
+		for i, v := range args[0] {
+			s, _ := v.(string)
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, fmt.Errorf("emulator: %s: %v", safeConvert, err)
+			}
+			vals[i] = string(b)
+		}
+		return vals, nil
+	}
+
+	calls := make([][]interface{}, rows)
+	for i := range calls {
+		calls[i] = make([]interface{}, len(args))
+		for j := range args {
+			calls[i][j] = args[j][i]
+		}
+	}
+	return e.Call(fn, calls)
+}
+
+// EncryptTable encrypts every column of plain with fhe_encrypt, which is what
+// app/main.go does before inserting into fhe.xy.
+func (e *Emulator) EncryptTable(plain []map[string]int64) (Table, error) {
+	table := make(Table, len(plain))
+	for i := range table {
+		table[i] = make(map[string]interface{})
+	}
+	cols := make(map[string]bool)
+	for _, row := range plain {
+		for c := range row {
+			cols[c] = true
+		}
+	}
+	for c := range cols {
+		calls := make([][]interface{}, len(plain))
+		for i, row := range plain {
+			calls[i] = []interface{}{float64(row[c])}
+		}
+		replies, err := e.Call("fhe_encrypt", calls)
+		if err != nil {
+			return nil, err
+		}
+		for i, r := range replies {
+			table[i][c] = r
+		}
+	}
+	return table, nil
+}
+
+// Plain evaluates query over plaintext integers modulo the plaintext modulus
+// T, centered like fhe_decrypt's output. It is the answer Query should
+// decrypt to.
+func Plain(query string, table []map[string]int64) ([]int64, error) {
+	n, err := expr.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	rows := len(table)
+	if table == nil {
+		rows = 1
+	}
+	t := int64(fhe.DefaultParams().T)
+	vals := make([]int64, rows)
+	for i := range vals {
+		var row map[string]int64
+		if table != nil {
+			row = table[i]
+		}
+		v, err := plainEval(n, row, t)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+func center(v, t int64) int64 {
+	v %= t
+	if v < 0 {
+		v += t
+	}
+	if v > t/2 {
+		v -= t
+	}
+	return v
+}
+
+func plainEval(n *expr.Node, row map[string]int64, t int64) (int64, error) {
+	switch n.Kind {
+	case expr.Lit:
+		return center(n.Lit, t), nil
+	case expr.Col:
+		v, ok := row[n.Col]
+		if !ok {
+			return 0, fmt.Errorf("emulator: unknown column %s", n.Col)
+		}
+		return center(v, t), nil
+	}
+
+	args := make([]int64, len(n.Args))
+	for i, a := range n.Args {
+		v, err := plainEval(a, row, t)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+
+	fn := funcName(n.Fn)
+	if fn == safeConvert {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("emulator: %s takes 1 argument", safeConvert)
+		}
+		return args[0], nil
+	}
+
+	f, ok := Functions[fn]
+	if !ok {
+		return 0, fmt.Errorf("emulator: unknown function %s", fn)
+	}
+	if len(args) != f.Args {
+		return 0, fmt.Errorf("emulator: %s takes %d arguments", fn, f.Args)
+	}
+	if f.Plain != nil {
+		return center(f.Plain(args), t), nil
+	}
+	return args[0], nil
+}
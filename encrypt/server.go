@@ -3,169 +3,375 @@ package encrypt
 import (
 	"context"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
+	"os"
 	"sync"
 
+	"example.com/fhe"
+	"example.com/fhe/bq"
+	"example.com/fhe/keyring"
+	"example.com/fhe/logging"
+	"example.com/fhe/metrics"
+	"example.com/fhe/service"
+	"example.com/fhe/tracing"
 	"github.com/ldsec/lattigo/bfv"
-	"golang.org/x/net/http2"
 )
 
-type bqRequest struct {
-	RequestId          string            `json:"requestId"`
-	Caller             string            `json:"caller"`
-	SessionUser        string            `json:"sessionUser"`
-	UserDefinedContext map[string]string `json:"userDefinedContext"`
-	Calls              [][]interface{}   `json:"calls"`
-}
-
-type bqResponse struct {
-	Replies      []string `json:"replies,omitempty"`
-	ErrorMessage string   `json:"errorMessage,omitempty"`
-}
-
 const (
 	pubKeyURL = "https://raw.githubusercontent.com/salrashid123/bq_fhe/main/app/pub.b64"
+
+	// overrides pubKeyURL, eg to point at your own key; may also be a file path
+	pubKeyURLEnv = fhe.PublicKeyURLEnv
 )
 
+// keys is one generation of the public keys. A reload swaps in a new one
+// and the batches in flight finish with the one they were pinned to.
+type keys struct {
+	id string // KeyID of the public key
+
+	// the keys rows encrypt under, each with the encryptor of its own
+	// Workspace since encryptors keep scratch polynomials
+	pk *bfv.PublicKey
+
+	// the EqParams public key, fetched by the first fhe_encrypt_eq call
+	// unless FHE_EQ_PUBLIC_KEY_URL was set when the generation loaded
+	eqMu sync.Mutex
+	eqPk *bfv.PublicKey
+}
+
 var (
-	pk          bfv.PublicKey
-	encryptorPk bfv.Encryptor
+	// tags outputs; nil when FHE_MAC_KEY is not set
+	mac *fhe.MAC
+
+	// the public keys, fetched on first use and reloaded without a restart
+	ring = keyring.New(loadKeys)
+
+	handler = bq.NewModes("encrypt", map[string]*bq.Handler{
+		"encrypt": bq.NewHandler(1, func(ctx context.Context, row []interface{}) (string, error) {
+			eint, err := plainValue(row, 0, fhe.DefaultParams())
+			if err != nil {
+				return "", err
+			}
+			ec, err := encrypt(ctx, eint)
+			if err != nil {
+				return "", err
+			}
+			return base64.StdEncoding.EncodeToString(ec), nil
+		}),
+
+		// fhe_encrypt_onehot(category INT64, buckets INT64) for histograms
+		"encrypt_onehot": bq.NewHandler(2, func(ctx context.Context, row []interface{}) (string, error) {
+			v, err := plainValue(row, 0, fhe.DefaultParams())
+			if err != nil {
+				return "", err
+			}
+			buckets, err := plainValue(row, 1, fhe.DefaultParams())
+			if err != nil {
+				return "", err
+			}
+			ec, err := encryptOneHot(ctx, v, buckets)
+			if err != nil {
+				return "", err
+			}
+			return base64.StdEncoding.EncodeToString(ec), nil
+		}),
+
+		// fhe_encrypt_eq(INT64) encrypts under EqParams for fhe_eq
+		"encrypt_eq": bq.NewHandler(1, func(ctx context.Context, row []interface{}) (string, error) {
+			eint, err := plainValue(row, 0, fhe.EqParams())
+			if err != nil {
+				return "", err
+			}
+			ec, err := encryptEq(ctx, eint)
+			if err != nil {
+				return "", err
+			}
+			return base64.StdEncoding.EncodeToString(ec), nil
+		}),
+
+		// fhe_rerandomize(BYTES) adds a fresh encryption of zero
+		"rerandomize": bq.NewHandler(1, func(ctx context.Context, row []interface{}) (string, error) {
+			x, err := bq.Bytes(row, 0)
+			if err != nil {
+				return "", err
+			}
+			ec, err := rerandomize(ctx, x)
+			if err != nil {
+				return "", err
+			}
+			return base64.StdEncoding.EncodeToString(ec), nil
+		}),
+	})
 )
 
-func encrypt(plain float64) ([]byte, error) {
+// plainValue returns argument i of row as an integer that decrypts under
+// params as it is: every value is modulo T, and fhe_decrypt gives back
+// [-T/2, T/2]. Anything else would come back as another number.
+func plainValue(row []interface{}, i int, params *bfv.Parameters) (int64, error) {
+	v, err := bq.Number(row, i)
+	if err != nil {
+		return 0, err
+	}
+	if v != math.Trunc(v) {
+		return 0, fmt.Errorf("invalid argument %d: %v is not an integer", i, v)
+	}
+	// compared as floats, since int64(v) of a huge v is undefined
+	if half := float64(params.T / 2); v < -half || v > half {
+		return 0, fmt.Errorf("invalid argument %d: %v is out of range, values are modulo T = %d so only [%d, %d] decrypt as they are", i, v, params.T, -int64(half), int64(half))
+	}
+	return int64(v), nil
+}
+
+func encrypt(ctx context.Context, plain int64) ([]byte, error) {
 
-	params := bfv.DefaultParams[bfv.PN12QP109]
-	XPlaintext := bfv.NewPlaintext(params)
-	encoder := bfv.NewEncoder(params)
-	rX := make([]uint64, 1<<params.LogN)
-	rX[0] = uint64(plain)
-	encoder.EncodeUint(rX, XPlaintext)
-	XcipherText := encryptorPk.EncryptNew(XPlaintext)
-	XcipherBytes, err := XcipherText.MarshalBinary()
+	k, err := ring.From(ctx)
 	if err != nil {
 		return nil, err
 	}
+	w := fhe.GetWorkspace(fhe.DefaultParams())
+	defer w.Put()
+	XPlaintext, XcipherText := w.EncodeValue(plain), w.Ciphertext(1)
+	done := metrics.Time(metrics.Encrypt)
+	w.Encryptor(k.pk).Encrypt(XPlaintext, XcipherText)
+	done()
+	return mac.Marshal(XcipherText)
+}
 
-	return XcipherBytes, nil
+// encryptOneHot encrypts a 1 in slot v of buckets.
+func encryptOneHot(ctx context.Context, v, buckets int64) ([]byte, error) {
+
+	k, err := ring.From(ctx)
+	if err != nil {
+		return nil, err
+	}
+	w := fhe.GetWorkspace(fhe.DefaultParams())
+	defer w.Put()
+	XPlaintext, err := fhe.EncodeOneHot(w.Params, w.Encoder, v, buckets)
+	if err != nil {
+		return nil, err
+	}
+	XcipherText := w.Ciphertext(1)
+	done := metrics.Time(metrics.Encrypt)
+	w.Encryptor(k.pk).Encrypt(XPlaintext, XcipherText)
+	done()
+	return mac.Marshal(XcipherText)
 }
 
-func init() {
+func encryptEq(ctx context.Context, plain int64) ([]byte, error) {
 
-	var client http.Client
-	resp, err := client.Get(pubKeyURL)
+	k, err := ring.From(ctx)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	defer resp.Body.Close()
-	var p []byte
-	if resp.StatusCode == http.StatusOK {
-		p, err = io.ReadAll(resp.Body)
-		if err != nil {
-			panic(err)
-		}
-	} else {
-		panic(errors.New("unable to get public key from url"))
+	pk, err := k.eq(ctx)
+	if err != nil {
+		return nil, err
 	}
+	w := fhe.GetWorkspace(fhe.EqParams())
+	defer w.Put()
+	XPlaintext, XcipherText := w.EncodeValue(plain), w.Ciphertext(1)
+	done := metrics.Time(metrics.Encrypt)
+	w.Encryptor(pk).Encrypt(XPlaintext, XcipherText)
+	done()
+	return mac.Marshal(XcipherText)
+}
 
-	params := bfv.DefaultParams[bfv.PN12QP109]
-	pubBytes, err := base64.StdEncoding.DecodeString(string(p))
+// rerandomize returns x plus a fresh encryption of zero, so a result no
+// longer matches the ciphertext anyone can recompute from its inputs.
+// fhe_aggregate envelopes have both their sums re-randomized. x comes from
+// the evaluators, so with keys split encrypt has to verify their key too;
+// the result is tagged with encrypt's own, which they and decrypt accept.
+func rerandomize(ctx context.Context, x []byte) ([]byte, error) {
+
+	k, err := ring.From(ctx)
 	if err != nil {
-		fmt.Printf("Invalid secret Key decoding %v\n", err)
+		return nil, err
+	}
+	x, err = mac.Open(x)
+	if errors.Is(err, fhe.ErrMACUnknownKey) {
+		// the evaluators tag under their own key in a split-key setup
+		return nil, fmt.Errorf("%w: add the evaluators' FHE_MAC_KEY to %s on encrypt", err, fhe.MACVerifyKeysEnv)
 	}
-	err = pk.UnmarshalBinary(pubBytes)
 	if err != nil {
-		fmt.Printf("Invalid secret Key %v\n", err)
+		return nil, err
 	}
-	encryptorPk = bfv.NewEncryptorFromPk(params, &pk)
+	params := fhe.CiphertextParams(x)
+	pk := k.pk
+	if fhe.IsEqCiphertext(x) {
+		if pk, err = k.eq(ctx); err != nil {
+			return nil, err
+		}
+	}
+	w := fhe.GetWorkspace(params)
+	defer w.Put()
+	evaluator, encryptor := w.Evaluator, w.Encryptor(pk)
 
-}
+	if fhe.IsAggregate(x) {
+		agg, err := fhe.UnmarshalAggregate(params, x)
+		if err != nil {
+			return nil, err
+		}
+		done := metrics.Time(metrics.Encrypt)
+		fhe.Rerandomize(evaluator, params, encryptor, agg.Sum)
+		fhe.Rerandomize(evaluator, params, encryptor, agg.SumSq)
+		done()
+		return mac.Marshal(agg)
+	}
 
-func FHE_ENCRYPT(w http.ResponseWriter, r *http.Request) {
+	ct, err := w.UnmarshalCiphertext(x)
+	if err != nil {
+		return nil, err
+	}
+	done := metrics.Time(metrics.Encrypt)
+	fhe.Rerandomize(evaluator, params, encryptor, ct)
+	done()
+	return mac.Marshal(ct)
+}
 
-	bqReq := &bqRequest{}
-	bqResp := &bqResponse{}
+// eq returns the EqParams public key, fetching the one
+// FHE_EQ_PUBLIC_KEY_URL points at on first use. There is no default, fhe_eq
+// keys are made with fhe keygen -eq.
+func (k *keys) eq(ctx context.Context) (_ *bfv.PublicKey, err error) {
 
-	if err := json.NewDecoder(r.Body).Decode(&bqReq); err != nil {
-		bqResp.ErrorMessage = fmt.Sprintf("External Function error: can't read POST body %v", err)
-	} else {
+	k.eqMu.Lock()
+	defer k.eqMu.Unlock()
+	if k.eqPk != nil {
+		return k.eqPk, nil
+	}
+	defer func() { metrics.KeyLoad("eq_public", err) }()
 
-		fmt.Printf("caller %s\n", bqReq.Caller)
-		fmt.Printf("sessionUser %s\n", bqReq.SessionUser)
-		fmt.Printf("userDefinedContext %v\n", bqReq.UserDefinedContext)
+	url := os.Getenv(fhe.EqPublicKeyURLEnv)
+	if url == "" {
+		return nil, fmt.Errorf("%s is not set", fhe.EqPublicKeyURLEnv)
+	}
+	pubBytes, err := fhe.ReadKey(ctx, url)
+	if err != nil {
+		return nil, err
+	}
 
-		wait := new(sync.WaitGroup)
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+	params := fhe.EqParams()
+	key, err := fhe.UnmarshalPublicKey(params, pubBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid public Key %v", err)
+	}
+	k.eqPk = key
+	return k.eqPk, nil
+}
 
-		objs := make([]string, len(bqReq.Calls))
+// loadKeys fetches a generation of public keys: on first use, and again on
+// every reload. It used to run in init() where an unreachable URL panicked
+// the whole instance.
+func loadKeys(ctx context.Context) (_ *keys, err error) {
 
-		for i, r := range bqReq.Calls {
-			if len(r) != 1 {
-				bqResp.ErrorMessage = fmt.Sprintf("Invalid number of input fields provided.  expected 1, got  %d", len(r))
-			}
+	defer func() { metrics.KeyLoad("public", err) }()
 
-			eint, ok := r[0].(float64)
-			if !ok {
-				bqResp.ErrorMessage = "Invalid key type. expected string"
-				bqResp.Replies = nil
-				break
-			}
+	url := pubKeyURL
+	if u := os.Getenv(pubKeyURLEnv); u != "" {
+		url = u
+	}
+	pubBytes, err := fhe.ReadKey(ctx, url)
+	if err != nil {
+		return nil, err
+	}
 
-			//  use goroutines heres but keep the order
-			wait.Add(1)
-			go func(j int) {
-				defer wait.Done()
-				for {
-					select {
-					case <-ctx.Done():
-						return
-					default:
-						ec, err := encrypt(eint)
-						if err != nil {
-							bqResp.ErrorMessage = fmt.Sprintf("Error decrypting row %d", j)
-							bqResp.Replies = nil
-							cancel()
-							return
-						}
-						objs[j] = base64.StdEncoding.EncodeToString(ec)
-						return
-					}
-				}
-			}(i)
+	params := bfv.DefaultParams[bfv.PN12QP109]
+	key, err := fhe.UnmarshalPublicKey(params, pubBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid public Key %v", err)
+	}
+	k := &keys{pk: key}
+	if k.id, err = fhe.KeyID(key); err != nil {
+		return nil, err
+	}
+	if os.Getenv(fhe.EqPublicKeyURLEnv) != "" {
+		if _, err := k.eq(ctx); err != nil {
+			return nil, err
 		}
+	}
+	slog.Info("public key loaded", "keyId", k.id)
+	return k, nil
+}
 
-		wait.Wait()
-		if bqResp.ErrorMessage != "" {
-			bqResp.Replies = nil
-		} else {
-			bqResp.Replies = objs
-		}
+func init() {
+
+	var err error
+	if err := logging.InitFromEnv(); err != nil {
+		panic(err)
+	}
+	mac, err = fhe.NewMACFromEnv()
+	if err != nil {
+		panic(err)
 	}
+	if mac == nil {
+		slog.Warn("ciphertext integrity checks are disabled", "unset", fhe.MACKeyEnv)
+	}
+	if err := tracing.InitFromEnv("fhe-encrypt"); err != nil {
+		panic(err)
+	}
+}
 
-	b, err := json.Marshal(bqResp)
+func FHE_ENCRYPT(w http.ResponseWriter, r *http.Request) {
+
+	ctx, err := pin(r.Context())
 	if err != nil {
-		http.Error(w, fmt.Sprintf("can't convert response to JSON %v", err), http.StatusInternalServerError)
+		bq.WriteError(w, fmt.Errorf("External Function error: can't load public key %w", err))
 		return
 	}
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(b)
+// pin pins a batch to the current keys and labels its spans with their
+// KeyID.
+func pin(ctx context.Context) (context.Context, error) {
+	ctx, err := ring.Pin(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	k, err := ring.From(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	return bq.WithKeyID(ctx, k.id), nil
 }
 
-func main() {
+// Batch runs a batch of the gRPC API pinned to the current keys, as
+// FHE_ENCRYPT does one from BigQuery.
+func Batch(ctx context.Context, bqReq *bq.Request) *bq.Response {
+	ctx, err := pin(ctx)
+	if err != nil {
+		return bq.ErrorResponse(fmt.Errorf("External Function error: can't load public key %w", err))
+	}
+	return handler.Do(ctx, bqReq)
+}
 
-	http.HandleFunc("/", FHE_ENCRYPT)
+// startup fetches the public keys and encrypts a known value. Only decrypt
+// holds the secret key to check the result, so this checks that it comes
+// out sealed and well formed.
+func startup() error {
+	b, err := encrypt(context.Background(), 42)
+	if err != nil {
+		return err
+	}
+	if b, err = mac.Open(b); err != nil {
+		return err
+	}
+	_, err = fhe.UnmarshalCiphertext(fhe.DefaultParams(), b)
+	return err
+}
 
-	server := &http.Server{
-		Addr: ":8080",
+// Server is FHE_ENCRYPT run as a server by cmd/server, eg on Cloud Run: it
+// loads the keys at startup, serves the probes and the gRPC API, reloads the
+// keys and drains on SIGTERM. Deployed as a function, none of that runs.
+func Server() *service.Service {
+	return &service.Service{
+		Handler:  http.HandlerFunc(FHE_ENCRYPT),
+		Batch:    Batch,
+		Startup:  startup,
+		Reload:   ring.Reload,
+		WatchEnv: []string{pubKeyURLEnv, fhe.EqPublicKeyURLEnv},
 	}
-	http2.ConfigureServer(server, &http2.Server{})
-	log.Println("Starting Server..")
-	err := server.ListenAndServe()
-	log.Fatalf("Unable to start Server %v", err)
 }
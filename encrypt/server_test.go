@@ -0,0 +1,121 @@
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"example.com/fhe"
+	"example.com/fhe/bq"
+	"github.com/ldsec/lattigo/bfv"
+)
+
+// useTestKey installs a fresh public key in place of the one loadKeys fetches.
+func useTestKey() *bfv.PublicKey {
+	params := bfv.DefaultParams[bfv.PN12QP109]
+	_, testPk := bfv.NewKeyGenerator(params).GenKeyPair()
+	ring.Set(&keys{pk: testPk})
+	return testPk
+}
+
+func FuzzFHE_ENCRYPT(f *testing.F) {
+	useTestKey()
+	f.Add(`{"calls":[[3],[2]]}`)
+	f.Add(`{"calls":[[-1e300]]}`)
+	f.Add(`{"calls":[["3"]]}`)
+	f.Add(`{"calls":[[3,2]]}`)
+
+	f.Fuzz(func(t *testing.T, body string) {
+		rec := httptest.NewRecorder()
+		FHE_ENCRYPT(rec, httptest.NewRequest("POST", "/", bytes.NewReader([]byte(body))))
+
+		resp := &bq.Response{}
+		if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+			t.Fatalf("response is not JSON: %v", err)
+		}
+		if resp.ErrorMessage != "" && resp.Replies != nil {
+			t.Fatalf("got both replies and an error: %+v", resp)
+		}
+	})
+}
+
+func TestStartup(t *testing.T) {
+	useTestKey()
+	if err := startup(); err != nil {
+		t.Errorf("startup() = %v", err)
+	}
+}
+
+// Values that wouldn't decrypt as they are get a 400 rather than an
+// encryption of something else.
+func TestPlainValue(t *testing.T) {
+	useTestKey()
+	for body, want := range map[string]string{
+		`{"calls":[[2.7]]}`:              "2.7 is not an integer",
+		`{"calls":[[32769]]}`:            "32769 is out of range",
+		`{"calls":[[-1e300]]}`:           "is out of range",
+		`{"calls":[[9007199254740993]]}`: "is out of range",
+		`{"userDefinedContext":{"mode":"encrypt_onehot"},"calls":[[1.5,4]]}`: "1.5 is not an integer",
+		`{"userDefinedContext":{"mode":"encrypt_eq"},"calls":[[-40000]]}`:    "-40000 is out of range",
+	} {
+		rec := httptest.NewRecorder()
+		FHE_ENCRYPT(rec, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		if rec.Code != 400 || !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("%s = %d %s, want a 400 saying %q", body, rec.Code, rec.Body, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	FHE_ENCRYPT(rec, httptest.NewRequest("POST", "/", strings.NewReader(`{"calls":[[-32768],[32768]]}`)))
+	if rec.Code != 200 {
+		t.Errorf("the ends of the range = %d %s", rec.Code, rec.Body)
+	}
+}
+
+// With the MAC keys split as the README describes, encrypt tags under A and
+// the evaluators under B. fhe_rerandomize takes the evaluators' results once
+// encrypt also verifies B, and hands back what they and decrypt accept.
+func TestRerandomizeSplitKeys(t *testing.T) {
+	pk := useTestKey()
+	params := fhe.DefaultParams()
+	a, b := bytes.Repeat([]byte{'a'}, fhe.MinMACKeyLen), bytes.Repeat([]byte{'b'}, fhe.MinMACKeyLen)
+	evaluator, err := fhe.NewMAC(b, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypt, err := fhe.NewMAC(nil, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := evaluator.Marshal(bfv.NewEncryptorFromPk(params, pk).EncryptNew(fhe.EncodeValue(params, bfv.NewEncoder(params), 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mac = nil })
+
+	// FHE_MAC_KEY=A alone
+	if mac, err = fhe.NewMAC(a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rerandomize(context.Background(), result); !errors.Is(err, fhe.ErrMACUnknownKey) || !strings.Contains(err.Error(), fhe.MACVerifyKeysEnv) {
+		t.Errorf("rerandomize() without B = %v, want ErrMACUnknownKey naming %s", err, fhe.MACVerifyKeysEnv)
+	}
+
+	// FHE_MAC_KEY=A, FHE_MAC_VERIFY_KEYS=B
+	if mac, err = fhe.NewMAC(a, b); err != nil {
+		t.Fatal(err)
+	}
+	out, err := rerandomize(context.Background(), result)
+	if err != nil {
+		t.Fatalf("rerandomize() with B verified = %v", err)
+	}
+	for name, m := range map[string]*fhe.MAC{"evaluator": evaluator, "decrypt": decrypt} {
+		if _, err := m.Open(out); err != nil {
+			t.Errorf("%s refuses the result: %v", name, err)
+		}
+	}
+}
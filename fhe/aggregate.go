@@ -0,0 +1,170 @@
+package fhe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+// aggregateTag starts an aggregate envelope. Raw ciphertexts start with
+// their degree + 1 and MAC envelopes with 0xF1.
+const aggregateTag = 0xA1
+
+// StatsMaxAbsContextKey bounds the magnitude of the values of an
+// aggregate, in userDefinedContext, for fhe_decrypt_stats to refuse counts
+// whose sums may have wrapped.
+const StatsMaxAbsContextKey = "max_abs"
+
+var ErrMalformedAggregate = errors.New("fhe: malformed aggregate")
+
+// ErrStatsOverflow is returned for the sums of an aggregate that have, or
+// may have, wrapped around T.
+var ErrStatsOverflow = errors.New("fhe: aggregate sums wrap modulo T")
+
+// Aggregate is what fhe_aggregate returns for a column: the encrypted sum
+// and sum of squares of its values and, in the clear, how many there were.
+// That is enough for the mean and variance once decrypted, which BFV can't
+// compute itself since it has no division.
+//
+// It is serialized as
+//
+//	0xA1 | count (8 bytes) | len(sum) (4 bytes) | sum | sum of squares
+//
+// and sealed as a whole, so the count is covered by the MAC too.
+type Aggregate struct {
+	Count int64
+	Sum   *bfv.Ciphertext
+	SumSq *bfv.Ciphertext
+}
+
+// IsAggregate reports whether data, with any MAC envelope opened, is an
+// aggregate rather than a ciphertext.
+func IsAggregate(data []byte) bool {
+	return len(data) > 0 && data[0] == aggregateTag
+}
+
+func (a *Aggregate) MarshalBinary() ([]byte, error) {
+	sum, err := a.Sum.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	sumSq, err := a.SumSq.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 13, 13+len(sum)+len(sumSq))
+	b[0] = aggregateTag
+	binary.BigEndian.PutUint64(b[1:], uint64(a.Count))
+	binary.BigEndian.PutUint32(b[9:], uint32(len(sum)))
+	b = append(b, sum...)
+	return append(b, sumSq...), nil
+}
+
+// UnmarshalAggregate decodes an aggregate, checking both ciphertexts against
+// params.
+func UnmarshalAggregate(params *bfv.Parameters, data []byte) (*Aggregate, error) {
+	if len(data) < 13 || !IsAggregate(data) {
+		return nil, fmt.Errorf("%w: %d bytes", ErrMalformedAggregate, len(data))
+	}
+	a := &Aggregate{Count: int64(binary.BigEndian.Uint64(data[1:]))}
+	if a.Count < 0 {
+		return nil, fmt.Errorf("%w: count %d", ErrMalformedAggregate, a.Count)
+	}
+	n := int(binary.BigEndian.Uint32(data[9:]))
+	if n > len(data)-13 {
+		return nil, fmt.Errorf("%w: sum is %d bytes", ErrMalformedAggregate, n)
+	}
+	var err error
+	if a.Sum, err = UnmarshalCiphertext(params, data[13:13+n]); err != nil {
+		return nil, fmt.Errorf("sum: %w", err)
+	}
+	if a.SumSq, err = UnmarshalCiphertext(params, data[13+n:]); err != nil {
+		return nil, fmt.Errorf("sum of squares: %w", err)
+	}
+	return a, nil
+}
+
+// AggregateOf returns the aggregate of xs. Each square is a multiplication,
+// so the values should be fresh; the squares are summed at degree 2 and
+// relinearized once.
+func AggregateOf(ev bfv.Evaluator, keys *EvalKeys, xs []*bfv.Ciphertext) (*Aggregate, error) {
+	if keys == nil || keys.Relin == nil {
+		return nil, errors.New("aggregate needs a relinearization key")
+	}
+	if len(xs) == 0 {
+		return nil, errors.New("nothing to aggregate")
+	}
+	a := &Aggregate{Count: int64(len(xs))}
+	var sq *bfv.Ciphertext
+	for i, x := range xs {
+		if x.Degree() != 1 {
+			return nil, fmt.Errorf("value %d has degree %d, relinearize it first", i, x.Degree())
+		}
+		if i == 0 {
+			a.Sum = x.CopyNew().Ciphertext()
+			sq = ev.MulNew(x, x)
+			continue
+		}
+		ev.Add(a.Sum, x, a.Sum)
+		ev.Add(sq, ev.MulNew(x, x), sq)
+	}
+	a.SumSq = ev.RelinearizeNew(sq, keys.Relin)
+	return a, nil
+}
+
+// Merge adds b to a, for aggregates of parts of a column.
+func (a *Aggregate) Merge(ev bfv.Evaluator, b *Aggregate) {
+	a.Count += b.Count
+	a.Sum = ev.AddNew(a.Sum, b.Sum)
+	a.SumSq = ev.AddNew(a.SumSq, b.SumSq)
+}
+
+// Stats are the decrypted aggregate of a column. Sum and SumSq are modulo T
+// like everything else, so they are only right while their magnitude stays
+// under T/2; Mean and the variances are nil when there are too few values.
+type Stats struct {
+	Count          int64    `json:"count"`
+	Sum            int64    `json:"sum"`
+	SumSq          int64    `json:"sum_of_squares"`
+	Mean           *float64 `json:"mean"`
+	Variance       *float64 `json:"variance"`
+	SampleVariance *float64 `json:"sample_variance"`
+}
+
+// CheckStats returns ErrStatsOverflow if sum and sumSq, decoded modulo T,
+// can't be the sums of count values: the sum of squares is negative, or
+// below sum²/count. That catches some wraps, not all. maxAbs, if not 0,
+// bounds the magnitude of the values, and then an error means count values
+// of maxAbs could take SumSq past T/2, whatever the sums decoded to.
+func CheckStats(params *bfv.Parameters, count, sum, sumSq, maxAbs int64) error {
+	half := int64(params.T / 2)
+	if maxAbs != 0 && count > 0 && (maxAbs > half || count > half/(maxAbs*maxAbs)) {
+		return fmt.Errorf("%w: %d values up to %d square to more than %d, aggregate fewer", ErrStatsOverflow, count, maxAbs, half)
+	}
+	// sum² <= count*sumSq, in floats since both sides can pass 2^63
+	if sumSq < 0 || count > 0 && float64(sum)*float64(sum) > float64(count)*float64(sumSq) {
+		return fmt.Errorf("%w: %d values can't sum to %d with squares summing to %d", ErrStatsOverflow, count, sum, sumSq)
+	}
+	return nil
+}
+
+// NewStats works out the mean, population variance and sample variance.
+func NewStats(count, sum, sumSq int64) *Stats {
+	s := &Stats{Count: count, Sum: sum, SumSq: sumSq}
+	if count == 0 {
+		return s
+	}
+	n := float64(count)
+	mean := float64(sum) / n
+	// sum of squared deviations from the mean
+	m2 := float64(sumSq) - float64(sum)*mean
+	variance := m2 / n
+	s.Mean, s.Variance = &mean, &variance
+	if count > 1 {
+		sample := m2 / (n - 1)
+		s.SampleVariance = &sample
+	}
+	return s
+}
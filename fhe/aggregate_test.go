@@ -0,0 +1,102 @@
+package fhe
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+func TestAggregate(t *testing.T) {
+	params := DefaultParams()
+	sk, pk := bfv.NewKeyGenerator(params).GenKeyPair()
+	keys := GenEvalKeys(params, sk)
+	encoder := bfv.NewEncoder(params)
+	encryptor := bfv.NewEncryptorFromPk(params, pk)
+	decryptor := bfv.NewDecryptor(params, sk)
+	ev := bfv.NewEvaluator(params)
+
+	values := []int64{4, -2, 9, 0, 7, 12}
+	var xs []*bfv.Ciphertext
+	for _, v := range values {
+		xs = append(xs, encryptor.EncryptNew(EncodeValue(params, encoder, v)))
+	}
+	// two parts merged, the way a large column is aggregated
+	a, err := AggregateOf(ev, keys, xs[:4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := AggregateOf(ev, keys, xs[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Merge(ev, b)
+
+	data, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsAggregate(data) {
+		t.Fatalf("IsAggregate() = false")
+	}
+	a, err = UnmarshalAggregate(params, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := DecodeValueChecked(encoder, decryptor.DecryptNew(a.Sum))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sumSq, err := DecodeValueChecked(encoder, decryptor.DecryptNew(a.SumSq))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStats(a.Count, sum, sumSq)
+	if s.Count != 6 || s.Sum != 30 || s.SumSq != 294 {
+		t.Fatalf("got count %d, sum %d, sum of squares %d, want 6, 30, 294", s.Count, s.Sum, s.SumSq)
+	}
+	// mean 5, squared deviations 1+49+16+25+4+49 = 144
+	if *s.Mean != 5 || *s.Variance != 24 || math.Abs(*s.SampleVariance-28.8) > 1e-9 {
+		t.Errorf("got mean %v, variance %v, sample variance %v, want 5, 24, 28.8", *s.Mean, *s.Variance, *s.SampleVariance)
+	}
+	if s := NewStats(1, 3, 9); s.SampleVariance != nil || *s.Variance != 0 {
+		t.Errorf("one value: got %+v", s)
+	}
+	if s := NewStats(0, 0, 0); s.Mean != nil {
+		t.Errorf("no values: got %+v", s)
+	}
+
+	if err := CheckStats(params, s.Count, s.Sum, s.SumSq, 12); err != nil {
+		t.Errorf("CheckStats() = %v", err)
+	}
+	for name, c := range map[string][4]int64{
+		"negative squares":   {2, 1, -5, 0},
+		"squares too small":  {2, 300, 100, 0},
+		"wrapped":            {1, 200, 200*200 - int64(params.T), 0},
+		"too many for max":   {3, 0, 0, 200},
+		"max over T/2":       {1, 0, 0, int64(params.T)},
+		"one more than fits": {int64(params.T/2)/100 + 1, 0, 0, 10},
+	} {
+		if err := CheckStats(params, c[0], c[1], c[2], c[3]); !errors.Is(err, ErrStatsOverflow) {
+			t.Errorf("%s: CheckStats() = %v, want ErrStatsOverflow", name, err)
+		}
+	}
+	if err := CheckStats(params, int64(params.T/2)/100, 0, 0, 10); err != nil {
+		t.Errorf("CheckStats() at the bound = %v", err)
+	}
+
+	if _, err := AggregateOf(ev, &EvalKeys{}, xs); err == nil {
+		t.Errorf("AggregateOf without a relinearization key succeeded")
+	}
+	for name, b := range map[string][]byte{
+		"empty":      {},
+		"ciphertext": data[13:],
+		"truncated":  data[:len(data)-1],
+		"length":     append(append([]byte{}, data[:9]...), 0xff, 0xff, 0xff, 0xff),
+	} {
+		if _, err := UnmarshalAggregate(params, b); !errors.Is(err, ErrMalformedAggregate) && !errors.Is(err, ErrMalformedCiphertext) {
+			t.Errorf("%s: UnmarshalAggregate() = %v, want a malformed error", name, err)
+		}
+	}
+}
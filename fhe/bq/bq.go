@@ -0,0 +1,609 @@
+// Package bq implements the BigQuery remote function protocol: it decodes a
+// batch of calls, runs each row concurrently and replies in order.
+package bq
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/fhe"
+	"example.com/fhe/logging"
+	"example.com/fhe/metrics"
+	"example.com/fhe/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultMaxRequestBytes caps the size of a request body. Cloud Run
+	// rejects HTTP/1 requests above 32MiB anyway.
+	DefaultMaxRequestBytes = 32 << 20
+
+	// MaxRequestBytesEnv overrides DefaultMaxRequestBytes.
+	MaxRequestBytesEnv = "FHE_MAX_REQUEST_BYTES"
+
+	// MaxInFlightRowsEnv caps the rows of the batches a process computes
+	// at once. Batches beyond it are shed with 429. Unset, there is no cap.
+	MaxInFlightRowsEnv = "FHE_MAX_INFLIGHT_ROWS"
+
+	// BatchWorkersEnv sets how many rows of a batch are computed at once.
+	// Unset, it is GOMAXPROCS: the rows are CPU bound.
+	BatchWorkersEnv = "FHE_BATCH_WORKERS"
+)
+
+// ErrOverloaded sheds a batch that would take the rows in flight over
+// FHE_MAX_INFLIGHT_ROWS.
+var ErrOverloaded = errors.New("bq: too many rows in flight, retry later")
+
+// Request is the body BigQuery POSTs to a remote function.
+type Request struct {
+	RequestId          string            `json:"requestId"`
+	Caller             string            `json:"caller"`
+	SessionUser        string            `json:"sessionUser"`
+	UserDefinedContext map[string]string `json:"userDefinedContext"`
+	Calls              [][]interface{}   `json:"calls"`
+}
+
+// Response is the body returned to BigQuery.
+type Response struct {
+	Replies      []string `json:"replies,omitempty"`
+	ErrorMessage string   `json:"errorMessage,omitempty"`
+
+	// Status is the HTTP status of a failed batch; 0 is 200.
+	Status int `json:"-"`
+}
+
+// ErrorResponse fails a batch with err. BigQuery retries a 429 or 503 and
+// gives up on the query at anything else, so err is answered with
+//
+//	429 if it is ErrOverloaded
+//	503 if it is Transient, eg a key that couldn't be fetched
+//	400 otherwise: bad input, a tag that doesn't verify, a missing setting
+func ErrorResponse(err error) *Response {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, ErrOverloaded):
+		status = http.StatusTooManyRequests
+	case Transient(err):
+		status = http.StatusServiceUnavailable
+	}
+	return &Response{ErrorMessage: err.Error(), Status: status}
+}
+
+// unavailableError is an error marked by Unavailable.
+type unavailableError struct{ err error }
+
+func (e unavailableError) Error() string { return e.err.Error() }
+func (e unavailableError) Unwrap() error { return e.err }
+
+// Unavailable marks err as transient, for a RowFunc that knows retrying
+// the batch later can succeed.
+func Unavailable(err error) error {
+	return unavailableError{err}
+}
+
+// Transient reports whether a batch that failed with err may succeed if
+// BigQuery retries it: err was marked by Unavailable, is a key fhe couldn't
+// fetch, is ErrOverloaded, or is a deadline.
+func Transient(err error) bool {
+	var u unavailableError
+	return errors.As(err, &u) ||
+		errors.Is(err, fhe.ErrKeyUnavailable) ||
+		errors.Is(err, ErrOverloaded) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// RowFunc computes the reply for one row of calls.
+type RowFunc func(ctx context.Context, row []interface{}) (string, error)
+
+// Handler serves BigQuery batches by running Row on every call.
+type Handler struct {
+	// number of arguments each row must have
+	Args int
+	Row  RowFunc
+
+	// request body limit in bytes
+	MaxRequestBytes int64
+
+	// Name labels the metrics of the batches it serves; NewModes sets it
+	// to the mode.
+	Name string
+
+	// Workers is how many rows of a batch are computed at once, 0 for
+	// GOMAXPROCS.
+	Workers int
+}
+
+// NewHandler returns a Handler for rows of args arguments. The request size
+// limit is read from FHE_MAX_REQUEST_BYTES and the workers from
+// FHE_BATCH_WORKERS.
+func NewHandler(args int, row RowFunc) *Handler {
+	return &Handler{
+		Args:            args,
+		Row:             row,
+		MaxRequestBytes: RequestLimit(),
+		Workers:         batchWorkers(),
+	}
+}
+
+func batchWorkers() int {
+	if v := os.Getenv(BatchWorkersEnv); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// RequestLimit returns the request size limit in bytes,
+// DefaultMaxRequestBytes unless FHE_MAX_REQUEST_BYTES is set.
+func RequestLimit() int64 {
+	if v := os.Getenv(MaxRequestBytesEnv); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return DefaultMaxRequestBytes
+}
+
+// admission is the in-flight row budget every Handler and Modes of the
+// process shares.
+var admission = &budget{max: maxInFlightRows()}
+
+func maxInFlightRows() int {
+	if v := os.Getenv(MaxInFlightRowsEnv); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// budget counts the rows of the batches being computed.
+type budget struct {
+	mu   sync.Mutex
+	max  int // 0 for no cap
+	used int
+}
+
+// acquire admits a batch of n rows if they fit in the budget. A batch
+// bigger than the whole budget is still admitted when nothing else is in
+// flight, or it could never run.
+func (b *budget) acquire(n int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.max > 0 && b.used > 0 && b.used+n > b.max {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+func (b *budget) release(n int) {
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	WriteResponse(w, h.serve(w, r))
+}
+
+// WriteResponse writes bqResp as the JSON reply to BigQuery.
+func WriteResponse(w http.ResponseWriter, bqResp *Response) {
+
+	b, err := json.Marshal(bqResp)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can't convert response to JSON %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if bqResp.Status != 0 {
+		w.WriteHeader(bqResp.Status)
+	}
+	w.Write(b)
+}
+
+// WriteError fails the whole batch with err, classified by ErrorResponse.
+func WriteError(w http.ResponseWriter, err error) {
+	WriteResponse(w, ErrorResponse(err))
+}
+
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request) *Response {
+	return serveBatch(w, r, h.MaxRequestBytes, func(*Request) (*Handler, string, error) {
+		return h, h.Name, nil
+	})
+}
+
+// serveBatch reads a batch and runs it inside a span that joins the trace
+// of r.
+func serveBatch(w http.ResponseWriter, r *http.Request, limit int64, pick func(*Request) (*Handler, string, error)) *Response {
+	ctx := tracing.Propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return runBatch(ctx, func() (*Request, *Response) {
+		return readRequest(w, r, limit)
+	}, pick)
+}
+
+// runBatch gets a batch from read, or the Response for one it couldn't
+// read, has pick choose the Handler and the mode to label it with, and runs
+// it if it fits in the in-flight row budget. The batch is recorded in the
+// metrics, logged and traced wherever it came from.
+func runBatch(ctx context.Context, read func() (*Request, *Response), pick func(*Request) (*Handler, string, error)) *Response {
+	start := time.Now()
+	ctx, span := tracing.Tracer().Start(ctx, "bq batch", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	bqReq, resp := read()
+	mode := ""
+	if resp == nil {
+		var h *Handler
+		var err error
+		h, mode, err = pick(bqReq)
+		ctx = logging.WithRequestID(ctx, bqReq.RequestId)
+		span.SetName("bq " + mode)
+		span.SetAttributes(
+			attribute.String("bq.request_id", bqReq.RequestId),
+			attribute.String("bq.caller", bqReq.Caller),
+			attribute.String("fhe.mode", mode),
+			attribute.Int("bq.rows", len(bqReq.Calls)),
+		)
+		if id := keyID(ctx); id != "" {
+			span.SetAttributes(attribute.String("fhe.key_id", id))
+		}
+		if err != nil {
+			resp = ErrorResponse(err)
+		} else if n := len(bqReq.Calls); !admission.acquire(n) {
+			resp = ErrorResponse(ErrOverloaded)
+		} else {
+			resp = h.handle(ctx, bqReq)
+			admission.release(n)
+		}
+	}
+	if resp.ErrorMessage != "" {
+		span.SetStatus(codes.Error, resp.ErrorMessage)
+	}
+	observe(mode, bqReq, start, resp)
+	logBatch(ctx, mode, bqReq, start, resp)
+	return resp
+}
+
+// logBatch logs a batch once it is answered, as a warning if it failed.
+// caller and sessionUser are redacted as the logging package is configured
+// to, and userDefinedContext is logged by its keys alone.
+func logBatch(ctx context.Context, mode string, bqReq *Request, start time.Time, resp *Response) {
+	level, msg := slog.LevelInfo, "batch"
+	attrs := []slog.Attr{
+		slog.String("mode", mode),
+		slog.Duration("duration", time.Since(start)),
+	}
+	if resp.ErrorMessage != "" {
+		level, msg = slog.LevelWarn, "batch failed"
+		attrs = append(attrs, slog.String("error", resp.ErrorMessage), slog.Int("status", resp.Status))
+	}
+	if bqReq != nil {
+		attrs = append(attrs,
+			slog.Int("rows", len(bqReq.Calls)),
+			slog.String(logging.CallerKey, bqReq.Caller),
+			slog.String(logging.SessionUserKey, bqReq.SessionUser),
+			slog.String("userDefinedContext", logging.ContextKeys(bqReq.UserDefinedContext)),
+		)
+	}
+	slog.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// observe records a batch of mode in the metrics. bqReq is nil if the body
+// couldn't be read.
+func observe(mode string, bqReq *Request, start time.Time, resp *Response) {
+	outcome := metrics.OK
+	switch resp.Status {
+	case http.StatusTooManyRequests:
+		outcome = metrics.Shed
+	case http.StatusServiceUnavailable:
+		outcome = metrics.Unavailable
+	default:
+		if resp.ErrorMessage != "" {
+			outcome = metrics.Error
+		}
+	}
+	metrics.Requests.WithLabelValues(mode, outcome).Inc()
+	metrics.RequestSeconds.WithLabelValues(mode).Observe(time.Since(start).Seconds())
+	if bqReq != nil {
+		metrics.BatchRows.WithLabelValues(mode).Observe(float64(len(bqReq.Calls)))
+	}
+}
+
+// readRequest decodes the body of r, or returns the Response for a body
+// that can't be.
+func readRequest(w http.ResponseWriter, r *http.Request, limit int64) (*Request, *Response) {
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+	bqReq := &Request{}
+	if err := json.NewDecoder(r.Body).Decode(bqReq); err != nil {
+		return nil, ErrorResponse(fmt.Errorf("External Function error: can't read POST body %v", err))
+	}
+	return bqReq, nil
+}
+
+func (h *Handler) handle(ctx context.Context, bqReq *Request) *Response {
+	for _, row := range bqReq.Calls {
+		if len(row) != h.Args {
+			return ErrorResponse(fmt.Errorf("Invalid number of input fields provided.  expected %d, got  %d", h.Args, len(row)))
+		}
+	}
+	ctx = context.WithValue(ctx, userDefinedContextKey{}, bqReq.UserDefinedContext)
+
+	replies, err := h.run(ctx, bqReq.Calls)
+	if err != nil {
+		return ErrorResponse(err)
+	}
+	return &Response{Replies: replies}
+}
+
+type userDefinedContextKey struct{}
+
+type keyIDKey struct{}
+
+// WithKeyID returns ctx carrying the fhe.KeyID of the keys a batch is
+// pinned to, which its spans record as fhe.key_id.
+func WithKeyID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, keyIDKey{}, id)
+}
+
+func keyID(ctx context.Context) string {
+	id, _ := ctx.Value(keyIDKey{}).(string)
+	return id
+}
+
+// UserDefinedContext returns the userDefinedContext of the request a RowFunc
+// is called for.
+func UserDefinedContext(ctx context.Context) map[string]string {
+	m, _ := ctx.Value(userDefinedContextKey{}).(map[string]string)
+	return m
+}
+
+// ModeContextKey is the userDefinedContext entry Modes dispatches on, set
+// with user_defined_context = [('mode', 'add')] on CREATE FUNCTION.
+const ModeContextKey = "mode"
+
+// Modes serves several remote functions from one endpoint, picking the
+// Handler by the mode in userDefinedContext. Requests without a mode go to
+// Default so functions created before a service grew modes keep working.
+type Modes struct {
+	Default  string
+	Handlers map[string]*Handler
+
+	// request body limit in bytes, which applies before the mode is known
+	MaxRequestBytes int64
+}
+
+// NewModes returns Modes for handlers with the request size limit read from
+// FHE_MAX_REQUEST_BYTES.
+func NewModes(def string, handlers map[string]*Handler) *Modes {
+	for mode, h := range handlers {
+		if h.Name == "" {
+			h.Name = mode
+		}
+	}
+	return &Modes{Default: def, Handlers: handlers, MaxRequestBytes: RequestLimit()}
+}
+
+func (m *Modes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	WriteResponse(w, m.serve(w, r))
+}
+
+func (m *Modes) serve(w http.ResponseWriter, r *http.Request) *Response {
+	return serveBatch(w, r, m.MaxRequestBytes, m.pick)
+}
+
+// Do runs a batch that didn't come over HTTP, eg from the gRPC API, exactly
+// as ServeHTTP runs one from BigQuery. Its BYTES arguments may be []byte
+// rather than base64.
+func (m *Modes) Do(ctx context.Context, bqReq *Request) *Response {
+	return runBatch(ctx, func() (*Request, *Response) {
+		return bqReq, nil
+	}, m.pick)
+}
+
+// pick returns the Handler for the mode of bqReq and the name to label it
+// with.
+func (m *Modes) pick(bqReq *Request) (*Handler, string, error) {
+	mode := bqReq.UserDefinedContext[ModeContextKey]
+	if mode == "" {
+		mode = m.Default
+	}
+	h, ok := m.Handlers[mode]
+	if !ok {
+		// not labelled with the mode itself, which is whatever the caller sent
+		return nil, "unknown", fmt.Errorf("unknown mode %q", mode)
+	}
+	return h, h.Name, nil
+}
+
+// run calls Row for every row, Workers rows at a time, and keeps the
+// replies in order. The first failing row cancels the others.
+func (h *Handler) run(ctx context.Context, calls [][]interface{}) ([]string, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wait     sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		next     atomic.Int64
+	)
+	replies := make([]string, len(calls))
+
+	workers := h.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	for i := 0; i < min(workers, len(calls)); i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for ctx.Err() == nil {
+				j := int(next.Add(1) - 1)
+				if j >= len(calls) {
+					return
+				}
+				reply, err := h.runRow(ctx, j, calls[j])
+				if err != nil {
+					once.Do(func() {
+						firstErr = fmt.Errorf("Error processing row %d: %w", j, err)
+						cancel()
+					})
+					return
+				}
+				replies[j] = reply
+			}
+		}()
+	}
+
+	wait.Wait()
+	if firstErr == nil && ctx.Err() != nil {
+		// the request was cancelled, not the batch at fault
+		return nil, Unavailable(ctx.Err())
+	}
+	return replies, firstErr
+}
+
+// runRow computes row j in a span of its own.
+func (h *Handler) runRow(ctx context.Context, j int, row []interface{}) (string, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "row", trace.WithAttributes(
+		attribute.String("fhe.mode", h.Name),
+		attribute.Int("bq.row", j),
+	))
+	defer span.End()
+	if id := keyID(ctx); id != "" {
+		span.SetAttributes(attribute.String("fhe.key_id", id))
+	}
+	if id, ok := macKeyID(row); ok {
+		span.SetAttributes(attribute.String("fhe.mac_key_id", id))
+	}
+	metrics.InFlightRows.Inc()
+	reply, err := h.call(ctx, row)
+	metrics.InFlightRows.Dec()
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return reply, err
+}
+
+// macKeyID returns the MAC key id the first BYTES argument of row is
+// tagged with, or that of the first element of an ARRAY<BYTES>. Only the
+// start of the base64 is decoded.
+func macKeyID(row []interface{}) (string, bool) {
+	if len(row) == 0 {
+		return "", false
+	}
+	arg := row[0]
+	if a, ok := arg.([]interface{}); ok && len(a) > 0 {
+		arg = a[0]
+	}
+	if b, ok := arg.([]byte); ok {
+		return fhe.TagKeyID(b)
+	}
+	s, ok := arg.(string)
+	if !ok || len(s) < 8 {
+		return "", false
+	}
+	prefix, err := base64.StdEncoding.DecodeString(s[:8])
+	if err != nil {
+		return "", false
+	}
+	return fhe.TagKeyID(prefix)
+}
+
+// call runs Row and turns a panic into an error for that row only.
+func (h *Handler) call(ctx context.Context, row []interface{}) (reply string, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return h.Row(ctx, row)
+}
+
+// Bytes returns argument i of row as BYTES, which BigQuery sends base64
+// encoded and Modes.Do callers may pass as they are.
+func Bytes(row []interface{}, i int) ([]byte, error) {
+	if b, ok := row[i].([]byte); ok {
+		return b, nil
+	}
+	s, ok := row[i].(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid argument %d: expected base64 string", i)
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid argument %d: %v", i, err)
+	}
+	return b, nil
+}
+
+// Number returns argument i of row as a JSON number.
+func Number(row []interface{}, i int) (float64, error) {
+	n, ok := row[i].(float64)
+	if !ok {
+		return 0, fmt.Errorf("invalid argument %d: expected number", i)
+	}
+	return n, nil
+}
+
+// BytesArray returns argument i of row as ARRAY<BYTES>.
+func BytesArray(row []interface{}, i int) ([][]byte, error) {
+	a, ok := row[i].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid argument %d: expected array", i)
+	}
+	out := make([][]byte, len(a))
+	for j, v := range a {
+		if b, ok := v.([]byte); ok {
+			out[j] = b
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid argument %d: element %d is not a base64 string", i, j)
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid argument %d: element %d: %v", i, j, err)
+		}
+		out[j] = b
+	}
+	return out, nil
+}
+
+// Int64Array returns argument i of row as ARRAY<INT64>, whose elements come
+// as JSON numbers like Number's.
+func Int64Array(row []interface{}, i int) ([]int64, error) {
+	a, ok := row[i].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid argument %d: expected array", i)
+	}
+	out := make([]int64, len(a))
+	for j, v := range a {
+		n, ok := v.(float64)
+		if !ok || n != float64(int64(n)) {
+			return nil, fmt.Errorf("invalid argument %d: element %d is not an integer", i, j)
+		}
+		out[j] = int64(n)
+	}
+	return out, nil
+}
@@ -0,0 +1,396 @@
+package bq
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/fhe"
+	"example.com/fhe/logging"
+	"example.com/fhe/metrics"
+	"github.com/ldsec/lattigo/bfv"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func serve(t testing.TB, h http.Handler, body string) *Response {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	resp := &Response{}
+	if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+		t.Fatalf("response is not JSON: %v: %q", err, rec.Body.String())
+	}
+	if rec.Code != http.StatusOK {
+		resp.Status = rec.Code
+	}
+	return resp
+}
+
+func echo(ctx context.Context, row []interface{}) (string, error) {
+	b, err := Bytes(row, 0)
+	if err != nil {
+		return "", err
+	}
+	switch string(b) {
+	case "panic":
+		panic("boom")
+	case "fail":
+		return "", errors.New("failed")
+	case "busy":
+		return "", Unavailable(errors.New("busy"))
+	case "key":
+		return "", fmt.Errorf("loading key: %w", fhe.ErrKeyUnavailable)
+	}
+	return string(b), nil
+}
+
+func TestHandler(t *testing.T) {
+	h := NewHandler(1, echo)
+
+	resp := serve(t, h, `{"calls":[["YQ=="],["Yg=="],["Yw=="]]}`)
+	if resp.ErrorMessage != "" || strings.Join(resp.Replies, ",") != "a,b,c" {
+		t.Fatalf("got %+v, want replies a,b,c", resp)
+	}
+
+	tests := map[string]string{
+		"not json": `{"calls":`,
+		"arity":    `{"calls":[["YQ==","YQ=="]]}`,
+		"type":     `{"calls":[[1]]}`,
+		"base64":   `{"calls":[["!!"]]}`,
+		"error":    `{"calls":[["YQ=="],["ZmFpbA=="]]}`,
+		"panic":    `{"calls":[["YQ=="],["cGFuaWM="]]}`,
+	}
+	for name, body := range tests {
+		resp := serve(t, h, body)
+		if resp.ErrorMessage == "" || resp.Replies != nil || resp.Status != http.StatusBadRequest {
+			t.Errorf("%s: got %+v, want a 400 error", name, resp)
+		}
+	}
+
+	// errors worth retrying are 503 so BigQuery retries the batch
+	for name, body := range map[string]string{
+		"unavailable": `{"calls":[["YQ=="],["YnVzeQ=="]]}`,
+		"key":         `{"calls":[["a2V5"]]}`,
+	} {
+		resp := serve(t, h, body)
+		if resp.ErrorMessage == "" || resp.Status != http.StatusServiceUnavailable {
+			t.Errorf("%s: got %+v, want a 503 error", name, resp)
+		}
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	prev := admission
+	admission = &budget{max: 3}
+	t.Cleanup(func() { admission = prev })
+
+	// buffered for the lone batch at the end, which nobody waits for
+	started, release := make(chan bool, 8), make(chan bool)
+	h := NewHandler(1, func(ctx context.Context, row []interface{}) (string, error) {
+		started <- true
+		<-release
+		return "", nil
+	})
+	h.Workers = 4 // the rows of a batch must be in flight together
+	done := make(chan *Response)
+	go func() { done <- serve(t, h, `{"calls":[[""],[""]]}`) }()
+	<-started
+	<-started
+
+	// 2 rows in flight: 2 more are shed, 1 more fits
+	if resp := serve(t, h, `{"calls":[[""],[""]]}`); resp.Status != http.StatusTooManyRequests {
+		t.Errorf("got %+v over the budget, want 429", resp)
+	}
+	go func() { done <- serve(t, h, `{"calls":[[""]]}`) }()
+	<-started
+	close(release)
+	for i := 0; i < 2; i++ {
+		if resp := <-done; resp.ErrorMessage != "" {
+			t.Errorf("admitted batch failed: %+v", resp)
+		}
+	}
+
+	// alone, a batch bigger than the budget still runs
+	if resp := serve(t, h, `{"calls":[[""],[""],[""],[""]]}`); resp.ErrorMessage != "" {
+		t.Errorf("got %+v for a lone batch over the budget", resp)
+	}
+	if admission.used != 0 {
+		t.Errorf("%d rows still counted in flight", admission.used)
+	}
+}
+
+// No more than Workers rows of a batch run at once, and the replies keep
+// their order.
+func TestHandlerWorkers(t *testing.T) {
+	var mu sync.Mutex
+	running, most := 0, 0
+	h := NewHandler(1, func(ctx context.Context, row []interface{}) (string, error) {
+		mu.Lock()
+		running++
+		most = max(most, running)
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return echo(ctx, row)
+	})
+	h.Workers = 3
+
+	var calls []string
+	var want []string
+	for i := 0; i < 20; i++ {
+		calls = append(calls, fmt.Sprintf(`["%s"]`, base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(i)))))
+		want = append(want, strconv.Itoa(i))
+	}
+	resp := serve(t, h, `{"calls":[`+strings.Join(calls, ",")+`]}`)
+	if resp.ErrorMessage != "" || strings.Join(resp.Replies, ",") != strings.Join(want, ",") {
+		t.Fatalf("got %+v, want replies %v", resp, want)
+	}
+	if most > h.Workers {
+		t.Errorf("%d rows ran at once, want at most %d", most, h.Workers)
+	}
+
+	t.Setenv(BatchWorkersEnv, "5")
+	if h := NewHandler(1, echo); h.Workers != 5 {
+		t.Errorf("NewHandler() Workers = %d with %s=5", h.Workers, BatchWorkersEnv)
+	}
+}
+
+func TestHandlerMaxRequestBytes(t *testing.T) {
+	h := NewHandler(1, echo)
+	h.MaxRequestBytes = 16
+
+	resp := serve(t, h, `{"calls":[["YQ=="],["Yg=="],["Yw=="]]}`)
+	if !strings.Contains(resp.ErrorMessage, "too large") {
+		t.Fatalf("got %+v, want request body too large", resp)
+	}
+}
+
+// An EqParams ciphertext is 8MiB in base64, so a request under the default
+// limit holds 3 of them, MAC tags and JSON included: 3 rows of fhe_eq(x, c)
+// but not 4.
+func TestEqBatchFits(t *testing.T) {
+	m, err := fhe.NewMAC(bytes.Repeat([]byte{1}, fhe.MinMACKeyLen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := m.Marshal(bfv.NewCiphertext(fhe.EqParams(), 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := base64.StdEncoding.EncodeToString(ct)
+	h := NewHandler(2, func(ctx context.Context, row []interface{}) (string, error) {
+		return "", nil
+	})
+	for rows, fits := range map[int]bool{3: true, 4: false} {
+		calls := make([][]interface{}, rows)
+		for i := range calls {
+			calls[i] = []interface{}{x, 3}
+		}
+		body, err := json.Marshal(&Request{Calls: calls, UserDefinedContext: map[string]string{"mode": "eq"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := serve(t, h, string(body))
+		if (resp.ErrorMessage == "") != fits {
+			t.Errorf("%d rows, %d bytes: got %q, want fits %v", rows, len(body), resp.ErrorMessage, fits)
+		}
+	}
+}
+
+func TestModes(t *testing.T) {
+	count := NewHandler(1, func(ctx context.Context, row []interface{}) (string, error) {
+		a, err := BytesArray(row, 0)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(len(a)), nil
+	})
+	sum := NewHandler(1, func(ctx context.Context, row []interface{}) (string, error) {
+		a, err := Int64Array(row, 0)
+		if err != nil {
+			return "", err
+		}
+		var s int64
+		for _, v := range a {
+			s += v
+		}
+		return strconv.FormatInt(s, 10), nil
+	})
+	table := NewHandler(1, func(ctx context.Context, row []interface{}) (string, error) {
+		return UserDefinedContext(ctx)["table"], nil
+	})
+	m := NewModes("echo", map[string]*Handler{"echo": NewHandler(1, echo), "count": count, "sum": sum, "table": table})
+
+	tests := map[string]string{
+		`{"calls":[["YQ=="]]}`: "a",
+		`{"userDefinedContext":{"mode":"echo"},"calls":[["YQ=="]]}`:         "a",
+		`{"userDefinedContext":{"mode":"count"},"calls":[[["YQ==",""]]]}`:   "2",
+		`{"userDefinedContext":{"mode":"sum"},"calls":[[[1,-5,7]]]}`:        "3",
+		`{"userDefinedContext":{"mode":"table","table":"t"},"calls":[[1]]}`: "t",
+	}
+	for body, want := range tests {
+		resp := serve(t, m, body)
+		if resp.ErrorMessage != "" || strings.Join(resp.Replies, ",") != want {
+			t.Errorf("%s: got %+v, want %s", body, resp, want)
+		}
+	}
+	for _, body := range []string{
+		`{"userDefinedContext":{"mode":"nope"},"calls":[["YQ=="]]}`,
+		`{"userDefinedContext":{"mode":"count"},"calls":[["YQ=="]]}`,
+		`{"userDefinedContext":{"mode":"count"},"calls":[[["!!"]]]}`,
+		`{"userDefinedContext":{"mode":"sum"},"calls":[[[1.5]]]}`,
+		`{"userDefinedContext":{"mode":"sum"},"calls":[[["1"]]]}`,
+	} {
+		if resp := serve(t, m, body); resp.ErrorMessage == "" {
+			t.Errorf("%s: got %+v, want an error", body, resp)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewModes("echo", map[string]*Handler{"echo": NewHandler(1, echo)})
+	ok := metrics.Requests.WithLabelValues("echo", metrics.OK)
+	failed := metrics.Requests.WithLabelValues("echo", metrics.Error)
+	unknown := metrics.Requests.WithLabelValues("unknown", metrics.Error)
+	okBefore, failedBefore, unknownBefore := testutil.ToFloat64(ok), testutil.ToFloat64(failed), testutil.ToFloat64(unknown)
+
+	serve(t, m, `{"calls":[["YQ=="],["Yg=="],["Yw=="]]}`)
+	serve(t, m, `{"calls":[["!!"]]}`)
+	serve(t, m, `{"userDefinedContext":{"mode":"nope"},"calls":[["YQ=="]]}`)
+
+	for name, c := range map[string]struct{ got, want float64 }{
+		"ok":       {testutil.ToFloat64(ok) - okBefore, 1},
+		"error":    {testutil.ToFloat64(failed) - failedBefore, 1},
+		"unknown":  {testutil.ToFloat64(unknown) - unknownBefore, 1},
+		"inflight": {testutil.ToFloat64(metrics.InFlightRows), 0},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s = %v, want %v", name, c.got, c.want)
+		}
+	}
+	if n, err := testutil.GatherAndCount(metrics.Registry, "fhe_batch_rows", "fhe_request_duration_seconds"); err != nil || n == 0 {
+		t.Errorf("batch and duration histograms: %d series, %v", n, err)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(sdktrace.NewTracerProvider()) })
+
+	m := NewModes("echo", map[string]*Handler{"echo": NewHandler(1, echo)})
+	// the second row is tagged with key id 01020304
+	body := `{"requestId":"r1","caller":"//bigquery.googleapis.com/projects/p/jobs/j1","calls":[["YQ=="],["8QECAwQAAAA="]]}`
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	m.ServeHTTP(httptest.NewRecorder(), req.WithContext(WithKeyID(req.Context(), "d6365f2a2611d9f9")))
+
+	attrs := func(s sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+		m := make(map[attribute.Key]attribute.Value)
+		for _, kv := range s.Attributes() {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+	var batch sdktrace.ReadOnlySpan
+	rows := map[int64]sdktrace.ReadOnlySpan{}
+	for _, s := range rec.Ended() {
+		switch s.Name() {
+		case "bq echo":
+			batch = s
+		case "row":
+			rows[attrs(s)["bq.row"].AsInt64()] = s
+		}
+	}
+	if batch == nil || len(rows) != 2 {
+		t.Fatalf("got %d spans, want a batch and 2 rows", len(rec.Ended()))
+	}
+	if got := batch.Parent().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("batch span is in trace %s, not the traceparent's", got)
+	}
+	a := attrs(batch)
+	if a["bq.request_id"].AsString() != "r1" || a["bq.caller"].AsString() != "//bigquery.googleapis.com/projects/p/jobs/j1" ||
+		a["fhe.mode"].AsString() != "echo" || a["bq.rows"].AsInt64() != 2 || a["fhe.key_id"].AsString() != "d6365f2a2611d9f9" {
+		t.Errorf("batch span attributes %v", batch.Attributes())
+	}
+	for i, s := range rows {
+		if s.Parent().SpanID() != batch.SpanContext().SpanID() {
+			t.Errorf("row %d is not a child of the batch span", i)
+		}
+		if got := attrs(s)["fhe.key_id"].AsString(); got != "d6365f2a2611d9f9" {
+			t.Errorf("row %d key id = %q, want the batch's", i, got)
+		}
+	}
+	if _, ok := attrs(rows[0])["fhe.mac_key_id"]; ok {
+		t.Errorf("untagged row 0 has a MAC key id")
+	}
+	if got := attrs(rows[1])["fhe.mac_key_id"].AsString(); got != "01020304" {
+		t.Errorf("row 1 MAC key id = %q, want 01020304", got)
+	}
+}
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(logging.New(&buf, &logging.Config{SessionUser: logging.Redact, Caller: logging.Keep}))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	m := NewModes("echo", map[string]*Handler{"echo": NewHandler(1, echo)})
+	serve(t, m, `{"requestId":"r1","caller":"//bigquery/jobs/j1","sessionUser":"alice@example.com",`+
+		`"userDefinedContext":{"mode":"echo","secret":"s3cret"},"calls":[["YQ=="]]}`)
+	serve(t, m, `{"requestId":"r2","userDefinedContext":{"mode":"nope"},"calls":[]}`)
+
+	var got []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("%v: %s", err, line)
+		}
+		got = append(got, rec)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d records, want one per batch:\n%s", len(got), buf.String())
+	}
+	if r := got[0]; r["severity"] != "INFO" || r["requestId"] != "r1" || r["mode"] != "echo" || r["rows"] != 1.0 ||
+		r["caller"] != "//bigquery/jobs/j1" || r["sessionUser"] != "redacted" || r["userDefinedContext"] != "mode,secret" {
+		t.Errorf("batch record %v", r)
+	}
+	if r := got[1]; r["severity"] != "WARNING" || r["requestId"] != "r2" || r["error"] != `unknown mode "nope"` {
+		t.Errorf("failed batch record %v", r)
+	}
+	if strings.Contains(buf.String(), "alice") || strings.Contains(buf.String(), "s3cret") {
+		t.Errorf("log leaks the session user or context values:\n%s", buf.String())
+	}
+}
+
+func FuzzHandler(f *testing.F) {
+	f.Add(`{"calls":[["YQ=="],["Yg=="]]}`)
+	f.Add(`{"calls":[["cGFuaWM="]]}`)
+	f.Add(`{"calls":[[null]]}`)
+	f.Add(`{"userDefinedContext":{"mode":"add"},"calls":[]}`)
+
+	h := NewHandler(1, echo)
+	f.Fuzz(func(t *testing.T, body string) {
+		resp := serve(t, h, body)
+		if resp.ErrorMessage != "" && resp.Replies != nil {
+			t.Fatalf("got both replies and an error: %+v", resp)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package fhe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"example.com/fhe/metrics"
+	"github.com/ldsec/lattigo/bfv"
+)
+
+// MaxCiphertextDegree is the largest ciphertext degree accepted. lattigo's
+// evaluator only keeps scratch space for 6 polynomials, anything larger
+// panics while tensoring.
+const MaxCiphertextDegree = 5
+
+var ErrMalformedCiphertext = errors.New("fhe: malformed ciphertext")
+
+// CiphertextLen returns the serialized length of a ciphertext of the given
+// degree under params.
+func CiphertextLen(params *bfv.Parameters, degree int) int {
+	return 2 + (degree+1)*polyLen(params)
+}
+
+func polyLen(params *bfv.Parameters) int {
+	return 2 + (1<<params.LogN)*len(params.Qi)*8
+}
+
+// UnmarshalCiphertext decodes a ciphertext after checking its header against
+// params: the degree, ring degree and number of moduli must match and no
+// coefficient may exceed its modulus. bfv.Ciphertext.UnmarshalBinary trusts those
+// fields and will allocate or index whatever they say.
+func UnmarshalCiphertext(params *bfv.Parameters, data []byte) (*bfv.Ciphertext, error) {
+	defer metrics.Time(metrics.Unmarshal)()
+	metrics.In(len(data))
+	if _, err := checkCiphertext(params, data); err != nil {
+		return nil, err
+	}
+	ct := &bfv.Ciphertext{}
+	if err := ct.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return ct, nil
+}
+
+// checkCiphertext checks a serialized ciphertext against params the way
+// UnmarshalCiphertext does and returns its degree.
+func checkCiphertext(params *bfv.Parameters, data []byte) (int, error) {
+	if len(data) < 2 {
+		return 0, fmt.Errorf("%w: %d bytes", ErrMalformedCiphertext, len(data))
+	}
+
+	count := int(data[0])
+	if count < 2 || count-1 > MaxCiphertextDegree {
+		return 0, fmt.Errorf("%w: degree %d", ErrMalformedCiphertext, count-1)
+	}
+	if data[1] > 1 {
+		return 0, fmt.Errorf("%w: invalid NTT flag", ErrMalformedCiphertext)
+	}
+	if len(data) != CiphertextLen(params, count-1) {
+		return 0, fmt.Errorf("%w: %d bytes for degree %d", ErrMalformedCiphertext, len(data), count-1)
+	}
+
+	n := 1 << params.LogN
+	pointer := 2
+	for p := 0; p < count; p++ {
+		if uint64(data[pointer]) != params.LogN {
+			return 0, fmt.Errorf("%w: ring degree 2^%d", ErrMalformedCiphertext, data[pointer])
+		}
+		if int(data[pointer+1]) != len(params.Qi) {
+			return 0, fmt.Errorf("%w: %d moduli", ErrMalformedCiphertext, data[pointer+1])
+		}
+		pointer += 2
+
+		for _, qi := range params.Qi {
+			for j := 0; j < n; j++ {
+				// lattigo only reduces lazily, negating a zero leaves qi
+				if binary.BigEndian.Uint64(data[pointer:]) > qi {
+					return 0, fmt.Errorf("%w: coefficient out of range", ErrMalformedCiphertext)
+				}
+				pointer += 8
+			}
+		}
+	}
+
+	return count - 1, nil
+}
+
+// CiphertextHeader is the metadata lattigo writes in front of a ciphertext
+// and its first polynomial.
+type CiphertextHeader struct {
+	Degree int
+	NTT    bool
+	LogN   int
+	Moduli int
+}
+
+// ParseCiphertextHeader reads the header of a serialized ciphertext without
+// checking it against any parameters.
+func ParseCiphertextHeader(data []byte) (CiphertextHeader, error) {
+	if len(data) < 4 {
+		return CiphertextHeader{}, fmt.Errorf("%w: %d bytes", ErrMalformedCiphertext, len(data))
+	}
+	return CiphertextHeader{
+		Degree: int(data[0]) - 1,
+		NTT:    data[1] != 0,
+		LogN:   int(data[2]),
+		Moduli: int(data[3]),
+	}, nil
+}
@@ -0,0 +1,90 @@
+package fhe
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+func newTestCiphertext(t testing.TB, params *bfv.Parameters, v uint64) *bfv.Ciphertext {
+	t.Helper()
+	kgen := bfv.NewKeyGenerator(params)
+	_, pk := kgen.GenKeyPair()
+	encoder := bfv.NewEncoder(params)
+	pt := bfv.NewPlaintext(params)
+	encoder.EncodeUint([]uint64{v}, pt)
+	return bfv.NewEncryptorFromPk(params, pk).EncryptNew(pt)
+}
+
+func marshal(t testing.TB, ct *bfv.Ciphertext) []byte {
+	t.Helper()
+	b, err := ct.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestUnmarshalCiphertext(t *testing.T) {
+	params := DefaultParams()
+	ct := newTestCiphertext(t, params, 3)
+	valid := marshal(t, ct)
+	product := marshal(t, bfv.NewEvaluator(params).MulNew(ct, ct))
+
+	for _, b := range [][]byte{valid, product} {
+		got, err := UnmarshalCiphertext(params, b)
+		if err != nil {
+			t.Fatalf("UnmarshalCiphertext() = %v", err)
+		}
+		if !bytes.Equal(marshal(t, got), b) {
+			t.Fatalf("UnmarshalCiphertext() did not round trip")
+		}
+	}
+
+	corrupt := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), valid...))
+	}
+	pl := polyLen(params)
+	tests := map[string][]byte{
+		"empty":       {},
+		"header only": valid[:2],
+		"truncated":   valid[:len(valid)-1],
+		"trailing":    append(append([]byte(nil), valid...), 0),
+		"degree 0":    corrupt(func(b []byte) []byte { b[0] = 1; return b }),
+		"degree 255":  corrupt(func(b []byte) []byte { b[0] = 255; return b }),
+		"ntt":         corrupt(func(b []byte) []byte { b[1] = 2; return b }),
+		"ring degree": corrupt(func(b []byte) []byte { b[2+pl] = 63; return b }),
+		"moduli":      corrupt(func(b []byte) []byte { b[3] = 200; return b }),
+		"coefficient": corrupt(func(b []byte) []byte {
+			copy(b[4:12], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+			return b
+		}),
+	}
+	for name, b := range tests {
+		if _, err := UnmarshalCiphertext(params, b); !errors.Is(err, ErrMalformedCiphertext) {
+			t.Errorf("%s: UnmarshalCiphertext() = %v, want ErrMalformedCiphertext", name, err)
+		}
+	}
+}
+
+func FuzzUnmarshalCiphertext(f *testing.F) {
+	params := DefaultParams()
+	ct := newTestCiphertext(f, params, 7)
+	valid := marshal(f, ct)
+	f.Add(valid)
+	f.Add(valid[:2])
+	f.Add([]byte{63, 0, 63, 255})
+	f.Add(marshal(f, bfv.NewEvaluator(params).MulNew(ct, ct)))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		got, err := UnmarshalCiphertext(params, b)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(marshal(t, got), b) {
+			t.Fatalf("accepted ciphertext does not round trip")
+		}
+	})
+}
@@ -0,0 +1,3 @@
+// Package fhe holds the code shared by the BigQuery remote functions and the
+// app so both sides read and write ciphertexts the same way.
+package fhe
@@ -0,0 +1,146 @@
+package fhe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+const (
+	// EqPublicKeyURLEnv, EqSecretKeyURLEnv and EqRelinKeyURLEnv point
+	// encrypt, decrypt and mul at the keys for EqParams, by URL or file path.
+	EqPublicKeyURLEnv = "FHE_EQ_PUBLIC_KEY_URL"
+	EqSecretKeyURLEnv = "FHE_EQ_SECRET_KEY_URL"
+	EqRelinKeyURLEnv  = "FHE_EQ_RELIN_KEY_URL"
+)
+
+// EqParams returns the BFV parameters (128 bit security) fhe_eq works under.
+//
+// fhe_eq computes 1 - (x-c)^(T-1), which by Fermat is 1 when x = c and 0
+// otherwise, so its depth is that of the power. lattigo only batches with a
+// prime T = 1 mod 2N, which rules out small plaintext moduli: the smallest
+// is 40961 for DefaultParams' ring and nothing below 65537 works for the
+// larger ones. 65537 has T-1 = 2^16, so the power is exactly 16 squarings,
+// and a ring of 2^15 is the smallest with room for that many (2^14 manages
+// 8). With T unchanged values mean the same as under DefaultParams.
+//
+// The price is size: a ciphertext is 6 MB, 8 MiB in base64, so a 32 MiB
+// request or reply holds 3 of them, and the relinearization key is 30 MB.
+// Keep fhe_eq to the columns that need it.
+func EqParams() *bfv.Parameters {
+	return bfv.DefaultParams[bfv.PN15QP880]
+}
+
+// EqParamsName is the lattigo name of EqParams.
+const EqParamsName = "PN15QP880"
+
+// EqMulDepth is how many multiplications an EqParams ciphertext can take:
+// the 16 fhe_eq needs plus one fhe_mul to mask a value with its result.
+const EqMulDepth = 17
+
+// IsEqCiphertext reports whether data, with any MAC envelope opened, is a
+// ciphertext under EqParams rather than DefaultParams. It only looks at the
+// ring degree; UnmarshalCiphertext checks the rest.
+func IsEqCiphertext(data []byte) bool {
+	h, err := ParseCiphertextHeader(data)
+	return err == nil && uint64(h.LogN) == EqParams().LogN
+}
+
+// CiphertextParams returns EqParams for an EqParams ciphertext and
+// DefaultParams for anything else, so the plain evaluators serve both.
+func CiphertextParams(data []byte) *bfv.Parameters {
+	if IsEqCiphertext(data) {
+		return EqParams()
+	}
+	return DefaultParams()
+}
+
+// LoadEqKeys reads the relinearization key FHE_EQ_RELIN_KEY_URL points at.
+func LoadEqKeys(ctx context.Context, getenv func(string) string) (*EvalKeys, error) {
+	loc := getenv(EqRelinKeyURLEnv)
+	if loc == "" {
+		return nil, fmt.Errorf("%s is not set", EqRelinKeyURLEnv)
+	}
+	b, err := ReadKey(ctx, loc)
+	if err != nil {
+		return nil, err
+	}
+	rlk, err := UnmarshalRelinKey(EqParams(), b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", EqRelinKeyURLEnv, err)
+	}
+	return &EvalKeys{Relin: rlk}, nil
+}
+
+// PowDepth returns the multiplicative depth of x^e as Pow computes it,
+// ceil(log2 e), which no product of copies of x can beat.
+func PowDepth(e uint64) int {
+	return bits.Len64(e - 1)
+}
+
+// Pow returns x^e, relinearizing after every multiplication. It squares its
+// way up to the powers of two in e and then multiplies those, always the two
+// shallowest first, which keeps the depth to PowDepth(e).
+func Pow(ev bfv.Evaluator, keys *EvalKeys, x *bfv.Ciphertext, e uint64) (*bfv.Ciphertext, error) {
+	if keys == nil || keys.Relin == nil {
+		return nil, errors.New("pow needs a relinearization key")
+	}
+	if e == 0 {
+		return nil, errors.New("pow needs a positive exponent")
+	}
+	if x.Degree() != 1 {
+		return nil, fmt.Errorf("value has degree %d, relinearize it first", x.Degree())
+	}
+
+	type power struct {
+		ct    *bfv.Ciphertext
+		depth int
+	}
+	var factors []power
+	sq := x
+	for i := 0; ; i++ {
+		if e&(1<<uint(i)) != 0 {
+			factors = append(factors, power{sq, i})
+		}
+		if e>>uint(i+1) == 0 {
+			break
+		}
+		sq = ev.RelinearizeNew(ev.MulNew(sq, sq), keys.Relin)
+	}
+	for len(factors) > 1 {
+		sort.SliceStable(factors, func(i, j int) bool { return factors[i].depth < factors[j].depth })
+		a, b := factors[0], factors[1]
+		factors = append(factors[2:], power{ev.RelinearizeNew(ev.MulNew(a.ct, b.ct), keys.Relin), b.depth + 1})
+	}
+	return factors[0].ct, nil
+}
+
+// Eq returns an encryption of 1 if x = y and 0 otherwise, in the first slot
+// like any other value. It takes PowDepth(T-1) levels, all EqParams has but
+// one, so x and y should be fresh.
+func Eq(ev bfv.Evaluator, params *bfv.Parameters, encoder bfv.Encoder, keys *EvalKeys, x, y *bfv.Ciphertext) (*bfv.Ciphertext, error) {
+	if y.Degree() != 1 {
+		return nil, fmt.Errorf("value has degree %d, relinearize it first", y.Degree())
+	}
+	return isZero(ev, params, encoder, keys, ev.SubNew(x, y))
+}
+
+// EqValue is Eq against a public value c.
+func EqValue(ev bfv.Evaluator, params *bfv.Parameters, encoder bfv.Encoder, keys *EvalKeys, x *bfv.Ciphertext, c int64) (*bfv.Ciphertext, error) {
+	return isZero(ev, params, encoder, keys, ev.SubNew(x, EncodeValue(params, encoder, c)))
+}
+
+// isZero returns 1 - d^(T-1). The other slots of d are zero and stay so.
+func isZero(ev bfv.Evaluator, params *bfv.Parameters, encoder bfv.Encoder, keys *EvalKeys, d *bfv.Ciphertext) (*bfv.Ciphertext, error) {
+	p, err := Pow(ev, keys, d, params.T-1)
+	if err != nil {
+		return nil, err
+	}
+	ev.Neg(p, p)
+	ev.Add(p, EncodeValue(params, encoder, 1), p)
+	return p, nil
+}
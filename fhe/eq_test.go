@@ -0,0 +1,110 @@
+package fhe
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+func TestPowDepth(t *testing.T) {
+	for e, want := range map[uint64]int{1: 0, 2: 1, 3: 2, 4: 2, 5: 3, 7: 3, 8: 3, 40960: 16, 65536: 16} {
+		if got := PowDepth(e); got != want {
+			t.Errorf("PowDepth(%d) = %d, want %d", e, got, want)
+		}
+	}
+	if d := PowDepth(EqParams().T - 1); d >= EqMulDepth {
+		t.Errorf("fhe_eq takes %d levels, EqParams has %d", d, EqMulDepth)
+	}
+}
+
+func TestPow(t *testing.T) {
+	params := DefaultParams()
+	sk, pk := bfv.NewKeyGenerator(params).GenKeyPair()
+	keys := GenEvalKeys(params, sk)
+	encoder := bfv.NewEncoder(params)
+	x := bfv.NewEncryptorFromPk(params, pk).EncryptNew(EncodeValue(params, encoder, -7))
+	ev := bfv.NewEvaluator(params)
+	for e, want := range map[uint64]int64{1: -7, 2: 49} {
+		p, err := Pow(ev, keys, x, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := DecodeValue(encoder, bfv.NewDecryptor(params, sk).DecryptNew(p)); got != want {
+			t.Errorf("Pow(-7, %d) = %d, want %d", e, got, want)
+		}
+	}
+	if _, err := Pow(ev, keys, x, 0); err == nil {
+		t.Errorf("Pow(x, 0) succeeded")
+	}
+	if _, err := Pow(ev, &EvalKeys{}, x, 2); err == nil {
+		t.Errorf("Pow without a relinearization key succeeded")
+	}
+}
+
+func TestEq(t *testing.T) {
+	if testing.Short() {
+		t.Skip("EqParams ciphertexts take seconds per fhe_eq")
+	}
+	params := EqParams()
+	kg := bfv.NewKeyGenerator(params)
+	sk, pk := kg.GenKeyPair()
+	rlk, err := kg.GenRelinKey(sk, RelinDegree-1).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "eq-rlk.bin")
+	if err := os.WriteFile(path, rlk, 0644); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadEqKeys(context.Background(), func(string) string { return path })
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoder := bfv.NewEncoder(params)
+	encryptor := bfv.NewEncryptorFromPk(params, pk)
+	decryptor := bfv.NewDecryptor(params, sk)
+	ev := bfv.NewEvaluator(params)
+	enc := func(v int64) *bfv.Ciphertext {
+		return encryptor.EncryptNew(EncodeValue(params, encoder, v))
+	}
+
+	hit, err := EqValue(ev, params, encoder, keys, enc(3), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	miss, err := Eq(ev, params, encoder, keys, enc(-3), enc(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name string
+		ct   *bfv.Ciphertext
+		want int64
+	}{{"EqValue(3, 3)", hit, 1}, {"Eq(-3, 3)", miss, 0}} {
+		if got, err := DecodeValueChecked(encoder, decryptor.DecryptNew(c.ct)); err != nil || got != c.want {
+			t.Errorf("%s = %d, %v, want %d", c.name, got, err, c.want)
+		}
+	}
+
+	// the one level left masks a value
+	sum := ev.AddNew(ev.MulNew(hit, enc(100)), ev.MulNew(miss, enc(200)))
+	if got := DecodeValue(encoder, decryptor.DecryptNew(sum)); got != 100 {
+		t.Errorf("masked sum = %d, want 100", got)
+	}
+
+	if !IsEqCiphertext(mustMarshal(t, enc(1))) || IsEqCiphertext(mustMarshal(t, bfv.NewCiphertext(DefaultParams(), 1))) {
+		t.Errorf("IsEqCiphertext can't tell EqParams from DefaultParams")
+	}
+}
+
+func mustMarshal(t testing.TB, ct *bfv.Ciphertext) []byte {
+	t.Helper()
+	b, err := ct.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,129 @@
+package fhe
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+const (
+	// RelinKeyURLEnv and RotationKeyURLEnv point the evaluators at the
+	// evaluation keys, by URL or file path. They are made from the secret
+	// key but do not reveal it.
+	RelinKeyURLEnv    = "FHE_RELIN_KEY_URL"
+	RotationKeyURLEnv = "FHE_ROTATION_KEY_URL"
+)
+
+// RelinDegree is the highest ciphertext degree GenEvalKeys' relinearization
+// key brings back to 1, the degree of a product of two fresh ciphertexts.
+const RelinDegree = 2
+
+// EvalKeys are the keys some ops need besides their arguments. Either may be
+// nil, which fails only the ops that need it.
+type EvalKeys struct {
+	Relin    *bfv.EvaluationKey
+	Rotation *bfv.RotationKeys
+}
+
+// GenEvalKeys makes the relinearization key and the power of two rotation
+// keys for sk.
+func GenEvalKeys(params *bfv.Parameters, sk *bfv.SecretKey) *EvalKeys {
+	kg := bfv.NewKeyGenerator(params)
+	return &EvalKeys{
+		Relin:    kg.GenRelinKey(sk, RelinDegree-1),
+		Rotation: kg.GenRotationKeysPow2(sk),
+	}
+}
+
+// checkSwitchingKey checks the switching key at the start of data and
+// returns its length. A switching key has a pair of polynomials for each
+// group of len(Pi) moduli of Q.
+func checkSwitchingKey(params *bfv.Parameters, data []byte) (int, error) {
+	dec := int(params.Beta())
+	if len(data) == 0 || int(data[0]) != dec {
+		return 0, fmt.Errorf("%w: bad switching key", ErrMalformedKey)
+	}
+	moduli := len(params.Qi) + len(params.Pi)
+	size := 1 + 2*dec*(2+(1<<params.LogN)*moduli*8)
+	if len(data) < size {
+		return 0, fmt.Errorf("%w: switching key is short", ErrMalformedKey)
+	}
+	if err := checkKey(params, data[1:size], 2*dec); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// UnmarshalRelinKey decodes a relinearization key after checking it belongs
+// to params.
+func UnmarshalRelinKey(params *bfv.Parameters, data []byte) (*bfv.EvaluationKey, error) {
+	if len(data) == 0 || data[0] == 0 {
+		return nil, fmt.Errorf("%w: empty relinearization key", ErrMalformedKey)
+	}
+	p := 1
+	for i := 0; i < int(data[0]); i++ {
+		n, err := checkSwitchingKey(params, data[p:])
+		if err != nil {
+			return nil, err
+		}
+		p += n
+	}
+	if p != len(data) {
+		return nil, fmt.Errorf("%w: %d bytes", ErrMalformedKey, len(data))
+	}
+	rlk := new(bfv.EvaluationKey)
+	if err := rlk.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return rlk, nil
+}
+
+// UnmarshalRotationKeys decodes rotation keys after checking they belong to
+// params.
+func UnmarshalRotationKeys(params *bfv.Parameters, data []byte) (*bfv.RotationKeys, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: no rotation keys", ErrMalformedKey)
+	}
+	for p := 0; p < len(data); {
+		// a byte for the direction and three for the rotation
+		if len(data)-p < 4 || data[p] < bfv.RotationRight || data[p] > bfv.RotationRow {
+			return nil, fmt.Errorf("%w: bad rotation key header", ErrMalformedKey)
+		}
+		n, err := checkSwitchingKey(params, data[p+4:])
+		if err != nil {
+			return nil, err
+		}
+		p += 4 + n
+	}
+	rtk := new(bfv.RotationKeys)
+	if err := rtk.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return rtk, nil
+}
+
+// LoadEvalKeys reads the keys FHE_RELIN_KEY_URL and FHE_ROTATION_KEY_URL
+// point at. A variable that is not set leaves its key nil.
+func LoadEvalKeys(ctx context.Context, params *bfv.Parameters, getenv func(string) string) (*EvalKeys, error) {
+	keys := &EvalKeys{}
+	if loc := getenv(RelinKeyURLEnv); loc != "" {
+		b, err := ReadKey(ctx, loc)
+		if err != nil {
+			return nil, err
+		}
+		if keys.Relin, err = UnmarshalRelinKey(params, b); err != nil {
+			return nil, fmt.Errorf("%s: %v", RelinKeyURLEnv, err)
+		}
+	}
+	if loc := getenv(RotationKeyURLEnv); loc != "" {
+		b, err := ReadKey(ctx, loc)
+		if err != nil {
+			return nil, err
+		}
+		if keys.Rotation, err = UnmarshalRotationKeys(params, b); err != nil {
+			return nil, fmt.Errorf("%s: %v", RotationKeyURLEnv, err)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,50 @@
+package fhe
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+func TestEvalKeys(t *testing.T) {
+	params := DefaultParams()
+	sk, _ := bfv.NewKeyGenerator(params).GenKeyPair()
+	keys := GenEvalKeys(params, sk)
+	rlk, err := keys.Relin.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rtk, err := keys.Rotation.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := UnmarshalRelinKey(params, rlk); err != nil {
+		t.Errorf("UnmarshalRelinKey() = %v", err)
+	}
+	if _, err := UnmarshalRotationKeys(params, rtk); err != nil {
+		t.Errorf("UnmarshalRotationKeys() = %v", err)
+	}
+	for name, b := range map[string][]byte{
+		"empty":     {},
+		"truncated": rlk[:len(rlk)-1],
+		"longer":    append(append([]byte{}, rlk...), 0),
+		"degree":    append([]byte{2}, rlk[1:]...),
+		"rotations": rtk[:1000],
+	} {
+		if _, err := UnmarshalRelinKey(params, b); !errors.Is(err, ErrMalformedKey) {
+			t.Errorf("%s: UnmarshalRelinKey() = %v, want ErrMalformedKey", name, err)
+		}
+	}
+	for name, b := range map[string][]byte{
+		"empty":     {},
+		"truncated": rtk[:len(rtk)-1],
+		"direction": append([]byte{9}, rtk[1:]...),
+		"relin":     rlk,
+	} {
+		if _, err := UnmarshalRotationKeys(params, b); !errors.Is(err, ErrMalformedKey) {
+			t.Errorf("%s: UnmarshalRotationKeys() = %v, want ErrMalformedKey", name, err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Package expr parses the nested function calls used in the README queries,
+// such as fhe.fhe_decrypt(fhe.fhe_mul(fhe.fhe_add(fhe.fhe_encrypt(4), ecd1.x), ecd1.y)),
+// so the emulator and the fhe command evaluate the same expressions.
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Kind says which fields of a Node are set.
+type Kind int
+
+const (
+	Call Kind = iota
+	Lit
+	Col
+)
+
+// Node is one term of an expression.
+type Node struct {
+	Kind Kind
+
+	// Fn is the function name as written, lower cased, for a Call.
+	Fn   string
+	Args []*Node
+
+	// Lit is the value of an integer literal.
+	Lit int64
+
+	// Col is the column name without its table qualifier, ecd1.x is x.
+	Col string
+}
+
+// Columns returns the distinct columns n refers to in the order they appear.
+func (n *Node) Columns() []string {
+	var cols []string
+	seen := make(map[string]bool)
+	var walk func(*Node)
+	walk = func(n *Node) {
+		if n.Kind == Col && !seen[n.Col] {
+			seen[n.Col] = true
+			cols = append(cols, n.Col)
+		}
+		for _, a := range n.Args {
+			walk(a)
+		}
+	}
+	walk(n)
+	return cols
+}
+
+// FuncName strips the dataset from a function name, fhe.fhe_add is fhe_add.
+func FuncName(s string) string {
+	if i := strings.LastIndex(s, "."); i >= 0 {
+		s = s[i+1:]
+	}
+	return s
+}
+
+func colName(s string) string {
+	if i := strings.LastIndex(s, "."); i >= 0 {
+		s = s[i+1:]
+	}
+	return s
+}
+
+func tokenize(expr string) []string {
+	var toks []string
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == ',':
+			toks = append(toks, string(c))
+			i++
+		default:
+			j := i + 1
+			for j < len(expr) && !strings.ContainsRune(" \t\n\r(),", rune(expr[j])) {
+				j++
+			}
+			toks = append(toks, expr[i:j])
+			i = j
+		}
+	}
+	return toks
+}
+
+type parser struct {
+	toks []string
+	pos  int
+}
+
+// Parse parses expr into a tree of calls, integer literals and columns.
+func Parse(expr string) (*Node, error) {
+	p := &parser{toks: tokenize(expr)}
+	n, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, fmt.Errorf("expr: unexpected %q in %q", p.toks[p.pos], expr)
+	}
+	return n, nil
+}
+
+func (p *parser) next() string {
+	if p.pos >= len(p.toks) {
+		return ""
+	}
+	t := p.toks[p.pos]
+	p.pos++
+	return t
+}
+
+func (p *parser) term() (*Node, error) {
+	t := p.next()
+	switch {
+	case t == "" || t == "(" || t == ")" || t == ",":
+		return nil, fmt.Errorf("expr: unexpected %q", t)
+	case t[0] == '-' || (t[0] >= '0' && t[0] <= '9'):
+		v, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expr: invalid number %q", t)
+		}
+		return &Node{Kind: Lit, Lit: v}, nil
+	case p.pos < len(p.toks) && p.toks[p.pos] == "(":
+		p.pos++
+		n := &Node{Kind: Call, Fn: strings.ToLower(t)}
+		for {
+			if p.pos < len(p.toks) && p.toks[p.pos] == ")" && len(n.Args) == 0 {
+				p.pos++
+				return n, nil
+			}
+			arg, err := p.term()
+			if err != nil {
+				return nil, err
+			}
+			n.Args = append(n.Args, arg)
+			switch p.next() {
+			case ",":
+			case ")":
+				return n, nil
+			default:
+				return nil, fmt.Errorf("expr: unterminated call to %s", t)
+			}
+		}
+	default:
+		return &Node{Kind: Col, Col: colName(t)}, nil
+	}
+}
@@ -0,0 +1,29 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	n, err := Parse("fhe.FHE_decrypt(fhe.fhe_mul(fhe.fhe_add(fhe.fhe_encrypt(-4), ecd1.x), ecd1.y))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Kind != Call || n.Fn != "fhe.fhe_decrypt" || FuncName(n.Fn) != "fhe_decrypt" {
+		t.Errorf("Parse() root = %+v", n)
+	}
+	add := n.Args[0].Args[0]
+	if lit := add.Args[0].Args[0]; lit.Kind != Lit || lit.Lit != -4 {
+		t.Errorf("Parse() literal = %+v", lit)
+	}
+	if got := n.Columns(); !reflect.DeepEqual(got, []string{"x", "y"}) {
+		t.Errorf("Columns() = %v", got)
+	}
+
+	for _, s := range []string{"", "add(x", "add(x,)", "add(x y)", "x)", "99999999999999999999", "(x)"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q) succeeded", s)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Package fhetest has the helpers the services' tests share: ciphertexts
+// made the way fhe_encrypt makes them, and calls in the remote function
+// protocol.
+package fhetest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/fhe"
+	"example.com/fhe/bq"
+	"github.com/ldsec/lattigo/bfv"
+)
+
+// Ciphertext returns v encrypted under pk with the default parameters, as
+// fhe_encrypt encodes it.
+func Ciphertext(t testing.TB, pk *bfv.PublicKey, v int64) []byte {
+	t.Helper()
+	params := fhe.DefaultParams()
+	pt := fhe.EncodeValue(params, bfv.NewEncoder(params), v)
+	b, err := bfv.NewEncryptorFromPk(params, pk).EncryptNew(pt).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Call sends one batch of calls to h in mode, or h's default mode if mode
+// is empty, and returns the response. []byte arguments are sent base64
+// encoded, as BigQuery sends BYTES.
+func Call(t testing.TB, h http.HandlerFunc, mode string, calls ...[]interface{}) *bq.Response {
+	t.Helper()
+	req := &bq.Request{}
+	if mode != "" {
+		req.UserDefinedContext = map[string]string{bq.ModeContextKey: mode}
+	}
+	for _, call := range calls {
+		row := make([]interface{}, len(call))
+		for i, arg := range call {
+			row[i] = encodeArg(arg)
+		}
+		req.Calls = append(req.Calls, row)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+
+	resp := &bq.Response{}
+	if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+		t.Fatalf("response is not JSON: %v", err)
+	}
+	if resp.ErrorMessage == "" && len(resp.Replies) != len(calls) {
+		t.Fatalf("got %d replies, want %d", len(resp.Replies), len(calls))
+	}
+	return resp
+}
+
+func encodeArg(arg interface{}) interface{} {
+	switch arg := arg.(type) {
+	case []byte:
+		return base64.StdEncoding.EncodeToString(arg)
+	case [][]byte:
+		enc := make([]interface{}, len(arg))
+		for i, b := range arg {
+			enc[i] = encodeArg(b)
+		}
+		return enc
+	}
+	return arg
+}
@@ -0,0 +1,86 @@
+// Package keyring holds a service's keys so they can be rotated without a
+// restart. A Ring holds one generation of keys behind an atomic.Pointer: a
+// reload fetches a whole new generation and swaps it in only if it loaded,
+// and a batch pinned to the generation it started with finishes with it.
+package keyring
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Ring holds the current generation of keys of type K.
+type Ring[K any] struct {
+	// Load fetches a new generation and checks it, eg by encrypting and
+	// decrypting a known value. ctx is the request's, for a first load.
+	Load func(ctx context.Context) (*K, error)
+
+	mu  sync.Mutex // one Load at a time
+	cur atomic.Pointer[K]
+}
+
+// New returns a Ring loading generations with load.
+func New[K any](load func(ctx context.Context) (*K, error)) *Ring[K] {
+	return &Ring[K]{Load: load}
+}
+
+// Get returns the current generation, loading the first one if there is
+// none yet with ctx. A failed first load is retried on the next call.
+func (r *Ring[K]) Get(ctx context.Context) (*K, error) {
+	if k := r.cur.Load(); k != nil {
+		return k, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if k := r.cur.Load(); k != nil {
+		return k, nil
+	}
+	k, err := r.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.cur.Store(k)
+	return k, nil
+}
+
+// Reload loads a new generation and makes it current. If the load fails
+// the current generation stays.
+func (r *Ring[K]) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k, err := r.Load(context.Background())
+	if err != nil {
+		return err
+	}
+	r.cur.Store(k)
+	return nil
+}
+
+// Set makes k the current generation: one loaded and checked outside Load,
+// or a test's.
+func (r *Ring[K]) Set(k *K) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cur.Store(k)
+}
+
+type ctxKey struct{ ring any }
+
+// Pin returns ctx holding the current generation, loading it if need be,
+// for everything a batch does to use with From.
+func (r *Ring[K]) Pin(ctx context.Context) (context.Context, error) {
+	k, err := r.Get(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, ctxKey{r}, k), nil
+}
+
+// From returns the generation ctx was pinned to, or the current one.
+func (r *Ring[K]) From(ctx context.Context) (*K, error) {
+	if k, ok := ctx.Value(ctxKey{r}).(*K); ok {
+		return k, nil
+	}
+	return r.Get(ctx)
+}
@@ -0,0 +1,59 @@
+package keyring
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	gen, fail := 0, false
+	r := New(func(context.Context) (*int, error) {
+		if fail {
+			return nil, errors.New("unreachable")
+		}
+		gen++
+		g := gen
+		return &g, nil
+	})
+
+	fail = true
+	if _, err := r.Get(context.Background()); err == nil {
+		t.Fatalf("Get() succeeded with a failing Load")
+	}
+	fail = false
+	k, err := r.Get(context.Background())
+	if err != nil || *k != 1 {
+		t.Fatalf("Get() = %v, %v, want generation 1", k, err)
+	}
+
+	// a batch pinned to generation 1 keeps it across a reload
+	ctx, err := r.Pin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if k, _ := r.From(ctx); *k != 1 {
+		t.Errorf("pinned batch sees generation %d, want 1", *k)
+	}
+	if k, _ := r.From(context.Background()); *k != 2 {
+		t.Errorf("new batch sees generation %d, want 2", *k)
+	}
+
+	// a failed reload keeps the current generation
+	fail = true
+	if err := r.Reload(); err == nil {
+		t.Errorf("Reload() succeeded with a failing Load")
+	}
+	if k, _ := r.Get(context.Background()); *k != 2 {
+		t.Errorf("after a failed reload Get() = %d, want 2", *k)
+	}
+
+	// rings of the same type don't see each other's pins
+	other := New(func(context.Context) (*int, error) { v := 9; return &v, nil })
+	if k, _ := other.From(ctx); *k != 9 {
+		t.Errorf("other ring From(ctx) = %d, want its own 9", *k)
+	}
+}
@@ -0,0 +1,128 @@
+package fhe
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+const (
+	// PublicKeyURLEnv and SecretKeyURLEnv point encrypt and decrypt at a
+	// key, by URL or file path.
+	PublicKeyURLEnv = "FHE_PUBLIC_KEY_URL"
+	SecretKeyURLEnv = "FHE_SECRET_KEY_URL"
+)
+
+var ErrMalformedKey = errors.New("fhe: malformed key")
+
+// ErrKeyUnavailable is a key URL that didn't answer, or answered with a
+// status worth retrying. bq fails the batch with 503 so BigQuery retries.
+var ErrKeyUnavailable = errors.New("fhe: key unavailable")
+
+// KeyFetchTimeout bounds fetching a key from a URL, which keyrings and
+// first rows can be waiting on.
+const KeyFetchTimeout = 20 * time.Second
+
+var keyClient = &http.Client{Timeout: KeyFetchTimeout}
+
+// ReadKey reads a key from an http(s) URL or a local file. The key may be
+// raw, like app/pub.bin, or base64, like app/pub.b64. A fetch gives up
+// when ctx is done or after KeyFetchTimeout.
+func ReadKey(ctx context.Context, location string) ([]byte, error) {
+	var data []byte
+	if strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := keyClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrKeyUnavailable, err)
+		}
+		defer resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			return nil, fmt.Errorf("%w: %s: %s", ErrKeyUnavailable, location, resp.Status)
+		case resp.StatusCode != http.StatusOK:
+			return nil, fmt.Errorf("fhe: unable to get key from %s: %s", location, resp.Status)
+		}
+		data, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrKeyUnavailable, err)
+		}
+	} else {
+		var err error
+		data, err = os.ReadFile(location)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data))); err == nil {
+		return b, nil
+	}
+	return data, nil
+}
+
+// checkKey checks that data holds polys polynomials over Q and P of the
+// ring params describes. lattigo's key decoders trust the length fields.
+func checkKey(params *bfv.Parameters, data []byte, polys int) error {
+	moduli := len(params.Qi) + len(params.Pi)
+	n := 1 << params.LogN
+	size := 2 + n*moduli*8
+	if len(data) != polys*size {
+		return fmt.Errorf("%w: %d bytes", ErrMalformedKey, len(data))
+	}
+	for p := 0; p < polys; p++ {
+		h := data[p*size:]
+		if uint64(h[0]) != params.LogN || int(h[1]) != moduli {
+			return fmt.Errorf("%w: ring 2^%d with %d moduli", ErrMalformedKey, h[0], h[1])
+		}
+	}
+	return nil
+}
+
+// UnmarshalPublicKey decodes a public key after checking it belongs to params.
+func UnmarshalPublicKey(params *bfv.Parameters, data []byte) (*bfv.PublicKey, error) {
+	if err := checkKey(params, data, 2); err != nil {
+		return nil, err
+	}
+	pk := new(bfv.PublicKey)
+	if err := pk.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return pk, nil
+}
+
+// UnmarshalSecretKey decodes a secret key after checking it belongs to params.
+func UnmarshalSecretKey(params *bfv.Parameters, data []byte) (*bfv.SecretKey, error) {
+	if err := checkKey(params, data, 1); err != nil {
+		return nil, err
+	}
+	sk := new(bfv.SecretKey)
+	if err := sk.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return sk, nil
+}
+
+// KeyID names a key pair by the first 8 bytes of SHA-256 of its public key,
+// in hex.
+func KeyID(pk *bfv.PublicKey) (string, error) {
+	b, err := pk.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:8]), nil
+}
@@ -0,0 +1,96 @@
+package fhe
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+func TestKeys(t *testing.T) {
+	params := DefaultParams()
+	sk, pk := bfv.NewKeyGenerator(params).GenKeyPair()
+	pub, err := pk.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec, err := sk.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	raw := filepath.Join(dir, "pub.bin")
+	b64 := filepath.Join(dir, "pub.b64")
+	os.WriteFile(raw, pub, 0600)
+	os.WriteFile(b64, []byte(base64.StdEncoding.EncodeToString(pub)+"\n"), 0600)
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer srv.Close()
+
+	id, err := KeyID(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, loc := range []string{raw, b64, srv.URL + "/pub.b64"} {
+		b, err := ReadKey(context.Background(), loc)
+		if err != nil {
+			t.Fatalf("ReadKey(%s) = %v", loc, err)
+		}
+		got, err := UnmarshalPublicKey(params, b)
+		if err != nil {
+			t.Fatalf("UnmarshalPublicKey(%s) = %v", loc, err)
+		}
+		if gotID, _ := KeyID(got); gotID != id {
+			t.Errorf("KeyID(%s) = %s, want %s", loc, gotID, id)
+		}
+	}
+	if _, err := ReadKey(context.Background(), srv.URL+"/missing"); err == nil || errors.Is(err, ErrKeyUnavailable) {
+		t.Errorf("ReadKey(missing) = %v, want a permanent error", err)
+	}
+	busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "try later", http.StatusServiceUnavailable)
+	}))
+	defer busy.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	for _, loc := range []string{busy.URL + "/pub.b64", down.URL + "/pub.b64"} {
+		if _, err := ReadKey(context.Background(), loc); !errors.Is(err, ErrKeyUnavailable) {
+			t.Errorf("ReadKey(%s) = %v, want ErrKeyUnavailable", loc, err)
+		}
+	}
+	// a key server that hangs gives up with the request
+	hang := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer hang.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := ReadKey(ctx, hang.URL+"/pub.b64"); !errors.Is(err, ErrKeyUnavailable) {
+		t.Errorf("ReadKey(hang) = %v, want ErrKeyUnavailable", err)
+	}
+
+	if _, err := UnmarshalSecretKey(params, sec); err != nil {
+		t.Errorf("UnmarshalSecretKey() = %v", err)
+	}
+	bad := map[string][]byte{
+		"empty":     {},
+		"secret":    sec,
+		"truncated": pub[:len(pub)-1],
+		"ring":      append([]byte{13}, pub[1:]...),
+	}
+	for name, b := range bad {
+		if _, err := UnmarshalPublicKey(params, b); !errors.Is(err, ErrMalformedKey) {
+			t.Errorf("%s: UnmarshalPublicKey() = %v, want ErrMalformedKey", name, err)
+		}
+	}
+	if _, err := UnmarshalSecretKey(params, pub); !errors.Is(err, ErrMalformedKey) {
+		t.Errorf("UnmarshalSecretKey(public key) = %v, want ErrMalformedKey", err)
+	}
+}
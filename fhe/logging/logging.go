@@ -0,0 +1,227 @@
+// Package logging sets up log/slog for the services: JSON in the shape
+// Cloud Logging reads (severity, message, sourceLocation, trace), the
+// BigQuery requestId and the current span on every record logged with a
+// request's context, and sessionUser and caller redacted or hashed before
+// they are written.
+//
+// It is configured from the environment:
+//
+//	FHE_LOG_LEVEL         debug, info (default), warn or error
+//	FHE_LOG_FORMAT        json (default) or text, for reading locally
+//	FHE_LOG_SESSION_USER  redact (default), hash or keep
+//	FHE_LOG_CALLER        keep (default), hash or redact
+//	GOOGLE_CLOUD_PROJECT  makes trace ids the full resource names Cloud
+//	                      Logging links to Cloud Trace
+package logging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	LevelEnv       = "FHE_LOG_LEVEL"
+	FormatEnv      = "FHE_LOG_FORMAT"
+	SessionUserEnv = "FHE_LOG_SESSION_USER"
+	CallerEnv      = "FHE_LOG_CALLER"
+	ProjectEnv     = "GOOGLE_CLOUD_PROJECT"
+)
+
+// The attribute keys that are redacted. bq logs the fields of a BigQuery
+// request under them.
+const (
+	SessionUserKey = "sessionUser"
+	CallerKey      = "caller"
+	RequestIDKey   = "requestId"
+)
+
+// The special fields of Cloud Logging's structured logs.
+const (
+	traceKey          = "logging.googleapis.com/trace"
+	spanIDKey         = "logging.googleapis.com/spanId"
+	traceSampledKey   = "logging.googleapis.com/trace_sampled"
+	sourceLocationKey = "logging.googleapis.com/sourceLocation"
+)
+
+// Redaction is what happens to a sensitive field.
+type Redaction string
+
+const (
+	// Redact replaces the value with "redacted".
+	Redact Redaction = "redact"
+	// Hash replaces it with a prefix of its SHA-256, which still tells
+	// requests of the same user apart. Email addresses are easy to guess
+	// so this is a pseudonym, not anonymity.
+	Hash Redaction = "hash"
+	// Keep logs it as it is.
+	Keep Redaction = "keep"
+)
+
+func (r Redaction) apply(v string) string {
+	switch r {
+	case Keep:
+		return v
+	case Hash:
+		sum := sha256.Sum256([]byte(v))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	default:
+		return "redacted"
+	}
+}
+
+// Config is what the environment configures.
+type Config struct {
+	Level       slog.Level
+	Text        bool
+	SessionUser Redaction
+	Caller      Redaction
+	Project     string
+}
+
+// ConfigFromEnv reads the Config from the FHE_LOG_* variables.
+func ConfigFromEnv() (*Config, error) {
+	c := &Config{SessionUser: Redact, Caller: Keep, Project: os.Getenv(ProjectEnv)}
+	if v := os.Getenv(LevelEnv); v != "" {
+		if err := c.Level.UnmarshalText([]byte(v)); err != nil {
+			return nil, fmt.Errorf("logging: invalid %s: %v", LevelEnv, err)
+		}
+	}
+	switch v := os.Getenv(FormatEnv); v {
+	case "", "json":
+	case "text":
+		c.Text = true
+	default:
+		return nil, fmt.Errorf("logging: invalid %s %q, want json or text", FormatEnv, v)
+	}
+	for env, r := range map[string]*Redaction{SessionUserEnv: &c.SessionUser, CallerEnv: &c.Caller} {
+		switch v := Redaction(os.Getenv(env)); v {
+		case "":
+		case Redact, Hash, Keep:
+			*r = v
+		default:
+			return nil, fmt.Errorf("logging: invalid %s %q, want redact, hash or keep", env, v)
+		}
+	}
+	return c, nil
+}
+
+// InitFromEnv makes a logger configured from the environment the slog
+// default, which the log package writes through as well.
+func InitFromEnv() error {
+	c, err := ConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(New(os.Stdout, c))
+	return nil
+}
+
+// New returns a logger writing to w as c says.
+func New(w io.Writer, c *Config) *slog.Logger {
+	opts := &slog.HandlerOptions{
+		Level:     c.Level,
+		AddSource: !c.Text,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			switch a.Key {
+			case SessionUserKey:
+				return slog.String(a.Key, c.SessionUser.apply(a.Value.String()))
+			case CallerKey:
+				return slog.String(a.Key, c.Caller.apply(a.Value.String()))
+			}
+			if c.Text || len(groups) > 0 {
+				return a
+			}
+			switch a.Key {
+			case slog.LevelKey:
+				return slog.String("severity", severity(a.Value.Any().(slog.Level)))
+			case slog.MessageKey:
+				a.Key = "message"
+			case slog.SourceKey:
+				a.Key = sourceLocationKey
+			}
+			return a
+		},
+	}
+	var h slog.Handler
+	if c.Text {
+		h = slog.NewTextHandler(w, opts)
+	} else {
+		h = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(&contextHandler{Handler: h, project: c.Project})
+}
+
+// severity returns the Cloud Logging name of l.
+func severity(l slog.Level) string {
+	switch {
+	case l >= slog.LevelError:
+		return "ERROR"
+	case l >= slog.LevelWarn:
+		return "WARNING"
+	case l >= slog.LevelInfo:
+		return "INFO"
+	default:
+		return "DEBUG"
+	}
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns ctx with the BigQuery requestId every record
+// logged with it should carry.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// contextHandler adds the requestId and the span of the context a record
+// is logged with.
+type contextHandler struct {
+	slog.Handler
+	project string
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id, _ := ctx.Value(requestIDKey{}).(string); id != "" {
+		r.AddAttrs(slog.String(RequestIDKey, id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		id := sc.TraceID().String()
+		if h.project != "" {
+			id = "projects/" + h.project + "/traces/" + id
+		}
+		r.AddAttrs(
+			slog.String(traceKey, id),
+			slog.String(spanIDKey, sc.SpanID().String()),
+			slog.Bool(traceSampledKey, sc.IsSampled()),
+		)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs), project: h.project}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name), project: h.project}
+}
+
+// ContextKeys returns the keys of a userDefinedContext, which is logged
+// without its values: those can be whole lookup tables.
+func ContextKeys(udc map[string]string) string {
+	keys := make([]string, 0, len(udc))
+	for k := range udc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
@@ -0,0 +1,91 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	log := New(&buf, &Config{SessionUser: Redact, Caller: Hash, Project: "p"})
+
+	tid, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	sid, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: tid, SpanID: sid, TraceFlags: trace.FlagsSampled,
+	}))
+	ctx = WithRequestID(ctx, "req-1")
+	log.WarnContext(ctx, "batch failed", SessionUserKey, "alice@example.com", CallerKey, "//bigquery/jobs/1")
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("%v: %s", err, buf.Bytes())
+	}
+	for k, want := range map[string]any{
+		"severity":                             "WARNING",
+		"message":                              "batch failed",
+		SessionUserKey:                         "redacted",
+		RequestIDKey:                           "req-1",
+		"logging.googleapis.com/trace":         "projects/p/traces/4bf92f3577b34da6a3ce929d0e0e4736",
+		"logging.googleapis.com/spanId":        "00f067aa0ba902b7",
+		"logging.googleapis.com/trace_sampled": true,
+	} {
+		if got[k] != want {
+			t.Errorf("%s = %v, want %v", k, got[k], want)
+		}
+	}
+	if c, _ := got[CallerKey].(string); !strings.HasPrefix(c, "sha256:") || strings.Contains(c, "bigquery") {
+		t.Errorf("caller = %q, want a hash", c)
+	}
+	if _, ok := got[sourceLocationKey]; !ok {
+		t.Errorf("no %s in %v", sourceLocationKey, got)
+	}
+
+	// text is for reading locally: the usual keys, and below-level records
+	// dropped
+	buf.Reset()
+	log = New(&buf, &Config{Level: slog.LevelWarn, Text: true, SessionUser: Keep, Caller: Redact})
+	log.Info("batch")
+	log.Error("batch failed", SessionUserKey, "alice@example.com", CallerKey, "//bigquery/jobs/1")
+	if s := buf.String(); strings.Contains(s, "msg=batch\n") || !strings.Contains(s, "level=ERROR") ||
+		!strings.Contains(s, "sessionUser=alice@example.com") || !strings.Contains(s, "caller=redacted") {
+		t.Errorf("text log:\n%s", s)
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	c, err := ConfigFromEnv()
+	if err != nil || c.SessionUser != Redact || c.Caller != Keep || c.Level != slog.LevelInfo || c.Text {
+		t.Errorf("default config %+v, %v", c, err)
+	}
+
+	t.Setenv(LevelEnv, "debug")
+	t.Setenv(FormatEnv, "text")
+	t.Setenv(SessionUserEnv, "hash")
+	t.Setenv(CallerEnv, "redact")
+	c, err = ConfigFromEnv()
+	if err != nil || c.SessionUser != Hash || c.Caller != Redact || c.Level != slog.LevelDebug || !c.Text {
+		t.Errorf("config %+v, %v", c, err)
+	}
+
+	for env, v := range map[string]string{LevelEnv: "loud", FormatEnv: "xml", SessionUserEnv: "encrypt"} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, v)
+			if _, err := ConfigFromEnv(); err == nil {
+				t.Errorf("%s=%q was accepted", env, v)
+			}
+		})
+	}
+}
+
+func TestContextKeys(t *testing.T) {
+	if got := ContextKeys(map[string]string{"mode": "lut", "lut": "1,2,3"}); got != "lut,mode" {
+		t.Errorf("ContextKeys = %q", got)
+	}
+}
@@ -0,0 +1,230 @@
+package fhe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+const (
+	// LUTContextKey holds a table inline in userDefinedContext, as a JSON
+	// object from input to output: {"0": 0, "18": 1, "65": 2}.
+	LUTContextKey = "lut"
+	// LUTNameContextKey names a table in the file LUTConfigEnv points at.
+	LUTNameContextKey = "lut_name"
+	// LUTConfigEnv points at a JSON file of named tables,
+	// {"age_band": {"0": 0, "18": 1}}, by URL or file path.
+	LUTConfigEnv = "FHE_LUT_CONFIG"
+)
+
+// MaxLUTEntries caps the size of a table. A table of n entries is a
+// polynomial of degree n-1, and every power of x up to it is a
+// multiplication.
+const MaxLUTEntries = 256
+
+// MaxLUTBytes caps the JSON of a table, which is kept as the key of the
+// interpolated table. 256 entries of five digit numbers take under 5KiB.
+const MaxLUTBytes = 16 << 10
+
+var ErrMalformedLUT = errors.New("fhe: malformed lookup table")
+
+// LUT is a lookup table interpolated into the polynomial over Z_T of least
+// degree that goes through its entries. Inputs that aren't in the table map
+// to whatever the polynomial gives, not to an error.
+type LUT struct {
+	// Coeffs[i] is the coefficient of x^i, modulo T
+	Coeffs []uint64
+}
+
+// ParseLUT decodes a table in the JSON form LUTContextKey takes.
+func ParseLUT(data []byte) (map[int64]int64, error) {
+	if len(data) > MaxLUTBytes {
+		return nil, fmt.Errorf("%w: %d bytes, want at most %d", ErrMalformedLUT, len(data), MaxLUTBytes)
+	}
+	var raw map[string]int64
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedLUT, err)
+	}
+	table := make(map[int64]int64, len(raw))
+	for k, v := range raw {
+		in, err := strconv.ParseInt(k, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: input %q is not an integer", ErrMalformedLUT, k)
+		}
+		table[in] = v
+	}
+	return table, nil
+}
+
+// ParseLUTConfig decodes a file of named tables for LUTConfigEnv.
+func ParseLUTConfig(data []byte) (map[string]map[int64]int64, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedLUT, err)
+	}
+	tables := make(map[string]map[int64]int64, len(raw))
+	for name, b := range raw {
+		table, err := ParseLUT(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		tables[name] = table
+	}
+	return tables, nil
+}
+
+// NewLUT interpolates table over Z_T by Lagrange's formula. Inputs must be
+// distinct modulo T.
+func NewLUT(params *bfv.Parameters, table map[int64]int64) (*LUT, error) {
+	if len(table) < 2 || len(table) > MaxLUTEntries {
+		return nil, fmt.Errorf("%w: %d entries, want 2 to %d", ErrMalformedLUT, len(table), MaxLUTEntries)
+	}
+	t := params.T
+	mod := func(v int64) uint64 {
+		v %= int64(t)
+		if v < 0 {
+			v += int64(t)
+		}
+		return uint64(v)
+	}
+
+	var xs, ys []uint64
+	seen := make(map[uint64]int64)
+	ins := make([]int64, 0, len(table))
+	for in := range table {
+		ins = append(ins, in)
+	}
+	sort.Slice(ins, func(i, j int) bool { return ins[i] < ins[j] })
+	for _, in := range ins {
+		x := mod(in)
+		if prev, ok := seen[x]; ok {
+			return nil, fmt.Errorf("%w: inputs %d and %d are the same modulo %d", ErrMalformedLUT, prev, in, t)
+		}
+		seen[x] = in
+		xs = append(xs, x)
+		ys = append(ys, mod(table[in]))
+	}
+
+	// m(x) = prod_j (x - xs[j]), lowest coefficient first
+	n := len(xs)
+	m := make([]uint64, n+1)
+	m[0] = 1
+	for j, xj := range xs {
+		for i := j + 1; i > 0; i-- {
+			m[i] = (m[i-1] + (t-xj)*m[i]) % t
+		}
+		m[0] = (t - xj) * m[0] % t
+	}
+
+	coeffs := make([]uint64, n)
+	q := make([]uint64, n)
+	for i, xi := range xs {
+		// q(x) = m(x) / (x - xi) by synthetic division
+		q[n-1] = m[n]
+		for k := n - 1; k > 0; k-- {
+			q[k-1] = (m[k] + xi*q[k]) % t
+		}
+		// q(xi) = prod_{j != i} (xi - xs[j])
+		var d uint64
+		for k := n - 1; k >= 0; k-- {
+			d = (d*xi + q[k]) % t
+		}
+		s := ys[i] * modInverse(d, t) % t
+		for k := range coeffs {
+			coeffs[k] = (coeffs[k] + s*q[k]) % t
+		}
+	}
+	for len(coeffs) > 1 && coeffs[len(coeffs)-1] == 0 {
+		coeffs = coeffs[:len(coeffs)-1]
+	}
+	return &LUT{Coeffs: coeffs}, nil
+}
+
+// modInverse returns 1/a modulo the prime t.
+func modInverse(a, t uint64) uint64 {
+	r, e := uint64(1), t-2
+	for a %= t; e > 0; e >>= 1 {
+		if e&1 == 1 {
+			r = r * a % t
+		}
+		a = a * a % t
+	}
+	return r
+}
+
+// Degree returns the degree of the interpolated polynomial, at most one less
+// than the number of entries.
+func (l *LUT) Degree() int {
+	return len(l.Coeffs) - 1
+}
+
+// Depth returns the multiplicative depth EvalLUT takes: that of the highest
+// power, plus one for the coefficients, which are up to T/2 and add as much
+// noise as a multiplication.
+func (l *LUT) Depth() int {
+	if l.Degree() == 0 {
+		return 0
+	}
+	return PowDepth(uint64(l.Degree())) + 1
+}
+
+// CheckDepth returns an error if the table is too deep for params:
+// MulDepth for DefaultParams, so there only tables that are linear work,
+// and EqMulDepth for EqParams.
+func (l *LUT) CheckDepth(params *bfv.Parameters) error {
+	if params.LogN == EqParams().LogN {
+		if l.Depth() > EqMulDepth {
+			return fmt.Errorf("a table of degree %d takes %d multiplications, EqParams allow %d", l.Degree(), l.Depth(), EqMulDepth)
+		}
+		return nil
+	}
+	if l.Depth() > MulDepth {
+		return fmt.Errorf("a table of degree %d takes %d multiplications, the default parameters allow %d: only linear tables work there, encrypt x with fhe_encrypt_eq for this one", l.Degree(), l.Depth(), MulDepth)
+	}
+	return nil
+}
+
+// Eval returns the table looked up at x, which should be fresh and within
+// CheckDepth of params.
+func (l *LUT) Eval(ev bfv.Evaluator, params *bfv.Parameters, encoder bfv.Encoder, keys *EvalKeys, x *bfv.Ciphertext) (*bfv.Ciphertext, error) {
+	if err := l.CheckDepth(params); err != nil {
+		return nil, err
+	}
+	if x.Degree() != 1 {
+		return nil, fmt.Errorf("value has degree %d, relinearize it first", x.Degree())
+	}
+	if l.Degree() > 1 && (keys == nil || keys.Relin == nil) {
+		return nil, errors.New("a lookup table needs a relinearization key")
+	}
+
+	// x^i = x^h * x^(i-h) for the largest power of two h <= i, which is the
+	// least depth for each
+	powers := make([]*bfv.Ciphertext, l.Degree()+1)
+	powers[1] = x
+	for i, h := 2, 1; i <= l.Degree(); i++ {
+		if i == 2*h {
+			h = i
+			powers[i] = ev.RelinearizeNew(ev.MulNew(powers[h/2], powers[h/2]), keys.Relin)
+			continue
+		}
+		powers[i] = ev.RelinearizeNew(ev.MulNew(powers[h], powers[i-h]), keys.Relin)
+	}
+
+	w := make([]int64, len(l.Coeffs))
+	for i, c := range l.Coeffs {
+		w[i] = int64(c)
+	}
+	y := ev.MulScalarNew(x, 0)
+	if l.Degree() > 0 {
+		var err error
+		if y, err = WeightedSum(ev, params, powers[1:], w[1:]); err != nil {
+			return nil, err
+		}
+	}
+	ev.Add(y, EncodeValue(params, encoder, w[0]), y)
+	return y, nil
+}
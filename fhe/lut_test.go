@@ -0,0 +1,148 @@
+package fhe
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+// evalPlain evaluates the interpolated polynomial at v modulo T.
+func evalPlain(l *LUT, t uint64, v int64) int64 {
+	x := uint64((v%int64(t) + int64(t)) % int64(t))
+	var y uint64
+	for i := len(l.Coeffs) - 1; i >= 0; i-- {
+		y = (y*x + l.Coeffs[i]) % t
+	}
+	if y > t/2 {
+		return int64(y) - int64(t)
+	}
+	return int64(y)
+}
+
+func TestNewLUT(t *testing.T) {
+	params := DefaultParams()
+	for _, table := range []map[int64]int64{
+		{0: 0, 18: 1, 30: 2, 65: 3, 120: 3},
+		{-3: 10, 3: -10, 7: 0},
+		{1: 5, 2: 7, 3: 9},
+	} {
+		l, err := NewLUT(params, table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for in, out := range table {
+			if got := evalPlain(l, params.T, in); got != out {
+				t.Errorf("%v at %d = %d, want %d", table, in, got, out)
+			}
+		}
+	}
+
+	l, err := NewLUT(params, map[int64]int64{1: 5, 2: 7, 3: 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Degree() != 1 || l.Depth() != 1 {
+		t.Errorf("a linear table has degree %d and depth %d, want 1 and 1", l.Degree(), l.Depth())
+	}
+
+	for name, table := range map[string]map[int64]int64{
+		"one entry":  {1: 1},
+		"same mod T": {1: 1, 1 + int64(params.T): 2},
+	} {
+		if _, err := NewLUT(params, table); !errors.Is(err, ErrMalformedLUT) {
+			t.Errorf("%s: NewLUT() = %v, want ErrMalformedLUT", name, err)
+		}
+	}
+}
+
+func TestParseLUT(t *testing.T) {
+	table, err := ParseLUT([]byte(`{"-1": 4, "20": -2}`))
+	if err != nil || len(table) != 2 || table[-1] != 4 || table[20] != -2 {
+		t.Errorf("ParseLUT() = %v, %v", table, err)
+	}
+	tables, err := ParseLUTConfig([]byte(`{"band": {"0": 0, "18": 1}, "flag": {"1": 1, "2": 0}}`))
+	if err != nil || len(tables) != 2 || tables["band"][18] != 1 {
+		t.Errorf("ParseLUTConfig() = %v, %v", tables, err)
+	}
+	long := `{"1": 1,` + strings.Repeat(" ", MaxLUTBytes) + `"2": 2}`
+	for _, s := range []string{`[1, 2]`, `{"x": 1}`, `{"1": 1.5}`, `{"band": {"a": 1}}`, long} {
+		if _, err := ParseLUT([]byte(s)); !errors.Is(err, ErrMalformedLUT) {
+			t.Errorf("ParseLUT(%s) = %v, want ErrMalformedLUT", s, err)
+		}
+	}
+}
+
+func TestLUTEval(t *testing.T) {
+	params := DefaultParams()
+	sk, pk := bfv.NewKeyGenerator(params).GenKeyPair()
+	encoder := bfv.NewEncoder(params)
+	x := bfv.NewEncryptorFromPk(params, pk).EncryptNew(EncodeValue(params, encoder, 2))
+	ev := bfv.NewEvaluator(params)
+
+	linear, _ := NewLUT(params, map[int64]int64{1: 5, 2: 7, 3: 9})
+	y, err := linear.Eval(ev, params, encoder, nil, x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := DecodeValueChecked(encoder, bfv.NewDecryptor(params, sk).DecryptNew(y)); err != nil || got != 7 {
+		t.Errorf("linear table at 2 = %d, %v, want 7", got, err)
+	}
+
+	square, _ := NewLUT(params, map[int64]int64{1: 1, 2: 4, 3: 9})
+	if _, err := square.Eval(ev, params, encoder, GenEvalKeys(params, sk), x); err == nil {
+		t.Errorf("a quadratic table under DefaultParams succeeded")
+	}
+}
+
+func TestLUTCheckDepth(t *testing.T) {
+	table := map[int64]int64{}
+	for i := int64(0); i < MaxLUTEntries; i++ {
+		table[i] = i * i
+	}
+	widest, err := NewLUT(DefaultParams(), table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	linear, _ := NewLUT(DefaultParams(), map[int64]int64{1: 5, 2: 7})
+	if err := linear.CheckDepth(DefaultParams()); err != nil {
+		t.Errorf("a linear table under DefaultParams: %v", err)
+	}
+	if err := widest.CheckDepth(DefaultParams()); err == nil {
+		t.Errorf("a table of degree %d under DefaultParams passed", widest.Degree())
+	}
+	// every table NewLUT takes fits EqParams
+	if err := widest.CheckDepth(EqParams()); err != nil {
+		t.Errorf("a table of degree %d under EqParams: %v", widest.Degree(), err)
+	}
+}
+
+func TestLUTEvalEq(t *testing.T) {
+	if testing.Short() {
+		t.Skip("EqParams ciphertexts take seconds per multiplication")
+	}
+	params := EqParams()
+	kg := bfv.NewKeyGenerator(params)
+	sk, pk := kg.GenKeyPair()
+	keys := &EvalKeys{Relin: kg.GenRelinKey(sk, RelinDegree-1)}
+	encoder := bfv.NewEncoder(params)
+	encryptor := bfv.NewEncryptorFromPk(params, pk)
+	decryptor := bfv.NewDecryptor(params, sk)
+	ev := bfv.NewEvaluator(params)
+
+	bands := map[int64]int64{0: 0, 18: 1, 30: 2, 65: 3, 120: 3}
+	l, err := NewLUT(params, bands)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range []int64{18, 65} {
+		y, err := l.Eval(ev, params, encoder, keys, encryptor.EncryptNew(EncodeValue(params, encoder, in)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := DecodeValueChecked(encoder, decryptor.DecryptNew(y)); err != nil || got != bands[in] {
+			t.Errorf("band of %d = %d, %v, want %d", in, got, err, bands[in])
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package fhe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"example.com/fhe/metrics"
+)
+
+const (
+	// MACKeyEnv holds the base64 HMAC key this instance tags its outputs with.
+	MACKeyEnv = "FHE_MAC_KEY"
+	// MACVerifyKeysEnv holds extra comma separated base64 HMAC keys accepted on input.
+	MACVerifyKeysEnv = "FHE_MAC_VERIFY_KEYS"
+	// MACDisabledEnv set to true lets decrypt take untagged ciphertexts
+	// when neither key is set, eg next to the emulator.
+	MACDisabledEnv = "FHE_MAC_DISABLED"
+
+	// MinMACKeyLen is the shortest HMAC key accepted.
+	MinMACKeyLen = 32
+
+	macVersion  = 0xF1
+	macKeyIDLen = 4
+	macTagLen   = sha256.Size
+	macOverhead = 1 + macKeyIDLen + macTagLen
+)
+
+var (
+	ErrMACMissing    = errors.New("fhe: ciphertext is not tagged")
+	ErrMACUnknownKey = errors.New("fhe: ciphertext is tagged with an unknown key")
+	ErrMACInvalid    = errors.New("fhe: ciphertext tag does not verify")
+	ErrMACNoSignKey  = errors.New("fhe: no signing key configured")
+	ErrMACRequired   = fmt.Errorf("fhe: no key to verify ciphertexts with, set %s or %s, or %s=1", MACKeyEnv, MACVerifyKeysEnv, MACDisabledEnv)
+)
+
+type macKeyID [macKeyIDLen]byte
+
+// MAC tags serialized ciphertexts with HMAC-SHA256 so services only accept
+// values that came out of a trusted instance.
+//
+// A tagged ciphertext is laid out as
+//
+//	0xF1 | keyID (4 bytes) | ciphertext | HMAC-SHA256(key, 0xF1 | keyID | ciphertext)
+//
+// where keyID is the first 4 bytes of SHA-256(key). The leading byte can never
+// start a raw lattigo ciphertext so untagged input is rejected up front.
+//
+// A nil *MAC disables tagging: Seal and Open return their input unchanged.
+type MAC struct {
+	signID  macKeyID
+	signKey []byte
+	keys    map[macKeyID][]byte
+}
+
+func newMACKeyID(key []byte) macKeyID {
+	var id macKeyID
+	h := sha256.Sum256(key)
+	copy(id[:], h[:macKeyIDLen])
+	return id
+}
+
+// NewMAC returns a MAC that tags with signKey and accepts tags made with
+// signKey or any of verifyKeys. signKey may be nil for verify-only use.
+func NewMAC(signKey []byte, verifyKeys ...[]byte) (*MAC, error) {
+	m := &MAC{
+		keys: make(map[macKeyID][]byte),
+	}
+	if signKey != nil {
+		if len(signKey) < MinMACKeyLen {
+			return nil, fmt.Errorf("fhe: signing key must be at least %d bytes", MinMACKeyLen)
+		}
+		m.signKey = signKey
+		m.signID = newMACKeyID(signKey)
+		m.keys[m.signID] = signKey
+	}
+	for _, k := range verifyKeys {
+		if len(k) < MinMACKeyLen {
+			return nil, fmt.Errorf("fhe: verify key must be at least %d bytes", MinMACKeyLen)
+		}
+		m.keys[newMACKeyID(k)] = k
+	}
+	if len(m.keys) == 0 {
+		return nil, errors.New("fhe: no MAC keys provided")
+	}
+	return m, nil
+}
+
+// NewMACFromEnv builds a MAC from FHE_MAC_KEY and FHE_MAC_VERIFY_KEYS.
+// It returns a nil MAC (tagging disabled) if neither is set.
+func NewMACFromEnv() (*MAC, error) {
+	signB64 := os.Getenv(MACKeyEnv)
+	verifyB64 := os.Getenv(MACVerifyKeysEnv)
+	if signB64 == "" && verifyB64 == "" {
+		return nil, nil
+	}
+
+	var signKey []byte
+	if signB64 != "" {
+		k, err := base64.StdEncoding.DecodeString(signB64)
+		if err != nil {
+			return nil, fmt.Errorf("fhe: invalid %s: %v", MACKeyEnv, err)
+		}
+		signKey = k
+	}
+
+	var verifyKeys [][]byte
+	for _, s := range strings.Split(verifyB64, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		k, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("fhe: invalid %s: %v", MACVerifyKeysEnv, err)
+		}
+		verifyKeys = append(verifyKeys, k)
+	}
+
+	return NewMAC(signKey, verifyKeys...)
+}
+
+// RequireMAC returns ErrMACRequired if m is nil, so tags aren't checked,
+// and FHE_MAC_DISABLED doesn't say that is on purpose. decrypt checks it
+// before decrypting anything, so a variable left unset doesn't turn it into
+// an oracle for whatever a caller sends.
+func RequireMAC(m *MAC) error {
+	if m != nil {
+		return nil
+	}
+	if off, _ := strconv.ParseBool(os.Getenv(MACDisabledEnv)); off {
+		return nil
+	}
+	return ErrMACRequired
+}
+
+func (m *MAC) sum(key []byte, header []byte, ct []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(header)
+	h.Write(ct)
+	return h.Sum(nil)
+}
+
+// Seal returns ct with a version byte, key id and tag attached.
+func (m *MAC) Seal(ct []byte) ([]byte, error) {
+	if m == nil {
+		return ct, nil
+	}
+	if m.signKey == nil {
+		return nil, ErrMACNoSignKey
+	}
+
+	out := make([]byte, 0, len(ct)+macOverhead)
+	out = append(out, macVersion)
+	out = append(out, m.signID[:]...)
+	header := out[:1+macKeyIDLen]
+	out = append(out, ct...)
+	out = append(out, m.sum(m.signKey, header, ct)...)
+	return out, nil
+}
+
+// Marshal encodes v, a ciphertext or an Aggregate, and seals it. The time
+// it takes and the size of the result go in the metrics.
+func (m *MAC) Marshal(v encoding.BinaryMarshaler) ([]byte, error) {
+	done := metrics.Time(metrics.Marshal)
+	b, err := v.MarshalBinary()
+	if err == nil {
+		b, err = m.Seal(b)
+	}
+	done()
+	if err != nil {
+		return nil, err
+	}
+	metrics.Out(len(b))
+	return b, nil
+}
+
+// Open verifies a tagged ciphertext and returns the raw ciphertext bytes.
+func (m *MAC) Open(tagged []byte) ([]byte, error) {
+	if m == nil {
+		return tagged, nil
+	}
+	if len(tagged) < macOverhead || tagged[0] != macVersion {
+		return nil, ErrMACMissing
+	}
+
+	var id macKeyID
+	copy(id[:], tagged[1:1+macKeyIDLen])
+	key, ok := m.keys[id]
+	if !ok {
+		return nil, ErrMACUnknownKey
+	}
+
+	header := tagged[:1+macKeyIDLen]
+	ct := tagged[1+macKeyIDLen : len(tagged)-macTagLen]
+	tag := tagged[len(tagged)-macTagLen:]
+	if !hmac.Equal(tag, m.sum(key, header, ct)) {
+		return nil, ErrMACInvalid
+	}
+	return ct, nil
+}
+
+// TagKeyID returns the id of the key a tagged ciphertext claims, in hex,
+// from its first 5 bytes alone; ok is false if they don't start a tag.
+func TagKeyID(prefix []byte) (keyID string, ok bool) {
+	if len(prefix) < 1+macKeyIDLen || prefix[0] != macVersion {
+		return "", false
+	}
+	return hex.EncodeToString(prefix[1 : 1+macKeyIDLen]), true
+}
+
+// ParseTag splits a tagged ciphertext into the id of the key it claims to be
+// tagged with, in hex, and the raw ciphertext. It does not verify the tag;
+// ok is false if data is not tagged at all.
+func ParseTag(data []byte) (keyID string, ct []byte, ok bool) {
+	if len(data) < macOverhead || data[0] != macVersion {
+		return "", data, false
+	}
+	return hex.EncodeToString(data[1 : 1+macKeyIDLen]), data[1+macKeyIDLen : len(data)-macTagLen], true
+}
@@ -0,0 +1,174 @@
+package fhe
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func macKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, MinMACKeyLen)
+}
+
+func mustMAC(t *testing.T, sign []byte, verify ...[]byte) *MAC {
+	t.Helper()
+	m, err := NewMAC(sign, verify...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMAC(t *testing.T) {
+	ct := []byte("a ciphertext, as far as the MAC cares")
+	a, b := macKey('a'), macKey('b')
+	sealed, err := mustMAC(t, a).Seal(ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flip := func(i int) []byte {
+		out := bytes.Clone(sealed)
+		out[i] ^= 1
+		return out
+	}
+
+	for _, test := range []struct {
+		name   string
+		mac    *MAC
+		tagged []byte
+		want   error
+	}{
+		{"round trip", mustMAC(t, a), sealed, nil},
+		{"verify only", mustMAC(t, nil, a), sealed, nil},
+		{"tampered tag", mustMAC(t, a), flip(len(sealed) - 1), ErrMACInvalid},
+		{"tampered body", mustMAC(t, a), flip(1 + macKeyIDLen), ErrMACInvalid},
+		{"tampered key id", mustMAC(t, a), flip(1), ErrMACUnknownKey},
+		{"unknown key", mustMAC(t, b), sealed, ErrMACUnknownKey},
+		{"untagged", mustMAC(t, a), ct, ErrMACMissing},
+		{"short", mustMAC(t, a), sealed[:macOverhead-1], ErrMACMissing},
+		{"empty", mustMAC(t, a), nil, ErrMACMissing},
+	} {
+		got, err := test.mac.Open(test.tagged)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: Open() = %v, want %v", test.name, err, test.want)
+			continue
+		}
+		if err == nil && !bytes.Equal(got, ct) {
+			t.Errorf("%s: Open() = %q, want %q", test.name, got, ct)
+		}
+	}
+
+	if _, err := mustMAC(t, nil, a).Seal(ct); !errors.Is(err, ErrMACNoSignKey) {
+		t.Errorf("Seal() with verify-only keys = %v, want ErrMACNoSignKey", err)
+	}
+	var off *MAC
+	if got, err := off.Seal(ct); err != nil || !bytes.Equal(got, ct) {
+		t.Errorf("nil MAC Seal() = %q, %v", got, err)
+	}
+	if got, err := off.Open(ct); err != nil || !bytes.Equal(got, ct) {
+		t.Errorf("nil MAC Open() = %q, %v", got, err)
+	}
+	if id, ok := TagKeyID(sealed); !ok || len(id) != 2*macKeyIDLen {
+		t.Errorf("TagKeyID() = %q, %v", id, ok)
+	}
+}
+
+// encrypt tags under A, the evaluators under B, and decrypt accepts both.
+func TestMACRotation(t *testing.T) {
+	ct := []byte("ciphertext")
+	a, b := macKey('a'), macKey('b')
+	encrypt := mustMAC(t, a)
+	evaluator := mustMAC(t, b, a)
+	decrypt := mustMAC(t, nil, a, b)
+
+	fromEncrypt, err := encrypt.Seal(ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := evaluator.Open(fromEncrypt)
+	if err != nil {
+		t.Fatalf("evaluator Open() of encrypt's output = %v", err)
+	}
+	fromEvaluator, err := evaluator.Seal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, tagged := range map[string][]byte{"encrypt": fromEncrypt, "evaluator": fromEvaluator} {
+		if got, err := decrypt.Open(tagged); err != nil || !bytes.Equal(got, ct) {
+			t.Errorf("decrypt Open() of %s's output = %q, %v", name, got, err)
+		}
+	}
+	// encrypt doesn't hold B, so it can't take the evaluators' output back
+	if _, err := encrypt.Open(fromEvaluator); !errors.Is(err, ErrMACUnknownKey) {
+		t.Errorf("encrypt Open() of the evaluator's output = %v, want ErrMACUnknownKey", err)
+	}
+}
+
+func TestNewMAC(t *testing.T) {
+	short := make([]byte, MinMACKeyLen-1)
+	for name, keys := range map[string][][]byte{
+		"no keys":      {nil},
+		"short sign":   {short},
+		"short verify": {macKey('a'), short},
+	} {
+		if _, err := NewMAC(keys[0], keys[1:]...); err == nil {
+			t.Errorf("NewMAC with %s succeeded", name)
+		}
+	}
+}
+
+func TestNewMACFromEnv(t *testing.T) {
+	a, b := base64.StdEncoding.EncodeToString(macKey('a')), base64.StdEncoding.EncodeToString(macKey('b'))
+	sealed, err := mustMAC(t, macKey('b')).Seal([]byte("ct"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name         string
+		sign, verify string
+		nilMAC       bool
+		fails        bool
+		opensB       bool
+	}{
+		{name: "unset", nilMAC: true},
+		{name: "sign", sign: a},
+		{name: "verify list", verify: a + ", " + b, opensB: true},
+		{name: "sign and verify", sign: a, verify: b, opensB: true},
+		{name: "bad base64", sign: "not base64!", fails: true},
+		{name: "bad verify key", verify: a + ",nope!", fails: true},
+		{name: "short key", sign: base64.StdEncoding.EncodeToString([]byte("short")), fails: true},
+	} {
+		t.Setenv(MACKeyEnv, test.sign)
+		t.Setenv(MACVerifyKeysEnv, test.verify)
+		m, err := NewMACFromEnv()
+		if (err != nil) != test.fails || err == nil && (m == nil) != test.nilMAC {
+			t.Errorf("%s: NewMACFromEnv() = %v, %v", test.name, m, err)
+			continue
+		}
+		if m == nil {
+			continue
+		}
+		if _, err := m.Open(sealed); (err == nil) != test.opensB {
+			t.Errorf("%s: Open() of a B tag = %v", test.name, err)
+		}
+	}
+}
+
+func TestRequireMAC(t *testing.T) {
+	t.Setenv(MACDisabledEnv, "")
+	if err := RequireMAC(nil); !errors.Is(err, ErrMACRequired) {
+		t.Errorf("RequireMAC(nil) = %v, want ErrMACRequired", err)
+	}
+	if err := RequireMAC(mustMAC(t, macKey('a'))); err != nil {
+		t.Errorf("RequireMAC(key) = %v", err)
+	}
+	t.Setenv(MACDisabledEnv, "1")
+	if err := RequireMAC(nil); err != nil {
+		t.Errorf("RequireMAC(nil) with %s=1 = %v", MACDisabledEnv, err)
+	}
+	t.Setenv(MACDisabledEnv, "false")
+	if err := RequireMAC(nil); err == nil {
+		t.Errorf("RequireMAC(nil) with %s=false succeeded", MACDisabledEnv)
+	}
+}
@@ -0,0 +1,145 @@
+// Package metrics holds the Prometheus metrics every service exports on
+// /metrics: requests by mode and outcome, rows per batch, the latency of
+// each step of a row, ciphertext sizes, rows in flight and whether the keys
+// loaded. They are registered with Registry rather than the default one so
+// a service exports nothing it didn't ask for.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The steps of a row that OpSeconds is labelled with.
+const (
+	Unmarshal = "unmarshal"
+	Evaluate  = "evaluate"
+	Marshal   = "marshal"
+	Encrypt   = "encrypt"
+	Decrypt   = "decrypt"
+)
+
+// The outcomes of a request: answered, failed for good (400), failed in a
+// way BigQuery retries (503) or shed for overload (429).
+const (
+	OK          = "ok"
+	Error       = "error"
+	Unavailable = "unavailable"
+	Shed        = "shed"
+)
+
+var (
+	// Registry holds the metrics below and the Go runtime and process
+	// collectors, which report goroutines and memory.
+	Registry = prometheus.NewRegistry()
+
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fhe_requests_total",
+		Help: "BigQuery batches served, by mode and outcome.",
+	}, []string{"mode", "outcome"})
+
+	RequestSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fhe_request_duration_seconds",
+		Help:    "Time to answer a BigQuery batch, by mode.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"mode"})
+
+	BatchRows = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fhe_batch_rows",
+		Help:    "Rows in a BigQuery batch, by mode.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"mode"})
+
+	OpSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fhe_op_duration_seconds",
+		Help:    "Time spent in one step of a row: unmarshal, evaluate, marshal, encrypt or decrypt.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"op"})
+
+	CiphertextBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fhe_ciphertext_bytes",
+		Help:    "Size of the ciphertexts read (in) and written (out).",
+		Buckets: prometheus.ExponentialBuckets(1<<10, 4, 9),
+	}, []string{"direction"})
+
+	InFlightRows = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "fhe_inflight_rows",
+		Help: "Rows being computed right now, each in its own goroutine.",
+	})
+
+	KeyLoaded = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fhe_key_loaded",
+		Help: "1 once a key has been loaded, by key.",
+	}, []string{"key"})
+
+	KeyLoadErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fhe_key_load_errors_total",
+		Help: "Failed attempts to load a key, by key.",
+	}, []string{"key"})
+
+	KeyReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fhe_key_reloads_total",
+		Help: "Key reloads, by trigger (sighup, admin or watch) and outcome.",
+	}, []string{"trigger", "outcome"})
+
+	KeyReloadTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "fhe_key_reload_timestamp_seconds",
+		Help: "When the keys were last reloaded, in seconds since the epoch.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Requests, RequestSeconds, BatchRows, OpSeconds, CiphertextBytes,
+		InFlightRows, KeyLoaded, KeyLoadErrors, KeyReloads, KeyReloadTime,
+	)
+}
+
+// Handler serves Registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Time starts timing op and returns the func that records it:
+//
+//	done := metrics.Time(metrics.Evaluate)
+//	z := fhe.Add(ev, x, y)
+//	done()
+func Time(op string) func() {
+	start := time.Now()
+	return func() {
+		OpSeconds.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	}
+}
+
+// In and Out record the size of a ciphertext read or written.
+func In(n int)  { CiphertextBytes.WithLabelValues("in").Observe(float64(n)) }
+func Out(n int) { CiphertextBytes.WithLabelValues("out").Observe(float64(n)) }
+
+// KeyLoad records an attempt to load key: it is marked loaded if err is
+// nil and counted as a failure otherwise. It returns err.
+func KeyLoad(key string, err error) error {
+	if err != nil {
+		KeyLoadErrors.WithLabelValues(key).Inc()
+		return err
+	}
+	KeyLoaded.WithLabelValues(key).Set(1)
+	return nil
+}
+
+// KeyReload records a reload of the keys by trigger. It returns err.
+func KeyReload(trigger string, err error) error {
+	if err != nil {
+		KeyReloads.WithLabelValues(trigger, Error).Inc()
+		return err
+	}
+	KeyReloads.WithLabelValues(trigger, OK).Inc()
+	KeyReloadTime.SetToCurrentTime()
+	return nil
+}
@@ -0,0 +1,84 @@
+package fhe
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+// EncodeOneHot puts a 1 in slot v and zero everywhere else, the category v
+// out of buckets as fhe_encrypt_onehot encrypts it. Summing such
+// ciphertexts counts each category in its own slot.
+func EncodeOneHot(params *bfv.Parameters, encoder bfv.Encoder, v, buckets int64) (*bfv.Plaintext, error) {
+	if n := int64(1) << params.LogN; buckets < 1 || buckets > n {
+		return nil, fmt.Errorf("%d buckets, want 1 to %d", buckets, n)
+	}
+	if v < 0 || v >= buckets {
+		return nil, fmt.Errorf("category %d is not in [0, %d)", v, buckets)
+	}
+	slots := make([]int64, v+1)
+	slots[v] = 1
+	return EncodeSlots(params, encoder, slots), nil
+}
+
+// Sum returns the sum of xs slot by slot: the histogram of one-hot
+// ciphertexts, or the total of plain values.
+func Sum(ev bfv.Evaluator, xs []*bfv.Ciphertext) (*bfv.Ciphertext, error) {
+	if len(xs) == 0 {
+		return nil, errors.New("nothing to sum")
+	}
+	sum := xs[0].CopyNew().Ciphertext()
+	for _, x := range xs[1:] {
+		ev.Add(sum, x, sum)
+	}
+	return sum, nil
+}
+
+// MaskedSum returns sum_i masks[i]*values[i], where each mask is a one-hot
+// ciphertext and each value an fhe_encrypt one: value i lands in the slot of
+// its category, so slot k ends up with the total of category k. Each value
+// is first copied into every slot by adding up its slots with rotations,
+// which only works while the others are zero. The products are summed at
+// degree 2 and relinearized once; like Mul the inputs should be fresh.
+func MaskedSum(ev bfv.Evaluator, params *bfv.Parameters, keys *EvalKeys, masks, values []*bfv.Ciphertext) (*bfv.Ciphertext, error) {
+	if keys == nil || keys.Relin == nil || keys.Rotation == nil {
+		return nil, errors.New("masked sum needs relinearization and rotation keys")
+	}
+	if len(masks) != len(values) {
+		return nil, fmt.Errorf("%d masks but %d values", len(masks), len(values))
+	}
+	if len(masks) == 0 {
+		return nil, errors.New("nothing to sum")
+	}
+	var sum *bfv.Ciphertext
+	spread := bfv.NewCiphertext(params, 1)
+	for i := range masks {
+		if masks[i].Degree() != 1 || values[i].Degree() != 1 {
+			return nil, fmt.Errorf("row %d has degree %d and %d, relinearize it first", i, masks[i].Degree(), values[i].Degree())
+		}
+		ev.InnerSum(values[i], keys.Rotation, spread)
+		if i == 0 {
+			sum = ev.MulNew(masks[i], spread)
+			continue
+		}
+		ev.Add(sum, ev.MulNew(masks[i], spread), sum)
+	}
+	return ev.RelinearizeNew(sum, keys.Relin), nil
+}
+
+// DecodeHistogram returns the first buckets slots of pt, the counts or
+// totals per category, and ErrNoise unless the slots after them are zero as
+// they are for one-hot sums.
+func DecodeHistogram(encoder bfv.Encoder, pt *bfv.Plaintext, buckets int) ([]int64, error) {
+	slots := encoder.DecodeInt(pt)
+	if buckets < 1 || buckets > len(slots) {
+		return nil, fmt.Errorf("%d buckets, want 1 to %d", buckets, len(slots))
+	}
+	for _, v := range slots[buckets:] {
+		if v != 0 {
+			return nil, ErrNoise
+		}
+	}
+	return slots[:buckets], nil
+}
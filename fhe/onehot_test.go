@@ -0,0 +1,62 @@
+package fhe
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+func TestOneHot(t *testing.T) {
+	params := DefaultParams()
+	sk, pk := bfv.NewKeyGenerator(params).GenKeyPair()
+	keys := GenEvalKeys(params, sk)
+	encoder := bfv.NewEncoder(params)
+	encryptor := bfv.NewEncryptorFromPk(params, pk)
+	decryptor := bfv.NewDecryptor(params, sk)
+	ev := bfv.NewEvaluator(params)
+
+	var masks, values []*bfv.Ciphertext
+	for _, r := range []struct{ category, value int64 }{{0, 10}, {2, -4}, {2, 7}, {1, 100}, {2, 1}} {
+		pt, err := EncodeOneHot(params, encoder, r.category, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		masks = append(masks, encryptor.EncryptNew(pt))
+		values = append(values, encryptor.EncryptNew(EncodeValue(params, encoder, r.value)))
+	}
+
+	counts, err := Sum(ev, masks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	totals, err := MaskedSum(ev, params, keys, masks, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string]struct {
+		ct   *bfv.Ciphertext
+		want string
+	}{"counts": {counts, "[1 1 3]"}, "totals": {totals, "[10 100 4]"}} {
+		got, err := DecodeHistogram(encoder, decryptor.DecryptNew(c.ct), 3)
+		if err != nil || fmt.Sprint(got) != c.want {
+			t.Errorf("%s = %v, %v, want %s", name, got, err, c.want)
+		}
+	}
+	if _, err := DecodeHistogram(encoder, decryptor.DecryptNew(counts), 2); !errors.Is(err, ErrNoise) {
+		t.Errorf("DecodeHistogram with too few buckets = %v, want ErrNoise", err)
+	}
+
+	if _, err := MaskedSum(ev, params, &EvalKeys{Relin: keys.Relin}, masks, values); err == nil {
+		t.Errorf("MaskedSum without rotation keys succeeded")
+	}
+	if _, err := MaskedSum(ev, params, keys, masks, values[1:]); err == nil {
+		t.Errorf("MaskedSum of 5 masks and 4 values succeeded")
+	}
+	for _, c := range [][2]int64{{3, 3}, {-1, 3}, {0, 0}, {0, 1 << 13}} {
+		if _, err := EncodeOneHot(params, encoder, c[0], c[1]); err == nil {
+			t.Errorf("EncodeOneHot(%d, %d) succeeded", c[0], c[1])
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package fhe
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+// Op is a homomorphic operation served by one of the evaluator functions.
+type Op struct {
+	Args int
+	Eval func(ev bfv.Evaluator, keys *EvalKeys, args []*bfv.Ciphertext) (*bfv.Ciphertext, error)
+}
+
+// Ops maps the function modes (fhe_add is "add") to what they compute, for
+// offline tools that need the same results the services give.
+var Ops = map[string]Op{
+	"add": {Args: 2, Eval: func(ev bfv.Evaluator, _ *EvalKeys, a []*bfv.Ciphertext) (*bfv.Ciphertext, error) {
+		return Add(ev, a[0], a[1]), nil
+	}},
+	"sub": {Args: 2, Eval: func(ev bfv.Evaluator, _ *EvalKeys, a []*bfv.Ciphertext) (*bfv.Ciphertext, error) {
+		return Sub(ev, a[0], a[1]), nil
+	}},
+	"mul": {Args: 2, Eval: func(ev bfv.Evaluator, _ *EvalKeys, a []*bfv.Ciphertext) (*bfv.Ciphertext, error) {
+		return Mul(ev, a[0], a[1])
+	}},
+	"neg": {Args: 1, Eval: func(ev bfv.Evaluator, _ *EvalKeys, a []*bfv.Ciphertext) (*bfv.Ciphertext, error) {
+		return Neg(ev, a[0]), nil
+	}},
+	"distance2": {Args: 4, Eval: func(ev bfv.Evaluator, keys *EvalKeys, a []*bfv.Ciphertext) (*bfv.Ciphertext, error) {
+		return Distance2(ev, keys, a[0], a[1], a[2], a[3])
+	}},
+}
+
+// Add returns x + y.
+func Add(ev bfv.Evaluator, x, y *bfv.Ciphertext) *bfv.Ciphertext {
+	return ev.AddNew(x, y)
+}
+
+// Sub returns x - y.
+func Sub(ev bfv.Evaluator, x, y *bfv.Ciphertext) *bfv.Ciphertext {
+	return ev.SubNew(x, y)
+}
+
+// Neg returns -x.
+func Neg(ev bfv.Evaluator, x *bfv.Ciphertext) *bfv.Ciphertext {
+	return ev.NegNew(x)
+}
+
+// Mul returns x * y without relinearizing, so the result has the sum of
+// the input degrees. That may not exceed MaxCiphertextDegree.
+func Mul(ev bfv.Evaluator, x, y *bfv.Ciphertext) (*bfv.Ciphertext, error) {
+	if err := checkProduct(x, y); err != nil {
+		return nil, err
+	}
+	return ev.MulNew(x, y), nil
+}
+
+func checkProduct(x, y *bfv.Ciphertext) error {
+	if x.Degree()+y.Degree() > MaxCiphertextDegree {
+		return fmt.Errorf("product degree %d is too large, decrypt or refresh the inputs first", x.Degree()+y.Degree())
+	}
+	return nil
+}
+
+// Distance2 returns (x1-x2)^2 + (y1-y2)^2, the squared Euclidean distance
+// between two points, relinearized back to degree 1. It consumes one level
+// of MulDepth like Mul.
+func Distance2(ev bfv.Evaluator, keys *EvalKeys, x1, y1, x2, y2 *bfv.Ciphertext) (*bfv.Ciphertext, error) {
+	if keys == nil || keys.Relin == nil {
+		return nil, errors.New("distance2 needs a relinearization key")
+	}
+	dx := ev.SubNew(x1, x2)
+	dy := ev.SubNew(y1, y2)
+	d := dx.Degree()
+	if dy.Degree() > d {
+		d = dy.Degree()
+	}
+	if 2*d > uint64(len(keys.Relin.Get()))+1 {
+		return nil, fmt.Errorf("squares of degree %d can't be relinearized, decrypt or refresh the inputs first", 2*d)
+	}
+	sq := ev.MulNew(dx, dx)
+	ev.Add(sq, ev.MulNew(dy, dy), sq)
+	return ev.RelinearizeNew(sq, keys.Relin), nil
+}
+
+// Pack moves the value in the first slot of cts[i] to slot i of one
+// ciphertext. The other slots of every input must be zero, as they are for
+// anything fhe_encrypt and the other ops produce.
+func Pack(ev bfv.Evaluator, params *bfv.Parameters, keys *EvalKeys, cts []*bfv.Ciphertext) (*bfv.Ciphertext, error) {
+	if keys == nil || keys.Rotation == nil {
+		return nil, errors.New("packing needs rotation keys")
+	}
+	if len(cts) == 0 {
+		return nil, errors.New("nothing to pack")
+	}
+	// a row of slots rotates as one; the second row starts at slots/2
+	row := uint64(1) << (params.LogN - 1)
+	if uint64(len(cts)) > row {
+		return nil, fmt.Errorf("%d values don't fit in one row of %d slots", len(cts), row)
+	}
+	packed := bfv.NewCiphertext(params, 1)
+	tmp := bfv.NewCiphertext(params, 1)
+	for i, ct := range cts {
+		if ct.Degree() != 1 {
+			return nil, fmt.Errorf("value %d has degree %d, relinearize it first", i, ct.Degree())
+		}
+		// rotating left by row-i is rotating right by i
+		ev.RotateColumns(ct, row-uint64(i), keys.Rotation, tmp)
+		ev.Add(packed, tmp, packed)
+	}
+	return packed, nil
+}
+
+// Nearest returns the squared distances from (x, y) to each candidate
+// (xs[i], ys[i]) packed into one ciphertext, distance i in slot i, so one
+// decryption ranks them all.
+func Nearest(ev bfv.Evaluator, params *bfv.Parameters, keys *EvalKeys, x, y *bfv.Ciphertext, xs, ys []*bfv.Ciphertext) (*bfv.Ciphertext, error) {
+	if len(xs) != len(ys) {
+		return nil, fmt.Errorf("%d x coordinates but %d y coordinates", len(xs), len(ys))
+	}
+	dists := make([]*bfv.Ciphertext, len(xs))
+	for i := range xs {
+		var err error
+		if dists[i], err = Distance2(ev, keys, x, y, xs[i], ys[i]); err != nil {
+			return nil, fmt.Errorf("candidate %d: %v", i, err)
+		}
+	}
+	return Pack(ev, params, keys, dists)
+}
+
+// Dot returns sum_i w[i]*x[i] for the values x packs one per slot, with the
+// weights in the clear. It multiplies by the weights as a plaintext and adds
+// the slots up with rotations, which leaves the sum in every slot. The
+// plaintext product costs as much noise as Mul, so x should be fresh.
+func Dot(ev bfv.Evaluator, params *bfv.Parameters, encoder bfv.Encoder, keys *EvalKeys, x *bfv.Ciphertext, w []int64) (*bfv.Ciphertext, error) {
+	if keys == nil || keys.Rotation == nil {
+		return nil, errors.New("dot needs rotation keys")
+	}
+	if n := 1 << params.LogN; len(w) > n {
+		return nil, fmt.Errorf("%d weights for %d slots", len(w), n)
+	}
+	if x.Degree() != 1 {
+		return nil, fmt.Errorf("vector has degree %d, relinearize it first", x.Degree())
+	}
+	prod := ev.MulNew(x, EncodeSlots(params, encoder, w))
+	sum := bfv.NewCiphertext(params, 1)
+	ev.InnerSum(prod, keys.Rotation, sum)
+	return sum, nil
+}
+
+// WeightedSum returns sum_i w[i]*xs[i] with the weights in the clear. Each
+// weight is centered modulo T and multiplies its ciphertext as a scalar,
+// so noise grows with the largest |w[i]|: weights near T/2 use up the
+// budget like Mul, small ones leave room for an earlier multiplication.
+func WeightedSum(ev bfv.Evaluator, params *bfv.Parameters, xs []*bfv.Ciphertext, w []int64) (*bfv.Ciphertext, error) {
+	if len(xs) != len(w) {
+		return nil, fmt.Errorf("%d values but %d weights", len(xs), len(w))
+	}
+	if len(xs) == 0 {
+		return nil, errors.New("nothing to sum")
+	}
+	t := int64(params.T)
+	var sum *bfv.Ciphertext
+	for i, x := range xs {
+		c := w[i] % t
+		if c > t/2 {
+			c -= t
+		} else if c < -t/2 {
+			c += t
+		}
+		abs := c
+		if abs < 0 {
+			abs = -abs
+		}
+		term := ev.MulScalarNew(x, uint64(abs))
+		switch {
+		case sum == nil && c < 0:
+			sum = ev.NegNew(term)
+		case sum == nil:
+			sum = term
+		case c < 0:
+			sum = ev.SubNew(sum, term)
+		default:
+			sum = ev.AddNew(sum, term)
+		}
+	}
+	return sum, nil
+}
@@ -0,0 +1,115 @@
+package fhe
+
+import (
+	"testing"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+func TestDistance(t *testing.T) {
+	params := DefaultParams()
+	sk, pk := bfv.NewKeyGenerator(params).GenKeyPair()
+	keys := GenEvalKeys(params, sk)
+	encoder := bfv.NewEncoder(params)
+	encryptor := bfv.NewEncryptorFromPk(params, pk)
+	decryptor := bfv.NewDecryptor(params, sk)
+	ev := bfv.NewEvaluator(params)
+	enc := func(v int64) *bfv.Ciphertext {
+		return encryptor.EncryptNew(EncodeValue(params, encoder, v))
+	}
+
+	d, err := Distance2(ev, keys, enc(1), enc(-2), enc(4), enc(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Degree() != 1 {
+		t.Errorf("Distance2 has degree %d, want 1", d.Degree())
+	}
+	if got, err := DecodeValueChecked(encoder, decryptor.DecryptNew(d)); err != nil || got != 25 {
+		t.Errorf("Distance2((1,-2), (4,2)) = %d, %v, want 25", got, err)
+	}
+	if _, err := Distance2(ev, &EvalKeys{}, enc(1), enc(1), enc(1), enc(1)); err == nil {
+		t.Errorf("Distance2 without a relinearization key succeeded")
+	}
+	sq := ev.MulNew(enc(1), enc(1))
+	if _, err := Distance2(ev, keys, sq, enc(1), enc(1), enc(1)); err == nil {
+		t.Errorf("Distance2 of a degree 2 input succeeded")
+	}
+
+	var xs, ys []*bfv.Ciphertext
+	var want []int64
+	for _, p := range [][2]int64{{0, 0}, {3, 4}, {-1, 10}, {7, -2}, {2, 3}} {
+		xs = append(xs, enc(p[0]))
+		ys = append(ys, enc(p[1]))
+		want = append(want, (p[0]-2)*(p[0]-2)+(p[1]-3)*(p[1]-3))
+	}
+	packed, err := Nearest(ev, params, keys, enc(2), enc(3), xs, ys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slots := encoder.DecodeInt(decryptor.DecryptNew(packed))
+	for i, w := range want {
+		if slots[i] != w {
+			t.Errorf("slot %d = %d, want %d", i, slots[i], w)
+		}
+	}
+	for i := len(want); i < len(slots); i++ {
+		if slots[i] != 0 {
+			t.Fatalf("slot %d = %d, want 0", i, slots[i])
+		}
+	}
+	if _, err := Nearest(ev, params, keys, enc(2), enc(3), xs, ys[1:]); err == nil {
+		t.Errorf("Nearest with mismatched coordinates succeeded")
+	}
+}
+
+func TestDot(t *testing.T) {
+	params := DefaultParams()
+	sk, pk := bfv.NewKeyGenerator(params).GenKeyPair()
+	keys := GenEvalKeys(params, sk)
+	encoder := bfv.NewEncoder(params)
+	encryptor := bfv.NewEncryptorFromPk(params, pk)
+	decryptor := bfv.NewDecryptor(params, sk)
+	ev := bfv.NewEvaluator(params)
+	T := int64(params.T)
+
+	x := []int64{3, -1, 7, 0, 12}
+	w := []int64{2, 5, -1, 9, T + 1}
+	var want int64
+	for i := range x {
+		want += x[i] * (w[i] % T)
+	}
+	d, err := Dot(ev, params, encoder, keys, encryptor.EncryptNew(EncodeSlots(params, encoder, x)), w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := DecodeValueChecked(encoder, decryptor.DecryptNew(d)); err != nil || got != want {
+		t.Errorf("Dot = %d, %v, want %d", got, err, want)
+	}
+	if _, err := Dot(ev, params, encoder, &EvalKeys{}, d, w); err == nil {
+		t.Errorf("Dot without rotation keys succeeded")
+	}
+
+	var xs []*bfv.Ciphertext
+	for _, v := range x {
+		xs = append(xs, encryptor.EncryptNew(EncodeValue(params, encoder, v)))
+	}
+	s, err := WeightedSum(ev, params, xs, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := DecodeValueChecked(encoder, decryptor.DecryptNew(s)); err != nil || got != want {
+		t.Errorf("WeightedSum = %d, %v, want %d", got, err, want)
+	}
+	// the first weight negative takes another branch
+	s, err = WeightedSum(ev, params, xs[:2], []int64{-4, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := DecodeValueChecked(encoder, decryptor.DecryptNew(s)); err != nil || got != -15 {
+		t.Errorf("WeightedSum(-4, 3) = %d, %v, want -15", got, err)
+	}
+	if _, err := WeightedSum(ev, params, xs, w[1:]); err == nil {
+		t.Errorf("WeightedSum with mismatched weights succeeded")
+	}
+}
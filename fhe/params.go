@@ -0,0 +1,17 @@
+package fhe
+
+import "github.com/ldsec/lattigo/bfv"
+
+// DefaultParams returns the BFV parameters (128 bit security) the functions
+// and the app encrypt under.
+func DefaultParams() *bfv.Parameters {
+	return bfv.DefaultParams[bfv.PN12QP109]
+}
+
+// MulDepth is how many fhe_mul calls can be chained on a path of an
+// expression before the noise in a DefaultParams ciphertext overwhelms the
+// plaintext. Additions and negations barely add noise.
+const MulDepth = 1
+
+// DefaultParamsName is the lattigo name of DefaultParams.
+const DefaultParamsName = "PN12QP109"
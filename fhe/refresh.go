@@ -0,0 +1,26 @@
+package fhe
+
+import "github.com/ldsec/lattigo/bfv"
+
+// Refresh decrypts ct and encrypts its slots again with encryptor, a public
+// key encryptor for the same key (lattigo's secret key encryptor turns out
+// noise after its first use). There is no bootstrapping in lattigo's
+// BFV, so this is how a long pipeline gets past MulDepth: the result has
+// degree 1 and the noise of a fresh encryption whatever ct went through, as
+// long as ct still decrypts. The slots are decoded and encoded again rather
+// than the decryption re-encrypted as it is, which would carry ct's noise
+// over. They never leave this function. ct must hold one value as
+// DecodeValueChecked reads it: anything else, eg a ciphertext that no longer
+// decrypts, returns ErrNoise rather than a fresh encryption of garbage.
+// decryptor and encryptor keep scratch space, so each caller needs its own,
+// eg those of its Workspace.
+func Refresh(params *bfv.Parameters, encoder bfv.Encoder, decryptor bfv.Decryptor, encryptor bfv.Encryptor, ct *bfv.Ciphertext) (*bfv.Ciphertext, error) {
+	pt := bfv.NewPlaintext(params)
+	decryptor.Decrypt(ct, pt)
+	if _, err := DecodeValueChecked(encoder, pt); err != nil {
+		return nil, err
+	}
+	fresh := bfv.NewPlaintext(params)
+	encoder.EncodeUint(encoder.DecodeUint(pt), fresh)
+	return encryptor.EncryptNew(fresh), nil
+}
@@ -0,0 +1,44 @@
+package fhe
+
+import (
+	"testing"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+func TestRefresh(t *testing.T) {
+	params := DefaultParams()
+	kg := bfv.NewKeyGenerator(params)
+	sk, pk := kg.GenKeyPair()
+	encoder := bfv.NewEncoder(params)
+	decryptor := bfv.NewDecryptor(params, sk)
+	// a public key made from sk, as the decrypt service does
+	encryptor := bfv.NewEncryptorFromPk(params, kg.GenPublicKey(sk))
+	ev := bfv.NewEvaluator(params)
+	x := bfv.NewEncryptorFromPk(params, pk).EncryptNew(EncodeValue(params, encoder, -3))
+
+	// x^8 is three multiplications, two past MulDepth
+	y := x
+	for i := 0; i < 3; i++ {
+		sq, err := Mul(ev, y, y)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if y, err = Refresh(params, encoder, decryptor, encryptor, sq); err != nil {
+			t.Fatal(err)
+		}
+		if y.Degree() != 1 {
+			t.Fatalf("refreshed ciphertext has degree %d", y.Degree())
+		}
+	}
+	if got, err := DecodeValueChecked(encoder, decryptor.DecryptNew(y)); err != nil || got != 6561 {
+		t.Errorf("x^8 = %d, %v, want 6561", got, err)
+	}
+
+	// a ciphertext under another key decrypts to noise, which stays refused
+	_, other := kg.GenKeyPair()
+	y = bfv.NewEncryptorFromPk(params, other).EncryptNew(EncodeValue(params, encoder, -3))
+	if _, err := Refresh(params, encoder, decryptor, encryptor, y); err != ErrNoise {
+		t.Errorf("Refresh() of noise = %v, want ErrNoise", err)
+	}
+}
@@ -0,0 +1,175 @@
+package fhe
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"example.com/fhe/keyring"
+	"github.com/ldsec/lattigo/bfv"
+)
+
+// RerandomizeEnv makes the evaluators re-randomize every result they return
+// when set to true. They need FHE_PUBLIC_KEY_URL, and FHE_EQ_PUBLIC_KEY_URL
+// for fhe_eq results, to do it.
+const RerandomizeEnv = "FHE_RERANDOMIZE"
+
+// Rerandomize adds a fresh encryption of zero to ct. The result decrypts to
+// the same value, but without the secret key it looks as random as any
+// other ciphertext, so it can't be matched to the inputs that made it by
+// recomputing the evaluation. The noise it carries still depends on the
+// computation. encryptor keeps scratch space, so each caller needs its
+// own, eg that of its Workspace.
+func Rerandomize(ev bfv.Evaluator, params *bfv.Parameters, encryptor bfv.Encryptor, ct *bfv.Ciphertext) {
+	ev.Add(ct, encryptor.EncryptNew(bfv.NewPlaintext(params)), ct)
+}
+
+// Rerandomizer re-randomizes the evaluators' results with the public keys
+// the environment points at. They are held in a keyring.Ring, so a reload
+// fetches them without holding up the rows in flight, and a batch pinned
+// with Pin finishes with the keys it started with.
+type Rerandomizer struct {
+	getenv func(string) string
+	ring   *keyring.Ring[rerandKeys]
+}
+
+// rerandKeys is one generation of the public keys: FHE_PUBLIC_KEY_URL's,
+// fetched with the generation, and FHE_EQ_PUBLIC_KEY_URL's, fetched on first
+// use.
+type rerandKeys struct {
+	getenv func(string) string
+	def    *bfv.PublicKey
+
+	eqMu sync.Mutex // the first fetch of eq
+	eq   atomic.Pointer[bfv.PublicKey]
+}
+
+// NewRerandomizerFromEnv returns a Rerandomizer if FHE_RERANDOMIZE is true
+// and nil (results returned as computed) if it is unset or false.
+func NewRerandomizerFromEnv() (*Rerandomizer, error) {
+	v := os.Getenv(RerandomizeEnv)
+	if v == "" {
+		return nil, nil
+	}
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("fhe: invalid %s: %v", RerandomizeEnv, err)
+	}
+	if !on {
+		return nil, nil
+	}
+	r := &Rerandomizer{getenv: os.Getenv}
+	r.ring = keyring.New(func(ctx context.Context) (*rerandKeys, error) {
+		// r.getenv as it is when the fhe_eq key is fetched, for tests
+		return loadRerandKeys(ctx, func(env string) string { return r.getenv(env) })
+	})
+	return r, nil
+}
+
+// loadRerandKeys fetches a generation of the keys.
+func loadRerandKeys(ctx context.Context, getenv func(string) string) (*rerandKeys, error) {
+	def, err := fetchRerandKey(ctx, DefaultParams(), getenv, PublicKeyURLEnv)
+	if err != nil {
+		return nil, err
+	}
+	return &rerandKeys{getenv: getenv, def: def}, nil
+}
+
+func fetchRerandKey(ctx context.Context, params *bfv.Parameters, getenv func(string) string, env string) (*bfv.PublicKey, error) {
+	loc := getenv(env)
+	if loc == "" {
+		return nil, fmt.Errorf("%s is set but %s is not", RerandomizeEnv, env)
+	}
+	b, err := ReadKey(ctx, loc)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := UnmarshalPublicKey(params, b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", env, err)
+	}
+	return pk, nil
+}
+
+// key returns the key for params, fetching the fhe_eq one the first time.
+func (g *rerandKeys) key(ctx context.Context, params *bfv.Parameters) (*bfv.PublicKey, error) {
+	if params.LogN != EqParams().LogN {
+		return g.def, nil
+	}
+	if k := g.eq.Load(); k != nil {
+		return k, nil
+	}
+	g.eqMu.Lock()
+	defer g.eqMu.Unlock()
+	if k := g.eq.Load(); k != nil {
+		return k, nil
+	}
+	k, err := fetchRerandKey(ctx, EqParams(), g.getenv, EqPublicKeyURLEnv)
+	if err != nil {
+		return nil, err
+	}
+	g.eq.Store(k)
+	return k, nil
+}
+
+// Pin returns ctx holding the current keys, fetching them if need be, for
+// every Rerandomize of a batch. A nil Rerandomizer returns ctx.
+func (r *Rerandomizer) Pin(ctx context.Context) (context.Context, error) {
+	if r == nil {
+		return ctx, nil
+	}
+	return r.ring.Pin(ctx)
+}
+
+// Rerandomize re-randomizes ct in place, a DefaultParams or an EqParams
+// ciphertext, with the keys ctx was pinned to or else the current ones. A
+// nil Rerandomizer leaves it as it is.
+func (r *Rerandomizer) Rerandomize(ctx context.Context, ct *bfv.Ciphertext) error {
+	if r == nil {
+		return nil
+	}
+	params := DefaultParams()
+	if n := uint64(len(ct.Value()[0].Coeffs[0])); n == 1<<EqParams().LogN {
+		params = EqParams()
+	}
+	g, err := r.ring.From(ctx)
+	if err != nil {
+		return err
+	}
+	pk, err := g.key(ctx, params)
+	if err != nil {
+		return err
+	}
+
+	w := GetWorkspace(params)
+	defer w.Put()
+	zero := w.Ciphertext(1)
+	w.Encryptor(pk).Encrypt(w.EncodeValue(0), zero)
+	w.Evaluator.Add(ct, zero, ct)
+	return nil
+}
+
+// Load fetches the FHE_PUBLIC_KEY_URL key ahead of the first result, for a
+// service starting up. The fhe_eq key is still fetched on first use. A nil
+// Rerandomizer has nothing to load.
+func (r *Rerandomizer) Load() error {
+	if r == nil {
+		return nil
+	}
+	_, err := r.ring.Get(context.Background())
+	return err
+}
+
+// Reload fetches the public keys again after a rotation: the
+// FHE_PUBLIC_KEY_URL one now and the fhe_eq one on its next use. Batches
+// already pinned keep the keys they have, and the current keys stay if the
+// fetch fails.
+func (r *Rerandomizer) Reload() error {
+	if r == nil {
+		return nil
+	}
+	return r.ring.Reload()
+}
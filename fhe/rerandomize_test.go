@@ -0,0 +1,120 @@
+package fhe
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+func TestRerandomize(t *testing.T) {
+	params := DefaultParams()
+	sk, pk := bfv.NewKeyGenerator(params).GenKeyPair()
+	pub, err := pk.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "pub.bin")
+	if err := os.WriteFile(path, pub, 0644); err != nil {
+		t.Fatal(err)
+	}
+	encoder := bfv.NewEncoder(params)
+	decryptor := bfv.NewDecryptor(params, sk)
+	ev := bfv.NewEvaluator(params)
+	x := bfv.NewEncryptorFromPk(params, pk).EncryptNew(EncodeValue(params, encoder, -12))
+	sq := ev.MulNew(x, x)
+
+	ctx := context.Background()
+	t.Setenv(RerandomizeEnv, "true")
+	r, err := NewRerandomizerFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.getenv = func(string) string { return path }
+	if err := r.Load(); err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	for _, c := range []struct {
+		ct   *bfv.Ciphertext
+		want int64
+	}{{x, -12}, {sq, 144}} {
+		before := mustMarshal(t, c.ct)
+		if err := r.Rerandomize(ctx, c.ct); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(before, mustMarshal(t, c.ct)) {
+			t.Errorf("degree %d ciphertext is unchanged", c.ct.Degree())
+		}
+		if got, err := DecodeValueChecked(encoder, decryptor.DecryptNew(c.ct)); err != nil || got != c.want {
+			t.Errorf("re-randomized %d decrypts to %d, %v", c.want, got, err)
+		}
+	}
+
+	r.getenv = func(string) string { return "" }
+	if err := r.Rerandomize(ctx, bfv.NewCiphertext(EqParams(), 1)); err == nil {
+		t.Errorf("Rerandomize without FHE_EQ_PUBLIC_KEY_URL succeeded")
+	}
+	// a reload that can't fetch the key keeps the one it has
+	if err := r.Reload(); err == nil {
+		t.Errorf("Reload without FHE_PUBLIC_KEY_URL succeeded")
+	}
+	if err := r.Rerandomize(ctx, x); err != nil {
+		t.Errorf("Rerandomize after a failed Reload: %v", err)
+	}
+
+	// a batch pinned before a rotation keeps re-randomizing under the key
+	// it started with
+	pinned, err := r.Pin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pk2 := bfv.NewKeyGenerator(params).GenKeyPair()
+	pub2, err := pk2.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path2 := filepath.Join(t.TempDir(), "pub2.bin")
+	if err := os.WriteFile(path2, pub2, 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.getenv = func(string) string { return path2 }
+	if err := r.Reload(); err != nil {
+		t.Errorf("Reload() = %v", err)
+	}
+	y := x.CopyNew().Ciphertext()
+	if err := r.Rerandomize(pinned, y); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := DecodeValueChecked(encoder, decryptor.DecryptNew(y)); err != nil || got != -12 {
+		t.Errorf("pinned batch after a reload decrypts to %d, %v", got, err)
+	}
+	if err := r.Rerandomize(ctx, y); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := DecodeValueChecked(encoder, decryptor.DecryptNew(y)); err == nil && got == -12 {
+		t.Errorf("unpinned batch after a reload still used the old key")
+	}
+	if err := (*Rerandomizer)(nil).Rerandomize(ctx, x); err != nil {
+		t.Errorf("nil Rerandomizer: %v", err)
+	}
+	if err := (*Rerandomizer)(nil).Load(); err != nil {
+		t.Errorf("nil Rerandomizer Load: %v", err)
+	}
+	if err := (*Rerandomizer)(nil).Reload(); err != nil {
+		t.Errorf("nil Rerandomizer Reload: %v", err)
+	}
+
+	for v, on := range map[string]bool{"": false, "false": false, "1": true} {
+		t.Setenv(RerandomizeEnv, v)
+		if r, err := NewRerandomizerFromEnv(); err != nil || (r != nil) != on {
+			t.Errorf("%s=%q: got %v, %v", RerandomizeEnv, v, r, err)
+		}
+	}
+	t.Setenv(RerandomizeEnv, "yes please")
+	if _, err := NewRerandomizerFromEnv(); err == nil {
+		t.Errorf("%s=\"yes please\" was accepted", RerandomizeEnv)
+	}
+}
@@ -0,0 +1,508 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: fhepb/fhe.proto
+
+package fhepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// FHEClient is the client API for FHE service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FHEClient interface {
+	// Encrypt encrypts each value.
+	Encrypt(ctx context.Context, in *EncryptRequest, opts ...grpc.CallOption) (*Ciphertexts, error)
+	// Decrypt decrypts each ciphertext.
+	Decrypt(ctx context.Context, in *DecryptRequest, opts ...grpc.CallOption) (*DecryptReply, error)
+	// Evaluate runs a mode such as add, mul or lut on each row.
+	Evaluate(ctx context.Context, in *EvaluateRequest, opts ...grpc.CallOption) (*Ciphertexts, error)
+	// Aggregate combines ciphertexts into one.
+	Aggregate(ctx context.Context, in *AggregateRequest, opts ...grpc.CallOption) (*Ciphertext, error)
+	// The streaming RPCs answer each request on the stream in turn as the
+	// unary ones would, so a client keeps sending batches over one call. A
+	// failed batch ends the stream.
+	EncryptStream(ctx context.Context, opts ...grpc.CallOption) (FHE_EncryptStreamClient, error)
+	DecryptStream(ctx context.Context, opts ...grpc.CallOption) (FHE_DecryptStreamClient, error)
+	EvaluateStream(ctx context.Context, opts ...grpc.CallOption) (FHE_EvaluateStreamClient, error)
+	// AggregateStream combines the ciphertexts of every request sent before
+	// the client closes the stream, which can be more than fit in one
+	// message: up to FHE_MAX_STREAM_BYTES, 256MiB by default, in all.
+	AggregateStream(ctx context.Context, opts ...grpc.CallOption) (FHE_AggregateStreamClient, error)
+}
+
+type fHEClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFHEClient(cc grpc.ClientConnInterface) FHEClient {
+	return &fHEClient{cc}
+}
+
+func (c *fHEClient) Encrypt(ctx context.Context, in *EncryptRequest, opts ...grpc.CallOption) (*Ciphertexts, error) {
+	out := new(Ciphertexts)
+	err := c.cc.Invoke(ctx, "/fhe.v1.FHE/Encrypt", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fHEClient) Decrypt(ctx context.Context, in *DecryptRequest, opts ...grpc.CallOption) (*DecryptReply, error) {
+	out := new(DecryptReply)
+	err := c.cc.Invoke(ctx, "/fhe.v1.FHE/Decrypt", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fHEClient) Evaluate(ctx context.Context, in *EvaluateRequest, opts ...grpc.CallOption) (*Ciphertexts, error) {
+	out := new(Ciphertexts)
+	err := c.cc.Invoke(ctx, "/fhe.v1.FHE/Evaluate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fHEClient) Aggregate(ctx context.Context, in *AggregateRequest, opts ...grpc.CallOption) (*Ciphertext, error) {
+	out := new(Ciphertext)
+	err := c.cc.Invoke(ctx, "/fhe.v1.FHE/Aggregate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fHEClient) EncryptStream(ctx context.Context, opts ...grpc.CallOption) (FHE_EncryptStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FHE_ServiceDesc.Streams[0], "/fhe.v1.FHE/EncryptStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fHEEncryptStreamClient{stream}
+	return x, nil
+}
+
+type FHE_EncryptStreamClient interface {
+	Send(*EncryptRequest) error
+	Recv() (*Ciphertexts, error)
+	grpc.ClientStream
+}
+
+type fHEEncryptStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *fHEEncryptStreamClient) Send(m *EncryptRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *fHEEncryptStreamClient) Recv() (*Ciphertexts, error) {
+	m := new(Ciphertexts)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fHEClient) DecryptStream(ctx context.Context, opts ...grpc.CallOption) (FHE_DecryptStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FHE_ServiceDesc.Streams[1], "/fhe.v1.FHE/DecryptStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fHEDecryptStreamClient{stream}
+	return x, nil
+}
+
+type FHE_DecryptStreamClient interface {
+	Send(*DecryptRequest) error
+	Recv() (*DecryptReply, error)
+	grpc.ClientStream
+}
+
+type fHEDecryptStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *fHEDecryptStreamClient) Send(m *DecryptRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *fHEDecryptStreamClient) Recv() (*DecryptReply, error) {
+	m := new(DecryptReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fHEClient) EvaluateStream(ctx context.Context, opts ...grpc.CallOption) (FHE_EvaluateStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FHE_ServiceDesc.Streams[2], "/fhe.v1.FHE/EvaluateStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fHEEvaluateStreamClient{stream}
+	return x, nil
+}
+
+type FHE_EvaluateStreamClient interface {
+	Send(*EvaluateRequest) error
+	Recv() (*Ciphertexts, error)
+	grpc.ClientStream
+}
+
+type fHEEvaluateStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *fHEEvaluateStreamClient) Send(m *EvaluateRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *fHEEvaluateStreamClient) Recv() (*Ciphertexts, error) {
+	m := new(Ciphertexts)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fHEClient) AggregateStream(ctx context.Context, opts ...grpc.CallOption) (FHE_AggregateStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FHE_ServiceDesc.Streams[3], "/fhe.v1.FHE/AggregateStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fHEAggregateStreamClient{stream}
+	return x, nil
+}
+
+type FHE_AggregateStreamClient interface {
+	Send(*AggregateRequest) error
+	CloseAndRecv() (*Ciphertext, error)
+	grpc.ClientStream
+}
+
+type fHEAggregateStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *fHEAggregateStreamClient) Send(m *AggregateRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *fHEAggregateStreamClient) CloseAndRecv() (*Ciphertext, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(Ciphertext)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// FHEServer is the server API for FHE service.
+// All implementations must embed UnimplementedFHEServer
+// for forward compatibility
+type FHEServer interface {
+	// Encrypt encrypts each value.
+	Encrypt(context.Context, *EncryptRequest) (*Ciphertexts, error)
+	// Decrypt decrypts each ciphertext.
+	Decrypt(context.Context, *DecryptRequest) (*DecryptReply, error)
+	// Evaluate runs a mode such as add, mul or lut on each row.
+	Evaluate(context.Context, *EvaluateRequest) (*Ciphertexts, error)
+	// Aggregate combines ciphertexts into one.
+	Aggregate(context.Context, *AggregateRequest) (*Ciphertext, error)
+	// The streaming RPCs answer each request on the stream in turn as the
+	// unary ones would, so a client keeps sending batches over one call. A
+	// failed batch ends the stream.
+	EncryptStream(FHE_EncryptStreamServer) error
+	DecryptStream(FHE_DecryptStreamServer) error
+	EvaluateStream(FHE_EvaluateStreamServer) error
+	// AggregateStream combines the ciphertexts of every request sent before
+	// the client closes the stream, which can be more than fit in one
+	// message: up to FHE_MAX_STREAM_BYTES, 256MiB by default, in all.
+	AggregateStream(FHE_AggregateStreamServer) error
+	mustEmbedUnimplementedFHEServer()
+}
+
+// UnimplementedFHEServer must be embedded to have forward compatible implementations.
+type UnimplementedFHEServer struct {
+}
+
+func (UnimplementedFHEServer) Encrypt(context.Context, *EncryptRequest) (*Ciphertexts, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Encrypt not implemented")
+}
+func (UnimplementedFHEServer) Decrypt(context.Context, *DecryptRequest) (*DecryptReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Decrypt not implemented")
+}
+func (UnimplementedFHEServer) Evaluate(context.Context, *EvaluateRequest) (*Ciphertexts, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Evaluate not implemented")
+}
+func (UnimplementedFHEServer) Aggregate(context.Context, *AggregateRequest) (*Ciphertext, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Aggregate not implemented")
+}
+func (UnimplementedFHEServer) EncryptStream(FHE_EncryptStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method EncryptStream not implemented")
+}
+func (UnimplementedFHEServer) DecryptStream(FHE_DecryptStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method DecryptStream not implemented")
+}
+func (UnimplementedFHEServer) EvaluateStream(FHE_EvaluateStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method EvaluateStream not implemented")
+}
+func (UnimplementedFHEServer) AggregateStream(FHE_AggregateStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method AggregateStream not implemented")
+}
+func (UnimplementedFHEServer) mustEmbedUnimplementedFHEServer() {}
+
+// UnsafeFHEServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FHEServer will
+// result in compilation errors.
+type UnsafeFHEServer interface {
+	mustEmbedUnimplementedFHEServer()
+}
+
+func RegisterFHEServer(s grpc.ServiceRegistrar, srv FHEServer) {
+	s.RegisterService(&FHE_ServiceDesc, srv)
+}
+
+func _FHE_Encrypt_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EncryptRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FHEServer).Encrypt(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fhe.v1.FHE/Encrypt",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FHEServer).Encrypt(ctx, req.(*EncryptRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FHE_Decrypt_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DecryptRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FHEServer).Decrypt(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fhe.v1.FHE/Decrypt",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FHEServer).Decrypt(ctx, req.(*DecryptRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FHE_Evaluate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EvaluateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FHEServer).Evaluate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fhe.v1.FHE/Evaluate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FHEServer).Evaluate(ctx, req.(*EvaluateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FHE_Aggregate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AggregateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FHEServer).Aggregate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fhe.v1.FHE/Aggregate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FHEServer).Aggregate(ctx, req.(*AggregateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FHE_EncryptStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FHEServer).EncryptStream(&fHEEncryptStreamServer{stream})
+}
+
+type FHE_EncryptStreamServer interface {
+	Send(*Ciphertexts) error
+	Recv() (*EncryptRequest, error)
+	grpc.ServerStream
+}
+
+type fHEEncryptStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *fHEEncryptStreamServer) Send(m *Ciphertexts) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *fHEEncryptStreamServer) Recv() (*EncryptRequest, error) {
+	m := new(EncryptRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _FHE_DecryptStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FHEServer).DecryptStream(&fHEDecryptStreamServer{stream})
+}
+
+type FHE_DecryptStreamServer interface {
+	Send(*DecryptReply) error
+	Recv() (*DecryptRequest, error)
+	grpc.ServerStream
+}
+
+type fHEDecryptStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *fHEDecryptStreamServer) Send(m *DecryptReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *fHEDecryptStreamServer) Recv() (*DecryptRequest, error) {
+	m := new(DecryptRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _FHE_EvaluateStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FHEServer).EvaluateStream(&fHEEvaluateStreamServer{stream})
+}
+
+type FHE_EvaluateStreamServer interface {
+	Send(*Ciphertexts) error
+	Recv() (*EvaluateRequest, error)
+	grpc.ServerStream
+}
+
+type fHEEvaluateStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *fHEEvaluateStreamServer) Send(m *Ciphertexts) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *fHEEvaluateStreamServer) Recv() (*EvaluateRequest, error) {
+	m := new(EvaluateRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _FHE_AggregateStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FHEServer).AggregateStream(&fHEAggregateStreamServer{stream})
+}
+
+type FHE_AggregateStreamServer interface {
+	SendAndClose(*Ciphertext) error
+	Recv() (*AggregateRequest, error)
+	grpc.ServerStream
+}
+
+type fHEAggregateStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *fHEAggregateStreamServer) SendAndClose(m *Ciphertext) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *fHEAggregateStreamServer) Recv() (*AggregateRequest, error) {
+	m := new(AggregateRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// FHE_ServiceDesc is the grpc.ServiceDesc for FHE service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var FHE_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "fhe.v1.FHE",
+	HandlerType: (*FHEServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Encrypt",
+			Handler:    _FHE_Encrypt_Handler,
+		},
+		{
+			MethodName: "Decrypt",
+			Handler:    _FHE_Decrypt_Handler,
+		},
+		{
+			MethodName: "Evaluate",
+			Handler:    _FHE_Evaluate_Handler,
+		},
+		{
+			MethodName: "Aggregate",
+			Handler:    _FHE_Aggregate_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "EncryptStream",
+			Handler:       _FHE_EncryptStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "DecryptStream",
+			Handler:       _FHE_DecryptStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "EvaluateStream",
+			Handler:       _FHE_EvaluateStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "AggregateStream",
+			Handler:       _FHE_AggregateStream_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "fhepb/fhe.proto",
+}
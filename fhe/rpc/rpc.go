@@ -0,0 +1,369 @@
+// Package rpc serves the gRPC API in fhepb/fhe.proto. Each request is run
+// as a batch of the service's BigQuery modes, by the bq.Modes the service
+// answers BigQuery with, so both APIs share the keys, the checks on every
+// ciphertext, the in-flight row budget, and the metrics, logs and spans.
+package rpc
+
+//go:generate buf generate --template buf.gen.yaml --path fhepb/fhe.proto
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"example.com/fhe/bq"
+	"example.com/fhe/rpc/fhepb"
+	"example.com/fhe/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// RequestIDKey is the metadata a caller can set to have its batches
+	// logged with its own request id, as BigQuery's requestId is.
+	RequestIDKey = "x-request-id"
+
+	// MaxStreamBytesEnv overrides DefaultMaxStreamBytes.
+	MaxStreamBytesEnv = "FHE_MAX_STREAM_BYTES"
+
+	// DefaultMaxStreamBytes caps the ciphertexts AggregateStream holds
+	// until the client closes the stream, eight of the largest requests.
+	DefaultMaxStreamBytes = 8 * bq.DefaultMaxRequestBytes
+)
+
+// StreamLimit returns the limit of AggregateStream in bytes,
+// DefaultMaxStreamBytes unless FHE_MAX_STREAM_BYTES is set.
+func StreamLimit() int64 {
+	if v := os.Getenv(MaxStreamBytesEnv); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return DefaultMaxStreamBytes
+}
+
+// Batch runs a batch of a service's modes, eg with its bq.Modes' Do once
+// the keys are pinned.
+type Batch func(ctx context.Context, bqReq *bq.Request) *bq.Response
+
+// Server is the FHE service answered with a Batch.
+type Server struct {
+	fhepb.UnimplementedFHEServer
+
+	batch Batch
+}
+
+// NewServer returns the FHE service answered with batch.
+func NewServer(batch Batch) *Server {
+	return &Server{batch: batch}
+}
+
+// NewGRPCServer returns a gRPC server for the FHE service answered with
+// batch. It takes messages as large as the requests bq reads.
+func NewGRPCServer(batch Batch) *grpc.Server {
+	g := grpc.NewServer(grpc.MaxRecvMsgSize(int(bq.RequestLimit())))
+	fhepb.RegisterFHEServer(g, NewServer(batch))
+	return g
+}
+
+// run sends rows to mode as one batch and returns its replies.
+func (s *Server) run(ctx context.Context, mode string, udc map[string]string, rows [][]interface{}) ([]string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = tracing.Propagator.Extract(ctx, metadataCarrier(md))
+	id, err := requestID(md)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "request id: %v", err)
+	}
+	bqReq := &bq.Request{
+		RequestId:          id,
+		Caller:             caller(ctx),
+		UserDefinedContext: map[string]string{bq.ModeContextKey: mode},
+		Calls:              rows,
+	}
+	for k, v := range udc {
+		if k != bq.ModeContextKey {
+			bqReq.UserDefinedContext[k] = v
+		}
+	}
+	resp := s.batch(ctx, bqReq)
+	if resp.ErrorMessage != "" {
+		return nil, status.Error(code(resp.Status), resp.ErrorMessage)
+	}
+	return resp.Replies, nil
+}
+
+// code is the gRPC code for a batch that failed with an HTTP status, which
+// retries the same batches BigQuery does.
+func code(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	return codes.InvalidArgument
+}
+
+// requestID is the caller's request id, or a random one.
+func requestID(md metadata.MD) (string, error) {
+	if v := md.Get(RequestIDKey); len(v) > 0 && v[0] != "" {
+		return v[0], nil
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// caller names the client in the caller field BigQuery fills with the job.
+func caller(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return "grpc://" + p.Addr.String()
+	}
+	return "grpc"
+}
+
+// metadataCarrier reads the traceparent of a request from its metadata.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// ciphertexts decodes the base64 replies of a mode returning BYTES.
+func ciphertexts(replies []string) ([][]byte, error) {
+	out := make([][]byte, len(replies))
+	for i, r := range replies {
+		b, err := base64.StdEncoding.DecodeString(r)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "reply %d: %v", i, err)
+		}
+		out[i] = b
+	}
+	return out, nil
+}
+
+func bytesArray(cts [][]byte) []interface{} {
+	a := make([]interface{}, len(cts))
+	for i, ct := range cts {
+		a[i] = ct
+	}
+	return a
+}
+
+// number is an INT64 argument as BigQuery sends it, a JSON number.
+func number(v int64) interface{} {
+	return float64(v)
+}
+
+func orDefault(mode, def string) string {
+	if mode == "" {
+		return def
+	}
+	return mode
+}
+
+func (s *Server) Encrypt(ctx context.Context, req *fhepb.EncryptRequest) (*fhepb.Ciphertexts, error) {
+	mode := orDefault(req.Mode, "encrypt")
+	rows := make([][]interface{}, len(req.Values))
+	for i, v := range req.Values {
+		rows[i] = []interface{}{number(v)}
+		if req.Buckets != 0 {
+			rows[i] = append(rows[i], number(req.Buckets))
+		}
+	}
+	replies, err := s.run(ctx, mode, nil, rows)
+	if err != nil {
+		return nil, err
+	}
+	cts, err := ciphertexts(replies)
+	if err != nil {
+		return nil, err
+	}
+	return &fhepb.Ciphertexts{Ciphertexts: cts}, nil
+}
+
+func (s *Server) Decrypt(ctx context.Context, req *fhepb.DecryptRequest) (*fhepb.DecryptReply, error) {
+	mode := orDefault(req.Mode, "decrypt")
+	rows := make([][]interface{}, len(req.Ciphertexts))
+	for i, ct := range req.Ciphertexts {
+		rows[i] = []interface{}{ct}
+		if req.N != 0 {
+			rows[i] = append(rows[i], number(req.N))
+		}
+	}
+	replies, err := s.run(ctx, mode, nil, rows)
+	if err != nil {
+		return nil, err
+	}
+	if mode != "decrypt" {
+		return &fhepb.DecryptReply{Json: replies}, nil
+	}
+	// fhe_decrypt returns the value as BYTES for SAFE_CONVERT_BYTES_TO_STRING
+	digits, err := ciphertexts(replies)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]int64, len(digits))
+	for i, d := range digits {
+		if values[i], err = strconv.ParseInt(string(d), 10, 64); err != nil {
+			return nil, status.Errorf(codes.Internal, "reply %d: %v", i, err)
+		}
+	}
+	return &fhepb.DecryptReply{Values: values}, nil
+}
+
+func (s *Server) Evaluate(ctx context.Context, req *fhepb.EvaluateRequest) (*fhepb.Ciphertexts, error) {
+	if req.Mode == "" {
+		return nil, status.Error(codes.InvalidArgument, "no mode")
+	}
+	rows := make([][]interface{}, len(req.Rows))
+	for i, r := range req.Rows {
+		rows[i] = make([]interface{}, len(r.Args))
+		for j, a := range r.Args {
+			switch v := a.Value.(type) {
+			case *fhepb.Arg_Ciphertext:
+				rows[i][j] = v.Ciphertext
+			case *fhepb.Arg_Integer:
+				rows[i][j] = number(v.Integer)
+			case *fhepb.Arg_Ciphertexts:
+				rows[i][j] = bytesArray(v.Ciphertexts.GetCiphertexts())
+			case *fhepb.Arg_Integers:
+				ns := make([]interface{}, len(v.Integers.GetValues()))
+				for k, n := range v.Integers.GetValues() {
+					ns[k] = number(n)
+				}
+				rows[i][j] = ns
+			default:
+				return nil, status.Errorf(codes.InvalidArgument, "row %d: argument %d has no value", i, j)
+			}
+		}
+	}
+	replies, err := s.run(ctx, req.Mode, req.Context, rows)
+	if err != nil {
+		return nil, err
+	}
+	cts, err := ciphertexts(replies)
+	if err != nil {
+		return nil, err
+	}
+	return &fhepb.Ciphertexts{Ciphertexts: cts}, nil
+}
+
+func (s *Server) Aggregate(ctx context.Context, req *fhepb.AggregateRequest) (*fhepb.Ciphertext, error) {
+	return s.aggregate(ctx, req.Mode, req.Ciphertexts)
+}
+
+// aggregate runs mode on one row of the ARRAY<BYTES> cts.
+func (s *Server) aggregate(ctx context.Context, mode string, cts [][]byte) (*fhepb.Ciphertext, error) {
+	replies, err := s.run(ctx, orDefault(mode, "aggregate"), nil, [][]interface{}{{bytesArray(cts)}})
+	if err != nil {
+		return nil, err
+	}
+	out, err := ciphertexts(replies)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) != 1 {
+		return nil, status.Errorf(codes.Internal, "%d replies to one row", len(out))
+	}
+	return &fhepb.Ciphertext{Ciphertext: out[0]}, nil
+}
+
+// stream answers each request received on a stream with do until the
+// client closes it.
+func stream[Req, Reply any](recv func() (*Req, error), send func(*Reply) error, do func(*Req) (*Reply, error)) error {
+	for {
+		req, err := recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		reply, err := do(req)
+		if err != nil {
+			return err
+		}
+		if err := send(reply); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) EncryptStream(st fhepb.FHE_EncryptStreamServer) error {
+	return stream(st.Recv, st.Send, func(req *fhepb.EncryptRequest) (*fhepb.Ciphertexts, error) {
+		return s.Encrypt(st.Context(), req)
+	})
+}
+
+func (s *Server) DecryptStream(st fhepb.FHE_DecryptStreamServer) error {
+	return stream(st.Recv, st.Send, func(req *fhepb.DecryptRequest) (*fhepb.DecryptReply, error) {
+		return s.Decrypt(st.Context(), req)
+	})
+}
+
+func (s *Server) EvaluateStream(st fhepb.FHE_EvaluateStreamServer) error {
+	return stream(st.Recv, st.Send, func(req *fhepb.EvaluateRequest) (*fhepb.Ciphertexts, error) {
+		return s.Evaluate(st.Context(), req)
+	})
+}
+
+func (s *Server) AggregateStream(st fhepb.FHE_AggregateStreamServer) error {
+	var (
+		mode  string
+		cts   [][]byte
+		size  int64
+		limit = StreamLimit()
+	)
+	for {
+		req, err := st.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if req.Mode != "" && mode != "" && req.Mode != mode {
+			return status.Errorf(codes.InvalidArgument, "mode %s after %s", req.Mode, mode)
+		}
+		mode = orDefault(req.Mode, mode)
+		cts = append(cts, req.Ciphertexts...)
+		for _, ct := range req.Ciphertexts {
+			size += int64(len(ct))
+		}
+		if size > limit {
+			return status.Errorf(codes.InvalidArgument, "ciphertexts over %d bytes, set %s for more", limit, MaxStreamBytesEnv)
+		}
+	}
+	reply, err := s.aggregate(st.Context(), mode, cts)
+	if err != nil {
+		return err
+	}
+	return st.SendAndClose(reply)
+}
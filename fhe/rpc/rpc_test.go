@@ -0,0 +1,308 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+
+	"example.com/fhe/bq"
+	"example.com/fhe/rpc/fhepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// modes stand in for the services' with "ciphertexts" that are the bytes
+// of the value, so the tests see what rows the RowFuncs were given.
+var modes = bq.NewModes("encrypt", map[string]*bq.Handler{
+	"encrypt": bq.NewHandler(1, func(ctx context.Context, row []interface{}) (string, error) {
+		v, err := bq.Number(row, 0)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(int(v)))), nil
+	}),
+	"decrypt": bq.NewHandler(1, func(ctx context.Context, row []interface{}) (string, error) {
+		x, err := bq.Bytes(row, 0)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(x), nil
+	}),
+	"decrypt_packed": bq.NewHandler(2, func(ctx context.Context, row []interface{}) (string, error) {
+		x, err := bq.Bytes(row, 0)
+		if err != nil {
+			return "", err
+		}
+		n, err := bq.Number(row, 1)
+		if err != nil {
+			return "", err
+		}
+		return "[" + string(bytes.Repeat(append(x, ','), int(n)-1)) + string(x) + "]", nil
+	}),
+	"add": bq.NewHandler(2, func(ctx context.Context, row []interface{}) (string, error) {
+		x, err := bq.Bytes(row, 0)
+		if err != nil {
+			return "", err
+		}
+		y, err := bq.Bytes(row, 1)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(append(append(x, '+'), y...)), nil
+	}),
+	// the arguments of fhe_dot and the table of fhe_lut
+	"dot": bq.NewHandler(2, func(ctx context.Context, row []interface{}) (string, error) {
+		w, err := bq.Int64Array(row, 1)
+		if err != nil {
+			return "", err
+		}
+		reply := bq.UserDefinedContext(ctx)["table"] + ":" + strconv.Itoa(len(w))
+		return base64.StdEncoding.EncodeToString([]byte(reply)), nil
+	}),
+	"aggregate": bq.NewHandler(1, func(ctx context.Context, row []interface{}) (string, error) {
+		xs, err := bq.BytesArray(row, 0)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(bytes.Join(xs, []byte("+"))), nil
+	}),
+	"unavailable": bq.NewHandler(1, func(ctx context.Context, row []interface{}) (string, error) {
+		return "", bq.Unavailable(errors.New("key server down"))
+	}),
+})
+
+func newClient(t *testing.T, batch Batch) fhepb.FHEClient {
+	t.Helper()
+	l := bufconn.Listen(1 << 20)
+	g := NewGRPCServer(batch)
+	go g.Serve(l)
+	t.Cleanup(g.Stop)
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return l.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return fhepb.NewFHEClient(conn)
+}
+
+func ct(s string) *fhepb.Arg {
+	return &fhepb.Arg{Value: &fhepb.Arg_Ciphertext{Ciphertext: []byte(s)}}
+}
+
+func TestUnary(t *testing.T) {
+	var got *bq.Request
+	c := newClient(t, func(ctx context.Context, bqReq *bq.Request) *bq.Response {
+		got = bqReq
+		return modes.Do(ctx, bqReq)
+	})
+	ctx := metadata.AppendToOutgoingContext(context.Background(), RequestIDKey, "req-1")
+
+	enc, err := c.Encrypt(ctx, &fhepb.EncryptRequest{Values: []int64{4, -2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enc.Ciphertexts) != 2 || string(enc.Ciphertexts[0]) != "4" || string(enc.Ciphertexts[1]) != "-2" {
+		t.Errorf("Encrypt = %q", enc.Ciphertexts)
+	}
+	if got.RequestId != "req-1" || got.UserDefinedContext[bq.ModeContextKey] != "encrypt" {
+		t.Errorf("batch %s with context %v", got.RequestId, got.UserDefinedContext)
+	}
+
+	sum, err := c.Evaluate(ctx, &fhepb.EvaluateRequest{Mode: "add", Rows: []*fhepb.Row{
+		{Args: []*fhepb.Arg{ct("4"), ct("2")}},
+		{Args: []*fhepb.Arg{ct("1"), ct("-2")}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sum.Ciphertexts) != 2 || string(sum.Ciphertexts[0]) != "4+2" || string(sum.Ciphertexts[1]) != "1+-2" {
+		t.Errorf("Evaluate add = %q", sum.Ciphertexts)
+	}
+
+	dot, err := c.Evaluate(ctx, &fhepb.EvaluateRequest{
+		Mode: "dot",
+		Rows: []*fhepb.Row{{Args: []*fhepb.Arg{ct("x"), {Value: &fhepb.Arg_Integers{Integers: &fhepb.Integers{Values: []int64{1, 2, 3}}}}}}},
+		// mode can't be overridden
+		Context: map[string]string{"table": "t", bq.ModeContextKey: "add"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dot.Ciphertexts[0]) != "t:3" {
+		t.Errorf("Evaluate dot = %q", dot.Ciphertexts[0])
+	}
+
+	dec, err := c.Decrypt(ctx, &fhepb.DecryptRequest{Ciphertexts: [][]byte{[]byte("6"), []byte("-1")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dec.Values) != 2 || dec.Values[0] != 6 || dec.Values[1] != -1 {
+		t.Errorf("Decrypt = %v", dec.Values)
+	}
+	packed, err := c.Decrypt(ctx, &fhepb.DecryptRequest{Mode: "decrypt_packed", N: 3, Ciphertexts: [][]byte{[]byte("7")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packed.Json) != 1 || packed.Json[0] != "[7,7,7]" {
+		t.Errorf("Decrypt decrypt_packed = %q", packed.Json)
+	}
+
+	agg, err := c.Aggregate(ctx, &fhepb.AggregateRequest{Ciphertexts: [][]byte{[]byte("1"), []byte("2"), []byte("3")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(agg.Ciphertext) != "1+2+3" {
+		t.Errorf("Aggregate = %q", agg.Ciphertext)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c := newClient(t, modes.Do)
+	ctx := context.Background()
+	for _, test := range []struct {
+		name string
+		call func() error
+		want codes.Code
+	}{
+		{"unknown mode", func() error {
+			_, err := c.Evaluate(ctx, &fhepb.EvaluateRequest{Mode: "nope", Rows: []*fhepb.Row{{Args: []*fhepb.Arg{ct("x")}}}})
+			return err
+		}, codes.InvalidArgument},
+		{"no mode", func() error {
+			_, err := c.Evaluate(ctx, &fhepb.EvaluateRequest{})
+			return err
+		}, codes.InvalidArgument},
+		{"wrong arity", func() error {
+			_, err := c.Evaluate(ctx, &fhepb.EvaluateRequest{Mode: "add", Rows: []*fhepb.Row{{Args: []*fhepb.Arg{ct("x")}}}})
+			return err
+		}, codes.InvalidArgument},
+		{"empty argument", func() error {
+			_, err := c.Evaluate(ctx, &fhepb.EvaluateRequest{Mode: "add", Rows: []*fhepb.Row{{Args: []*fhepb.Arg{ct("x"), {}}}}})
+			return err
+		}, codes.InvalidArgument},
+		{"transient", func() error {
+			_, err := c.Evaluate(ctx, &fhepb.EvaluateRequest{Mode: "unavailable", Rows: []*fhepb.Row{{Args: []*fhepb.Arg{ct("x")}}}})
+			return err
+		}, codes.Unavailable},
+		{"overloaded", func() error {
+			_, err := newClient(t, func(context.Context, *bq.Request) *bq.Response {
+				return bq.ErrorResponse(bq.ErrOverloaded)
+			}).Encrypt(ctx, &fhepb.EncryptRequest{Values: []int64{1}})
+			return err
+		}, codes.ResourceExhausted},
+	} {
+		if got := status.Code(test.call()); got != test.want {
+			t.Errorf("%s: %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestStreams(t *testing.T) {
+	batches := 0
+	c := newClient(t, func(ctx context.Context, bqReq *bq.Request) *bq.Response {
+		batches++
+		return modes.Do(ctx, bqReq)
+	})
+	ctx := context.Background()
+
+	enc, err := c.EncryptStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < 3; i++ {
+		if err := enc.Send(&fhepb.EncryptRequest{Values: []int64{i, i * 10}}); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := enc.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := strconv.Itoa(int(i * 10)); string(reply.Ciphertexts[1]) != want {
+			t.Errorf("batch %d: %q, want %s", i, reply.Ciphertexts[1], want)
+		}
+	}
+	enc.CloseSend()
+	if batches != 3 {
+		t.Errorf("%d batches, want 3", batches)
+	}
+
+	eval, err := c.EvaluateStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eval.Send(&fhepb.EvaluateRequest{Mode: "add", Rows: []*fhepb.Row{{Args: []*fhepb.Arg{ct("1"), ct("2")}}}})
+	if reply, err := eval.Recv(); err != nil || string(reply.Ciphertexts[0]) != "1+2" {
+		t.Errorf("EvaluateStream = %v, %v", reply, err)
+	}
+	// a failed batch ends the stream
+	eval.Send(&fhepb.EvaluateRequest{Mode: "add", Rows: []*fhepb.Row{{Args: []*fhepb.Arg{ct("1")}}}})
+	if _, err := eval.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("EvaluateStream with a bad row = %v", err)
+	}
+
+	dec, err := c.DecryptStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec.Send(&fhepb.DecryptRequest{Ciphertexts: [][]byte{[]byte("5")}})
+	if reply, err := dec.Recv(); err != nil || reply.Values[0] != 5 {
+		t.Errorf("DecryptStream = %v, %v", reply, err)
+	}
+	dec.CloseSend()
+
+	batches = 0
+	agg, err := c.AggregateStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []string{"1", "2", "3"} {
+		if err := agg.Send(&fhepb.AggregateRequest{Ciphertexts: [][]byte{[]byte(x)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sum, err := agg.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(sum.Ciphertext) != "1+2+3" || batches != 1 {
+		t.Errorf("AggregateStream = %q in %d batches, want 1+2+3 in one", sum.Ciphertext, batches)
+	}
+}
+
+// AggregateStream takes more than one message can hold, up to its own limit.
+func TestAggregateStreamLimit(t *testing.T) {
+	t.Setenv(bq.MaxRequestBytesEnv, "64")
+	t.Setenv(MaxStreamBytesEnv, "256")
+	c := newClient(t, modes.Do)
+	ctx := context.Background()
+	x := bytes.Repeat([]byte("x"), 40)
+
+	send := func(n int) (*fhepb.Ciphertext, error) {
+		agg, err := c.AggregateStream(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			if err := agg.Send(&fhepb.AggregateRequest{Ciphertexts: [][]byte{x}}); err != nil {
+				break // the server gave up, CloseAndRecv says why
+			}
+		}
+		return agg.CloseAndRecv()
+	}
+	if sum, err := send(3); err != nil || len(sum.Ciphertext) != 3*40+2 {
+		t.Errorf("AggregateStream of 120 bytes = %v, %v", sum, err)
+	}
+	if _, err := send(7); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("AggregateStream of 280 bytes = %v, want InvalidArgument", err)
+	}
+}
@@ -0,0 +1,54 @@
+package fhe
+
+import (
+	"fmt"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+// The values services compute on before they report ready.
+const (
+	selfTestX = 7
+	selfTestY = -3
+)
+
+// CheckKeys encrypts a known value with encryptor and checks that
+// decryptor decrypts it back, ie that the keys a service loaded are a pair
+// and work. Callers hold whatever lock guards the two.
+func CheckKeys(params *bfv.Parameters, encoder bfv.Encoder, encryptor bfv.Encryptor, decryptor bfv.Decryptor) error {
+	ct := encryptor.EncryptNew(EncodeValue(params, encoder, selfTestX))
+	got, err := DecodeValueChecked(encoder, decryptor.DecryptNew(ct))
+	if err != nil {
+		return fmt.Errorf("fhe: self-test: %v", err)
+	}
+	if got != selfTestX {
+		return fmt.Errorf("fhe: self-test decrypted %d, want %d", got, selfTestX)
+	}
+	return nil
+}
+
+// SelfTest encrypts two known values under throwaway keys, applies op and
+// checks the result decrypts to plain of the two. The evaluators hold no
+// secret key to check the keys they are given, so this tests the
+// evaluation itself.
+func SelfTest(op func(ev bfv.Evaluator, x, y *bfv.Ciphertext) (*bfv.Ciphertext, error), plain func(x, y int64) int64) error {
+	params := DefaultParams()
+	sk, pk := bfv.NewKeyGenerator(params).GenKeyPair()
+	encoder := bfv.NewEncoder(params)
+	encryptor := bfv.NewEncryptorFromPk(params, pk)
+	x := encryptor.EncryptNew(EncodeValue(params, encoder, selfTestX))
+	y := encryptor.EncryptNew(EncodeValue(params, encoder, selfTestY))
+
+	z, err := op(bfv.NewEvaluator(params), x, y)
+	if err != nil {
+		return fmt.Errorf("fhe: self-test: %v", err)
+	}
+	got, err := DecodeValueChecked(encoder, bfv.NewDecryptor(params, sk).DecryptNew(z))
+	if err != nil {
+		return fmt.Errorf("fhe: self-test: %v", err)
+	}
+	if want := plain(selfTestX, selfTestY); got != want {
+		return fmt.Errorf("fhe: self-test computed %d, want %d", got, want)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package fhe
+
+import (
+	"testing"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+func TestSelfTest(t *testing.T) {
+	add := func(ev bfv.Evaluator, x, y *bfv.Ciphertext) (*bfv.Ciphertext, error) { return Add(ev, x, y), nil }
+	if err := SelfTest(add, func(x, y int64) int64 { return x + y }); err != nil {
+		t.Errorf("add: %v", err)
+	}
+	if err := SelfTest(Mul, func(x, y int64) int64 { return x * y }); err != nil {
+		t.Errorf("mul: %v", err)
+	}
+	if err := SelfTest(add, func(x, y int64) int64 { return x - y }); err == nil {
+		t.Errorf("a wrong evaluation passed")
+	}
+
+	params := DefaultParams()
+	encoder := bfv.NewEncoder(params)
+	kg := bfv.NewKeyGenerator(params)
+	sk, pk := kg.GenKeyPair()
+	other := kg.GenSecretKey()
+	encryptor := bfv.NewEncryptorFromPk(params, pk)
+	if err := CheckKeys(params, encoder, encryptor, bfv.NewDecryptor(params, sk)); err != nil {
+		t.Errorf("CheckKeys(pair) = %v", err)
+	}
+	if err := CheckKeys(params, encoder, encryptor, bfv.NewDecryptor(params, other)); err == nil {
+		t.Errorf("CheckKeys passed keys that aren't a pair")
+	}
+}
@@ -0,0 +1,376 @@
+// Package service runs a BigQuery remote function server the same way in
+// every service: the function on /, /metrics, /healthz and /readyz next to
+// it, the gRPC API on the same port, a startup phase that loads keys with
+// exponential backoff instead of panicking in init(), key reloads on SIGHUP,
+// on a POST to /admin/reload or when a key file changes, and a graceful
+// shutdown that drains the batches in flight when Cloud Run sends SIGTERM.
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"example.com/fhe/bq"
+	"example.com/fhe/metrics"
+	"example.com/fhe/rpc"
+	"example.com/fhe/tracing"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const (
+	// Addr is where the services listen.
+	Addr = ":8080"
+
+	// DrainTimeout is how long SIGTERM waits for the batches in flight.
+	// Cloud Run kills the container 10s after SIGTERM.
+	DrainTimeout = 9 * time.Second
+
+	// AdminTokenEnv enables POST /admin/reload for callers presenting it
+	// as a bearer token.
+	AdminTokenEnv = "FHE_ADMIN_TOKEN"
+
+	// WatchIntervalEnv is how often key files are checked for changes,
+	// eg 30s. Unset, they aren't watched.
+	WatchIntervalEnv = "FHE_KEY_WATCH_INTERVAL"
+)
+
+// The triggers of a key reload.
+const (
+	SIGHUP = "sighup"
+	Admin  = "admin"
+	Watch  = "watch"
+)
+
+var (
+	errStarting     = errors.New("starting")
+	errShuttingDown = errors.New("shutting down")
+)
+
+// Backoff is the delay between startup attempts, doubling from Initial up
+// to Max.
+type Backoff struct {
+	Initial, Max time.Duration
+}
+
+// DefaultBackoff retries quickly at first, then every 30s while a key URL
+// stays unreachable.
+var DefaultBackoff = Backoff{Initial: 100 * time.Millisecond, Max: 30 * time.Second}
+
+// Retry calls f until it succeeds or ctx is done, logging every failure.
+func (b Backoff) Retry(ctx context.Context, f func() error) error {
+	delay := b.Initial
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		slog.WarnContext(ctx, "startup failed", "attempt", attempt, "retryIn", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > b.Max {
+			delay = b.Max
+		}
+	}
+}
+
+// Service is a BigQuery remote function server.
+type Service struct {
+	// Handler answers BigQuery on /.
+	Handler http.Handler
+
+	// Batch runs the batches of the gRPC API, eg the bq.Modes Handler
+	// serves with the keys pinned the same way. nil serves no gRPC.
+	Batch func(ctx context.Context, bqReq *bq.Request) *bq.Response
+
+	// Startup loads what the service needs and checks that it works, eg
+	// with a self-test encrypt and decrypt of a known value. It is retried
+	// with Backoff until it succeeds and /readyz fails until then. nil is
+	// ready at once.
+	Startup func() error
+	Backoff Backoff // DefaultBackoff if zero
+
+	// Reload fetches the keys again and swaps them in. nil has nothing to
+	// reload.
+	Reload func() error
+
+	// WatchEnv names the variables that point at keys. Those that are
+	// local files are watched every WatchInterval.
+	WatchEnv      []string
+	WatchInterval time.Duration // from FHE_KEY_WATCH_INTERVAL in Run
+
+	// AdminToken guards /admin/reload, which isn't served if it is empty.
+	AdminToken string // from FHE_ADMIN_TOKEN in Run
+
+	mu    sync.Mutex
+	state error // why the service isn't ready, nil once it is
+}
+
+func (s *Service) setState(err error) {
+	s.mu.Lock()
+	s.state = err
+	s.mu.Unlock()
+}
+
+// Ready returns nil once Startup has succeeded, and why not otherwise.
+func (s *Service) Ready() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Mux routes / to Handler next to /metrics, /healthz, which answers as
+// long as the process does, and /readyz, which answers 503 until Startup
+// has succeeded and once shutdown has begun.
+func (s *Service) Mux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/", s.Handler)
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := s.Ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	if s.AdminToken != "" && s.Reload != nil {
+		mux.HandleFunc("/admin/reload", s.serveReload)
+	}
+	return mux
+}
+
+// serveGRPC sends the gRPC requests among those of the combined listener to
+// g and the rest to h. gRPC comes over HTTP/2 with its own content type,
+// which is how Cloud Run passes it on with --use-http2, BigQuery's batches
+// included.
+func serveGRPC(g http.Handler, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			g.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serveReload reloads the keys for a POST with the admin token.
+func (s *Service) serveReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "POST to reload the keys", http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) != 1 {
+		http.Error(w, "invalid admin token", http.StatusUnauthorized)
+		return
+	}
+	if err := s.reload(r.Context(), Admin); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("reloaded\n"))
+}
+
+// reload runs Reload and records it in the metrics and the log.
+func (s *Service) reload(ctx context.Context, trigger string) error {
+	if err := metrics.KeyReload(trigger, s.Reload()); err != nil {
+		slog.WarnContext(ctx, "key reload failed, keeping the current keys", "trigger", trigger, "error", err)
+		return err
+	}
+	slog.InfoContext(ctx, "keys reloaded", "trigger", trigger)
+	return nil
+}
+
+// keyFiles returns the locations in WatchEnv that are local files.
+func (s *Service) keyFiles() []string {
+	var files []string
+	for _, env := range s.WatchEnv {
+		loc := os.Getenv(env)
+		if loc != "" && !strings.HasPrefix(loc, "https://") && !strings.HasPrefix(loc, "http://") {
+			files = append(files, loc)
+		}
+	}
+	return files
+}
+
+// stat sums up the size and modification time of files. A Cloud Run
+// secret volume swaps the file under its path when the version changes.
+func stat(files []string) string {
+	var b strings.Builder
+	for _, f := range files {
+		if fi, err := os.Stat(f); err != nil {
+			fmt.Fprintf(&b, "%s missing;", f)
+		} else {
+			fmt.Fprintf(&b, "%s %d %d;", f, fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+	return b.String()
+}
+
+// watch reloads the keys whenever a key file changes until ctx is done.
+func (s *Service) watch(ctx context.Context) {
+	files := s.keyFiles()
+	if len(files) == 0 {
+		return
+	}
+	slog.InfoContext(ctx, "watching key files", "files", files, "interval", s.WatchInterval)
+	last := stat(files)
+	tick := time.NewTicker(s.WatchInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		if now := stat(files); now != last {
+			last = now
+			s.reload(ctx, Watch)
+		}
+	}
+}
+
+// hangup reloads the keys on every SIGHUP until ctx is done.
+func (s *Service) hangup(ctx context.Context, hup <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			s.reload(ctx, SIGHUP)
+		}
+	}
+}
+
+// start runs Startup until it succeeds or ctx is done.
+func (s *Service) start(ctx context.Context) {
+	if s.Startup == nil {
+		s.setState(nil)
+		return
+	}
+	b := s.Backoff
+	if b == (Backoff{}) {
+		b = DefaultBackoff
+	}
+	err := b.Retry(ctx, func() error {
+		err := s.Startup()
+		if err != nil {
+			s.setState(err)
+		}
+		return err
+	})
+	if err == nil {
+		s.setState(nil)
+		slog.InfoContext(ctx, "ready")
+	}
+}
+
+// Run listens on addr until SIGTERM or an interrupt, then drains. It
+// reads AdminToken and WatchInterval from the environment.
+func (s *Service) Run(addr string) error {
+	if s.AdminToken == "" {
+		s.AdminToken = os.Getenv(AdminTokenEnv)
+	}
+	if v := os.Getenv(WatchIntervalEnv); v != "" && s.WatchInterval == 0 {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("service: invalid %s %q", WatchIntervalEnv, v)
+		}
+		s.WatchInterval = d
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, l)
+}
+
+// Serve serves l, reloading the keys when asked to, until ctx is done.
+// HTTP/1 and HTTP/2 without TLS share l, and gRPC if Batch is set. Then it
+// stops accepting connections, waits up to DrainTimeout for the requests in
+// flight and flushes the spans still buffered.
+func (s *Service) Serve(ctx context.Context, l net.Listener) error {
+	s.setState(errStarting)
+	var handler http.Handler = s.Mux()
+	if s.Batch != nil {
+		handler = serveGRPC(rpc.NewGRPCServer(s.Batch), handler)
+	}
+	// Shutdown doesn't wait for the HTTP/2 connections h2c takes over from
+	// the server, whose streams run inside the request that started them,
+	// so every request is counted here
+	var inflight sync.WaitGroup
+	h2s := &http2.Server{}
+	h2 := h2c.NewHandler(handler, h2s)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inflight.Add(1)
+		defer inflight.Done()
+		h2.ServeHTTP(w, r)
+	})}
+	// so that Shutdown sends the HTTP/2 connections a GOAWAY, after which
+	// they end once their streams have
+	http2.ConfigureServer(server, h2s)
+	go s.start(ctx)
+	if s.Reload != nil {
+		// registered before serving so a SIGHUP can't kill a ready server
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		go s.hangup(ctx, hup)
+		if s.WatchInterval > 0 {
+			go s.watch(ctx)
+		}
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("starting server", "addr", l.Addr().String())
+		errc <- server.Serve(l)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.setState(errShuttingDown)
+	slog.Info("shutting down, draining requests", "timeout", DrainTimeout)
+	drain, cancel := context.WithTimeout(context.Background(), DrainTimeout)
+	defer cancel()
+	err := server.Shutdown(drain)
+	drained := make(chan struct{})
+	go func() {
+		inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-drain.Done():
+		if err == nil {
+			err = drain.Err()
+		}
+	}
+	if terr := tracing.Shutdown(drain); err == nil {
+		err = terr
+	}
+	return err
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"example.com/fhe/bq"
+	"example.com/fhe/metrics"
+	"example.com/fhe/rpc/fhepb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestService(t *testing.T) {
+	calls, started := 0, make(chan bool)
+	release := make(chan bool)
+	s := &Service{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// a batch still computing when SIGTERM comes
+			started <- true
+			<-release
+			w.Write([]byte("done"))
+		}),
+		// fails twice, like a key URL that isn't reachable yet
+		Startup: func() error {
+			if calls++; calls < 3 {
+				return errors.New("key not loaded")
+			}
+			return nil
+		},
+		Backoff: Backoff{Initial: time.Millisecond, Max: 2 * time.Millisecond},
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + l.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() { served <- s.Serve(ctx, l) }()
+
+	if code, _ := get(t, url+"/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz = %d", code)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		code, body := get(t, url+"/readyz")
+		if code == http.StatusOK {
+			break
+		}
+		if code != http.StatusServiceUnavailable || time.Now().After(deadline) {
+			t.Fatalf("/readyz = %d %q", code, body)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if calls != 3 {
+		t.Errorf("Startup ran %d times, want 3", calls)
+	}
+	if code, body := get(t, url+"/metrics"); code != http.StatusOK || body == "" {
+		t.Errorf("/metrics = %d", code)
+	}
+
+	batch := make(chan string)
+	go func() {
+		_, body := get(t, url+"/")
+		batch <- body
+	}()
+	<-started
+	cancel()
+	// the listener closes at once but the batch in flight finishes
+	for s.Ready() != errShuttingDown {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	if body := <-batch; body != "done" {
+		t.Errorf("batch in flight got %q", body)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve = %v", err)
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	started, release := make(chan bool), make(chan bool)
+	s := &Service{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("bigquery"))
+		}),
+		Batch: func(ctx context.Context, bqReq *bq.Request) *bq.Response {
+			// a batch still computing when SIGTERM comes
+			started <- true
+			<-release
+			return &bq.Response{Replies: []string{base64.StdEncoding.EncodeToString([]byte("ct"))}}
+		},
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() { served <- s.Serve(ctx, l) }()
+
+	// the BigQuery handler answers on the same port
+	if code, body := get(t, "http://"+l.Addr().String()+"/"); code != http.StatusOK || body != "bigquery" {
+		t.Errorf("/ = %d %q", code, body)
+	}
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reply := make(chan error)
+	go func() {
+		r, err := fhepb.NewFHEClient(conn).Encrypt(context.Background(), &fhepb.EncryptRequest{Values: []int64{1}})
+		if err == nil && string(r.Ciphertexts[0]) != "ct" {
+			err = errors.New("got " + string(r.Ciphertexts[0]))
+		}
+		reply <- err
+	}()
+	<-started
+	cancel()
+	for s.Ready() != errShuttingDown {
+		time.Sleep(time.Millisecond)
+	}
+	// Serve waits for the call in flight
+	select {
+	case err := <-served:
+		t.Fatalf("Serve returned %v with a call in flight", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-reply; err != nil {
+		t.Errorf("call in flight: %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve = %v", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := 0
+	err := Backoff{Initial: time.Millisecond, Max: time.Millisecond}.Retry(ctx, func() error {
+		if n++; n == 3 {
+			cancel()
+		}
+		return errors.New("unreachable")
+	})
+	if !errors.Is(err, context.Canceled) || n != 3 {
+		t.Errorf("Retry = %v after %d attempts, want it cancelled after 3", err, n)
+	}
+}
+
+func TestReload(t *testing.T) {
+	key := filepath.Join(t.TempDir(), "pub.b64")
+	if err := os.WriteFile(key, []byte("v1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_KEY_URL", key)
+	reloads := make(chan bool, 10)
+	s := &Service{
+		Handler:       http.NotFoundHandler(),
+		Reload:        func() error { reloads <- true; return nil },
+		WatchEnv:      []string{"TEST_KEY_URL"},
+		WatchInterval: time.Millisecond,
+		AdminToken:    "secret",
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + l.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Serve(ctx, l)
+	// answering means SIGHUP is caught
+	for code, _ := get(t, url+"/readyz"); code != http.StatusOK; code, _ = get(t, url+"/readyz") {
+		time.Sleep(time.Millisecond)
+	}
+	before := testutil.ToFloat64(metrics.KeyReloads.WithLabelValues(Admin, metrics.OK))
+
+	reloaded := func(trigger string) {
+		t.Helper()
+		select {
+		case <-reloads:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no reload on %s", trigger)
+		}
+	}
+
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "Bearer nope": http.StatusUnauthorized, "Bearer secret": http.StatusOK} {
+		req, _ := http.NewRequest("POST", url+"/admin/reload", nil)
+		req.Header.Set("Authorization", token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("POST /admin/reload with %q = %d, want %d", token, resp.StatusCode, want)
+		}
+	}
+	reloaded(Admin)
+	if got := testutil.ToFloat64(metrics.KeyReloads.WithLabelValues(Admin, metrics.OK)) - before; got != 1 {
+		t.Errorf("counted %v admin reloads, want 1", got)
+	}
+	if code, _ := get(t, url+"/admin/reload"); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /admin/reload = %d", code)
+	}
+
+	if err := os.WriteFile(key, []byte("v2, longer"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	reloaded(Watch)
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	reloaded(SIGHUP)
+
+	select {
+	case <-reloads:
+		t.Errorf("reloaded without a trigger")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
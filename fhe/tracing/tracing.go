@@ -0,0 +1,105 @@
+// Package tracing sets up OpenTelemetry for the services. bq starts a span
+// for every batch and a child span for every row; where they go is picked
+// by FHE_TRACE_EXPORTER:
+//
+//	otlp    OTLP over HTTP, configured by the standard OTEL_EXPORTER_OTLP_*
+//	        variables (eg an OpenTelemetry collector sending to Cloud Trace)
+//	stdout  one JSON document per span, for local tests, on stderr or
+//	        appended to the file FHE_TRACE_FILE names; stdout is left to
+//	        the logs and the CLI's output
+//
+// Unset, spans are not recorded at all.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ExporterEnv picks the span exporter, otlp or stdout.
+	ExporterEnv = "FHE_TRACE_EXPORTER"
+
+	// FileEnv is where the stdout exporter writes its spans instead of
+	// stderr.
+	FileEnv = "FHE_TRACE_FILE"
+
+	// Name is the instrumentation name of the spans.
+	Name = "example.com/fhe"
+)
+
+// Tracer returns the tracer bq starts its spans with. It follows whatever
+// provider Init installs, even if it is called first.
+func Tracer() trace.Tracer {
+	return otel.Tracer(Name)
+}
+
+// Propagator reads the W3C traceparent Cloud Run passes along so the spans
+// join the request's trace.
+var Propagator = propagation.TraceContext{}
+
+// provider is the one Init installed, nil while tracing is off.
+var provider *sdktrace.TracerProvider
+
+// InitFromEnv installs the exporter FHE_TRACE_EXPORTER names, if any.
+// service is recorded on every span as service.name.
+func InitFromEnv(service string) error {
+	exporter := os.Getenv(ExporterEnv)
+	var w io.Writer = os.Stderr
+	if path := os.Getenv(FileEnv); path != "" && exporter == "stdout" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("tracing: %s: %v", FileEnv, err)
+		}
+		w = f
+	}
+	return Init(service, exporter, w)
+}
+
+// Init installs an exporter by name; stdout spans are written to w.
+func Init(service, exporter string, w io.Writer) error {
+	var opt sdktrace.TracerProviderOption
+	switch exporter {
+	case "":
+		return nil
+	case "stdout":
+		e, err := stdouttrace.New(stdouttrace.WithWriter(w))
+		if err != nil {
+			return err
+		}
+		// synchronous so a test sees its spans as soon as they end
+		opt = sdktrace.WithSyncer(e)
+	case "otlp":
+		e, err := otlptracehttp.New(context.Background())
+		if err != nil {
+			return err
+		}
+		opt = sdktrace.WithBatcher(e)
+	default:
+		return fmt.Errorf("tracing: invalid %s %q, want otlp or stdout", ExporterEnv, exporter)
+	}
+
+	provider = sdktrace.NewTracerProvider(opt, sdktrace.WithResource(
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(service))))
+	otel.SetTracerProvider(provider)
+	return nil
+}
+
+// Shutdown sends the spans still buffered and stops recording.
+func Shutdown(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+	return provider.Shutdown(ctx)
+}
@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Init("fhe-test", "stdout", &buf); err != nil {
+		t.Fatal(err)
+	}
+	_, span := Tracer().Start(context.Background(), "op")
+	span.End()
+
+	var got struct {
+		Name     string
+		Resource []struct {
+			Key   string
+			Value struct{ Value interface{} }
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("stdout span is not JSON: %v\n%s", err, buf.Bytes())
+	}
+	service := ""
+	for _, kv := range got.Resource {
+		if kv.Key == "service.name" {
+			service, _ = kv.Value.Value.(string)
+		}
+	}
+	if got.Name != "op" || service != "fhe-test" {
+		t.Errorf("span %q of service %q, want op of fhe-test", got.Name, service)
+	}
+	if err := Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	if err := Init("fhe-test", "zipkin", &buf); err == nil {
+		t.Errorf("Init with an unknown exporter succeeded")
+	}
+	if err := Init("fhe-test", "", &buf); err != nil {
+		t.Errorf("Init without an exporter: %v", err)
+	}
+}
+
+// The stdout exporter writes to FHE_TRACE_FILE when set, never to stdout.
+func TestInitFromEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	t.Setenv(ExporterEnv, "stdout")
+	t.Setenv(FileEnv, path)
+	if err := InitFromEnv("fhe-test"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Shutdown(context.Background()) })
+	_, span := Tracer().Start(context.Background(), "op")
+	span.End()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(`"Name":"op"`)) {
+		t.Errorf("%s holds %q, want the span", FileEnv, b)
+	}
+
+	t.Setenv(FileEnv, filepath.Join(path, "not a dir", "spans.json"))
+	if err := InitFromEnv("fhe-test"); err == nil {
+		t.Errorf("InitFromEnv with an unwritable %s succeeded", FileEnv)
+	}
+}
@@ -0,0 +1,70 @@
+package fhe
+
+import (
+	"errors"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+// ErrNoise means a decryption came out as noise: the ciphertext was made
+// under another key or went through more multiplications than the
+// parameters allow.
+var ErrNoise = errors.New("fhe: decryption is noise, wrong key or too many multiplications")
+
+// EncodeValue puts v in the first slot of a plaintext the way fhe_encrypt
+// does. v is reduced modulo T first: EncodeUint stores whatever it is given,
+// so a value of T or more would silently decrypt to garbage.
+func EncodeValue(params *bfv.Parameters, encoder bfv.Encoder, v int64) *bfv.Plaintext {
+	return EncodeSlots(params, encoder, []int64{v})
+}
+
+// EncodeSlots puts vs[i] in slot i of a plaintext, reduced modulo T, and
+// zero in the slots after them. There are 1<<LogN slots.
+func EncodeSlots(params *bfv.Parameters, encoder bfv.Encoder, vs []int64) *bfv.Plaintext {
+	slots := make([]uint64, 1<<params.LogN)
+	for i, v := range vs {
+		slots[i] = reduce(params, v)
+	}
+	pt := bfv.NewPlaintext(params)
+	encoder.EncodeUint(slots, pt)
+	return pt
+}
+
+// reduce returns v modulo T, in [0, T).
+func reduce(params *bfv.Parameters, v int64) uint64 {
+	t := int64(params.T)
+	v %= t
+	if v < 0 {
+		v += t
+	}
+	return uint64(v)
+}
+
+// DecodeValue returns the first slot of pt centered around zero, which is
+// what fhe_decrypt replies with.
+func DecodeValue(encoder bfv.Encoder, pt *bfv.Plaintext) int64 {
+	return encoder.DecodeInt(pt)[0]
+}
+
+// DecodeValueChecked is DecodeValue but returns ErrNoise unless every other
+// slot is zero, or holds the same value as the first like Dot's result. That
+// holds for anything fhe_encrypt and the evaluators produce, and a bad
+// decryption is uniformly random in every slot.
+func DecodeValueChecked(encoder bfv.Encoder, pt *bfv.Plaintext) (int64, error) {
+	slots := encoder.DecodeInt(pt)
+	zero, same := true, true
+	for _, v := range slots[1:] {
+		zero = zero && v == 0
+		same = same && v == slots[0]
+		if !zero && !same {
+			return 0, ErrNoise
+		}
+	}
+	return slots[0], nil
+}
+
+// DecodeSlots returns the first n slots of pt centered around zero, for
+// ciphertexts that pack one value per slot like Pack's.
+func DecodeSlots(encoder bfv.Encoder, pt *bfv.Plaintext, n int) []int64 {
+	return encoder.DecodeInt(pt)[:n]
+}
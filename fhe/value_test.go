@@ -0,0 +1,58 @@
+package fhe
+
+import (
+	"testing"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+func TestValue(t *testing.T) {
+	params := DefaultParams()
+	sk, pk := bfv.NewKeyGenerator(params).GenKeyPair()
+	encoder := bfv.NewEncoder(params)
+	encryptor := bfv.NewEncryptorFromPk(params, pk)
+	decryptor := bfv.NewDecryptor(params, sk)
+	T := int64(params.T)
+
+	tests := map[int64]int64{
+		0:       0,
+		3:       3,
+		-3:      -3,
+		T / 2:   T / 2,
+		T:       0,
+		T + 5:   5,
+		-T - 5:  -5,
+		T/2 + 1: -T / 2,
+	}
+	for v, want := range tests {
+		ct := encryptor.EncryptNew(EncodeValue(params, encoder, v))
+		if got := DecodeValue(encoder, decryptor.DecryptNew(ct)); got != want {
+			t.Errorf("DecodeValue(EncodeValue(%d)) = %d, want %d", v, got, want)
+		}
+	}
+}
+
+func TestDecodeValueChecked(t *testing.T) {
+	params := DefaultParams()
+	kgen := bfv.NewKeyGenerator(params)
+	sk, pk := kgen.GenKeyPair()
+	otherSk, _ := kgen.GenKeyPair()
+	encoder := bfv.NewEncoder(params)
+	ct := bfv.NewEncryptorFromPk(params, pk).EncryptNew(EncodeValue(params, encoder, -42))
+
+	if v, err := DecodeValueChecked(encoder, bfv.NewDecryptor(params, sk).DecryptNew(ct)); v != -42 || err != nil {
+		t.Errorf("DecodeValueChecked() = %d, %v, want -42", v, err)
+	}
+	if _, err := DecodeValueChecked(encoder, bfv.NewDecryptor(params, otherSk).DecryptNew(ct)); err != ErrNoise {
+		t.Errorf("DecodeValueChecked(wrong key) = %v, want ErrNoise", err)
+	}
+
+	// past MulDepth the noise swamps the value
+	ev := bfv.NewEvaluator(params)
+	for i := 0; i <= MulDepth; i++ {
+		ct = ev.MulNew(ct, ct)
+	}
+	if _, err := DecodeValueChecked(encoder, bfv.NewDecryptor(params, sk).DecryptNew(ct)); err != ErrNoise {
+		t.Errorf("DecodeValueChecked(too deep) = %v, want ErrNoise", err)
+	}
+}
@@ -0,0 +1,195 @@
+package fhe
+
+import (
+	"sync"
+
+	"example.com/fhe/metrics"
+	"github.com/ldsec/lattigo/bfv"
+	"github.com/ldsec/lattigo/ring"
+)
+
+// maxScratch is how many scratch ciphertexts a Workspace keeps between
+// rows. A row of a fixed arity op needs three at most; the ciphertexts of
+// an ARRAY argument are better left to the garbage collector.
+const maxScratch = 4
+
+// Workspace is what one row needs to compute under one parameter set: an
+// evaluator and an encoder, which keep scratch polynomials of their own and
+// so are not safe for concurrent use, a slot buffer and plaintext to encode
+// into, and scratch ciphertexts to decode and compute into. Making them is
+// most of the cost of a row of fhe_add. A row takes a Workspace with
+// GetWorkspace, uses it alone and puts it back.
+type Workspace struct {
+	Params    *bfv.Parameters
+	Evaluator bfv.Evaluator
+	Encoder   bfv.Encoder
+
+	slots []uint64
+	pt    *bfv.Plaintext
+
+	// the encryptor and decryptor of the last keys asked for, which keep
+	// scratch space of their own too
+	pk        *bfv.PublicKey
+	encryptor bfv.Encryptor
+	sk        *bfv.SecretKey
+	decryptor bfv.Decryptor
+
+	// scratch ciphertexts, the first used of them handed out since the
+	// Workspace was taken
+	scratch []*scratch
+	used    int
+}
+
+// scratch is a ciphertext with the polynomials of the largest degree it
+// has had, so it shrinks and grows without allocating.
+type scratch struct {
+	ct    *bfv.Ciphertext
+	polys []*ring.Poly
+}
+
+// workspaces holds a sync.Pool of Workspaces for each parameter set, which
+// keeps about one Workspace per P for the rows running on it.
+var workspaces sync.Map
+
+// GetWorkspace takes a Workspace for params from the pool, making one if
+// every Workspace is in use.
+func GetWorkspace(params *bfv.Parameters) *Workspace {
+	p, ok := workspaces.Load(params)
+	if !ok {
+		p, _ = workspaces.LoadOrStore(params, &sync.Pool{New: func() any {
+			return newWorkspace(params)
+		}})
+	}
+	return p.(*sync.Pool).Get().(*Workspace)
+}
+
+func newWorkspace(params *bfv.Parameters) *Workspace {
+	return &Workspace{
+		Params:    params,
+		Evaluator: bfv.NewEvaluator(params),
+		Encoder:   bfv.NewEncoder(params),
+		slots:     make([]uint64, 1<<params.LogN),
+		pt:        bfv.NewPlaintext(params),
+	}
+}
+
+// Put returns w to the pool. Nothing w handed out may be used after, so
+// marshal the results first.
+func (w *Workspace) Put() {
+	if len(w.scratch) > maxScratch {
+		w.scratch = w.scratch[:maxScratch]
+	}
+	w.used = 0
+	p, _ := workspaces.Load(w.Params)
+	p.(*sync.Pool).Put(w)
+}
+
+// Encryptor returns an encryptor under pk for w alone. It is made again
+// only when pk is not the key of the last call, eg after a key reload.
+func (w *Workspace) Encryptor(pk *bfv.PublicKey) bfv.Encryptor {
+	if w.pk != pk {
+		w.pk, w.encryptor = pk, bfv.NewEncryptorFromPk(w.Params, pk)
+	}
+	return w.encryptor
+}
+
+// Decryptor returns a decryptor under sk for w alone, made again only
+// when sk is not the key of the last call.
+func (w *Workspace) Decryptor(sk *bfv.SecretKey) bfv.Decryptor {
+	if w.sk != sk {
+		w.sk, w.decryptor = sk, bfv.NewDecryptor(w.Params, sk)
+	}
+	return w.decryptor
+}
+
+// Ciphertext returns a scratch ciphertext of degree, with whatever values
+// it last held, for the evaluator to write a result into.
+func (w *Workspace) Ciphertext(degree int) *bfv.Ciphertext {
+	if w.used == len(w.scratch) {
+		ct := bfv.NewCiphertext(w.Params, uint64(degree))
+		w.scratch = append(w.scratch, &scratch{ct, ct.Value()})
+	}
+	s := w.scratch[w.used]
+	w.used++
+	n, moduli := uint64(1)<<w.Params.LogN, uint64(len(w.Params.Qi))
+	for len(s.polys) < degree+1 {
+		s.polys = append(s.polys, ring.NewPoly(n, moduli))
+	}
+	s.ct.SetValue(s.polys[:degree+1])
+	// what bfv.NewCiphertext marks, whatever the domain of the values
+	s.ct.SetIsNTT(true)
+	return s.ct
+}
+
+// UnmarshalCiphertext is UnmarshalCiphertext decoding into a scratch
+// ciphertext of w.
+func (w *Workspace) UnmarshalCiphertext(data []byte) (*bfv.Ciphertext, error) {
+	defer metrics.Time(metrics.Unmarshal)()
+	metrics.In(len(data))
+	degree, err := checkCiphertext(w.Params, data)
+	if err != nil {
+		return nil, err
+	}
+	ct := w.Ciphertext(degree)
+	n, moduli := uint64(1)<<w.Params.LogN, uint64(len(w.Params.Qi))
+	pointer := uint64(2)
+	for _, p := range ct.Value() {
+		// skip the ring degree and moduli checkCiphertext checked
+		if pointer, err = ring.DecodeCoeffs(pointer+2, n, moduli, p.Coeffs, data); err != nil {
+			return nil, err
+		}
+	}
+	ct.SetIsNTT(data[1] == 1)
+	return ct, nil
+}
+
+// EncodeValue is EncodeValue into the plaintext of w, which the next
+// encode overwrites.
+func (w *Workspace) EncodeValue(v int64) *bfv.Plaintext {
+	clear(w.slots)
+	w.slots[0] = reduce(w.Params, v)
+	w.Encoder.EncodeUint(w.slots, w.pt)
+	return w.pt
+}
+
+// EncodeSlots is EncodeSlots into the plaintext of w, which the next
+// encode overwrites.
+func (w *Workspace) EncodeSlots(vs []int64) *bfv.Plaintext {
+	clear(w.slots)
+	for i, v := range vs {
+		w.slots[i] = reduce(w.Params, v)
+	}
+	w.Encoder.EncodeUint(w.slots, w.pt)
+	return w.pt
+}
+
+// Add returns x + y in a scratch ciphertext.
+func (w *Workspace) Add(x, y *bfv.Ciphertext) *bfv.Ciphertext {
+	out := w.Ciphertext(int(max(x.Degree(), y.Degree())))
+	w.Evaluator.Add(x, y, out)
+	return out
+}
+
+// Sub returns x - y in a scratch ciphertext.
+func (w *Workspace) Sub(x, y *bfv.Ciphertext) *bfv.Ciphertext {
+	out := w.Ciphertext(int(max(x.Degree(), y.Degree())))
+	w.Evaluator.Sub(x, y, out)
+	return out
+}
+
+// Neg returns -x in a scratch ciphertext.
+func (w *Workspace) Neg(x *bfv.Ciphertext) *bfv.Ciphertext {
+	out := w.Ciphertext(int(x.Degree()))
+	w.Evaluator.Neg(x, out)
+	return out
+}
+
+// Mul is Mul into a scratch ciphertext.
+func (w *Workspace) Mul(x, y *bfv.Ciphertext) (*bfv.Ciphertext, error) {
+	if err := checkProduct(x, y); err != nil {
+		return nil, err
+	}
+	out := w.Ciphertext(int(x.Degree() + y.Degree()))
+	w.Evaluator.Mul(x, y, out)
+	return out, nil
+}
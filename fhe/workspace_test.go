@@ -0,0 +1,172 @@
+package fhe
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/ldsec/lattigo/bfv"
+)
+
+func TestWorkspace(t *testing.T) {
+	params := DefaultParams()
+	sk, pk := bfv.NewKeyGenerator(params).GenKeyPair()
+	encoder := bfv.NewEncoder(params)
+	encryptor := bfv.NewEncryptorFromPk(params, pk)
+	decryptor := bfv.NewDecryptor(params, sk)
+	ev := bfv.NewEvaluator(params)
+	x := encryptor.EncryptNew(EncodeValue(params, encoder, 7))
+	y := encryptor.EncryptNew(EncodeValue(params, encoder, -3))
+	sq := ev.MulNew(x, x)
+	px, py, psq := mustMarshal(t, x), mustMarshal(t, y), mustMarshal(t, sq)
+
+	// rounds after the first compute into scratch left over from the last
+	for round := 0; round < 3; round++ {
+		w := GetWorkspace(params)
+		wx, err := w.UnmarshalCiphertext(px)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wy, _ := w.UnmarshalCiphertext(py)
+		wsq, _ := w.UnmarshalCiphertext(psq)
+		if !bytes.Equal(mustMarshal(t, wsq), psq) {
+			t.Fatalf("round %d: a degree 2 ciphertext doesn't decode to itself", round)
+		}
+
+		prod, err := w.Mul(wx, wy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := Mul(ev, x, y)
+		for name, c := range map[string][2]*bfv.Ciphertext{
+			"add": {w.Add(wx, wsq), Add(ev, x, sq)},
+			"sub": {w.Sub(wy, wsq), Sub(ev, y, sq)},
+			"neg": {w.Neg(wsq), Neg(ev, sq)},
+			"mul": {prod, want},
+		} {
+			if !bytes.Equal(mustMarshal(t, c[0]), mustMarshal(t, c[1])) {
+				t.Errorf("round %d: %s differs from a fresh evaluator's", round, name)
+			}
+		}
+		if _, err := w.Mul(wsq, ev.MulNew(sq, sq)); err == nil {
+			t.Errorf("a product of degree 6 was computed")
+		}
+		if got := encoder.DecodeInt(w.EncodeSlots([]int64{1, -2})); got[0] != 1 || got[1] != -2 || got[2] != 0 {
+			t.Errorf("round %d: EncodeSlots decodes to %v", round, got[:3])
+		}
+		if got := DecodeValue(encoder, w.EncodeValue(-5)); got != -5 {
+			t.Errorf("round %d: EncodeValue(-5) decodes to %d", round, got)
+		}
+		ct := w.Ciphertext(1)
+		encryptor.Encrypt(w.EncodeValue(11), ct)
+		if got, err := DecodeValueChecked(encoder, decryptor.DecryptNew(ct)); err != nil || got != 11 {
+			t.Errorf("round %d: 11 encrypted into scratch decrypts to %d, %v", round, got, err)
+		}
+		if _, err := w.UnmarshalCiphertext(px[:len(px)-1]); err == nil {
+			t.Errorf("a short ciphertext was decoded")
+		}
+		w.Put()
+	}
+}
+
+// rows is the batch size the benchmarks run, the most BigQuery sends to a
+// function with max_batching_rows unset.
+const rows = 1000
+
+// benchmarkRows runs row concurrently for rows rows, one goroutine each like
+// bq.Handler.
+func benchmarkRows(b *testing.B, row func() error) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		errc := make(chan error, rows)
+		for r := 0; r < rows; r++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := row(); err != nil {
+					errc <- err
+				}
+			}()
+		}
+		wg.Wait()
+		close(errc)
+		if err := <-errc; err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N*rows)/b.Elapsed().Seconds(), "rows/s")
+}
+
+// BenchmarkAdd compares a batch of fhe_add rows that make everything they
+// use with one that takes a Workspace.
+func BenchmarkAdd(b *testing.B) {
+	params := DefaultParams()
+	_, pk := bfv.NewKeyGenerator(params).GenKeyPair()
+	encryptor := bfv.NewEncryptorFromPk(params, pk)
+	x := mustMarshal(b, encryptor.EncryptNew(EncodeValue(params, bfv.NewEncoder(params), 3)))
+
+	b.Run("new", func(b *testing.B) {
+		benchmarkRows(b, func() error {
+			ev := bfv.NewEvaluator(params)
+			cx, err := UnmarshalCiphertext(params, x)
+			if err != nil {
+				return err
+			}
+			cy, err := UnmarshalCiphertext(params, x)
+			if err != nil {
+				return err
+			}
+			_, err = Add(ev, cx, cy).MarshalBinary()
+			return err
+		})
+	})
+	b.Run("workspace", func(b *testing.B) {
+		benchmarkRows(b, func() error {
+			w := GetWorkspace(params)
+			defer w.Put()
+			cx, err := w.UnmarshalCiphertext(x)
+			if err != nil {
+				return err
+			}
+			cy, err := w.UnmarshalCiphertext(x)
+			if err != nil {
+				return err
+			}
+			_, err = w.Add(cx, cy).MarshalBinary()
+			return err
+		})
+	})
+}
+
+// BenchmarkEncrypt compares encoding a batch of values into fresh
+// plaintexts and ciphertexts with encoding into a Workspace's.
+func BenchmarkEncrypt(b *testing.B) {
+	params := DefaultParams()
+	_, pk := bfv.NewKeyGenerator(params).GenKeyPair()
+	var mu sync.Mutex
+	encryptor := bfv.NewEncryptorFromPk(params, pk)
+
+	b.Run("new", func(b *testing.B) {
+		benchmarkRows(b, func() error {
+			pt := EncodeValue(params, bfv.NewEncoder(params), 42)
+			mu.Lock()
+			ct := encryptor.EncryptNew(pt)
+			mu.Unlock()
+			_, err := ct.MarshalBinary()
+			return err
+		})
+	})
+	b.Run("workspace", func(b *testing.B) {
+		benchmarkRows(b, func() error {
+			w := GetWorkspace(params)
+			defer w.Put()
+			pt, ct := w.EncodeValue(42), w.Ciphertext(1)
+			mu.Lock()
+			encryptor.Encrypt(pt, ct)
+			mu.Unlock()
+			_, err := ct.MarshalBinary()
+			return err
+		})
+	})
+}
@@ -3,166 +3,862 @@ package mul
 import (
 	"context"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
+	"os"
 	"sync"
-
+	"sync/atomic"
+
+	"example.com/fhe"
+	"example.com/fhe/bq"
+	"example.com/fhe/keyring"
+	"example.com/fhe/logging"
+	"example.com/fhe/metrics"
+	"example.com/fhe/service"
+	"example.com/fhe/tracing"
 	"github.com/ldsec/lattigo/bfv"
-	"golang.org/x/net/http2"
 )
 
-type bqRequest struct {
-	RequestId          string            `json:"requestId"`
-	Caller             string            `json:"caller"`
-	SessionUser        string            `json:"sessionUser"`
-	UserDefinedContext map[string]string `json:"userDefinedContext"`
-	Calls              [][]interface{}   `json:"calls"`
+func mul(ctx context.Context, x []byte, y []byte) ([]byte, error) {
+
+	x, err := mac.Open(x)
+	if err != nil {
+		return nil, err
+	}
+
+	// BFV parameters (128 bit security), or fhe_eq's for its results
+	params := fhe.CiphertextParams(x)
+	w := fhe.GetWorkspace(params)
+	defer w.Put()
+
+	y, err = mac.Open(y)
+	if err != nil {
+		return nil, err
+	}
+
+	rX, err := w.UnmarshalCiphertext(x)
+	if err != nil {
+		return nil, err
+	}
+
+	rY, err := w.UnmarshalCiphertext(y)
+	if err != nil {
+		return nil, err
+	}
+
+	done := metrics.Time(metrics.Evaluate)
+	XPlusY, err := w.Mul(rX, rY)
+	done()
+	if err != nil {
+		return nil, err
+	}
+	if err := rerand.Rerandomize(ctx, XPlusY); err != nil {
+		return nil, err
+	}
+	return mac.Marshal(XPlusY)
+}
+
+// openCiphertexts checks the tags on data and decodes the ciphertexts.
+func openCiphertexts(params *bfv.Parameters, data ...[]byte) ([]*bfv.Ciphertext, error) {
+	cts := make([]*bfv.Ciphertext, len(data))
+	for i, b := range data {
+		b, err := mac.Open(b)
+		if err != nil {
+			return nil, err
+		}
+		if cts[i], err = fhe.UnmarshalCiphertext(params, b); err != nil {
+			return nil, err
+		}
+	}
+	return cts, nil
 }
 
-type bqResponse struct {
-	Replies      []string `json:"replies,omitempty"`
-	ErrorMessage string   `json:"errorMessage,omitempty"`
+// sealCiphertext re-randomizes ct if configured to, then encodes and tags it.
+func sealCiphertext(ctx context.Context, ct *bfv.Ciphertext) ([]byte, error) {
+	if err := rerand.Rerandomize(ctx, ct); err != nil {
+		return nil, err
+	}
+	return mac.Marshal(ct)
 }
 
-func mul(x []byte, y []byte) ([]byte, error) {
+// distance2 returns (x1-x2)^2 + (y1-y2)^2.
+func distance2(ctx context.Context, x1, y1, x2, y2 []byte) ([]byte, error) {
+	params := fhe.DefaultParams()
+	g, err := ring.From(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := g.loadEval(ctx, fhe.RelinKeyURLEnv)
+	if err != nil {
+		return nil, err
+	}
+	cts, err := openCiphertexts(params, x1, y1, x2, y2)
+	if err != nil {
+		return nil, err
+	}
+	w := fhe.GetWorkspace(params)
+	defer w.Put()
+	done := metrics.Time(metrics.Evaluate)
+	d, err := fhe.Distance2(w.Evaluator, keys, cts[0], cts[1], cts[2], cts[3])
+	done()
+	if err != nil {
+		return nil, err
+	}
+	return sealCiphertext(ctx, d)
+}
 
-	// BFV parameters (128 bit security)
-	params := bfv.DefaultParams[bfv.PN12QP109]
-	//params.T =  0x3ee0001 //65929217₁₀
+// nearest returns the squared distances from (x, y) to every candidate
+// packed into one ciphertext, candidate i in slot i.
+func nearest(ctx context.Context, x, y []byte, xs, ys [][]byte) ([]byte, error) {
+	params := fhe.DefaultParams()
+	g, err := ring.From(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := g.loadEval(ctx, fhe.RelinKeyURLEnv, fhe.RotationKeyURLEnv)
+	if err != nil {
+		return nil, err
+	}
+	p, err := openCiphertexts(params, x, y)
+	if err != nil {
+		return nil, err
+	}
+	cxs, err := openCiphertexts(params, xs...)
+	if err != nil {
+		return nil, err
+	}
+	cys, err := openCiphertexts(params, ys...)
+	if err != nil {
+		return nil, err
+	}
+	w := fhe.GetWorkspace(params)
+	defer w.Put()
+	done := metrics.Time(metrics.Evaluate)
+	d, err := fhe.Nearest(w.Evaluator, params, keys, p[0], p[1], cxs, cys)
+	done()
+	if err != nil {
+		return nil, err
+	}
+	return sealCiphertext(ctx, d)
+}
 
-	evaluator := bfv.NewEvaluator(params)
+// dot returns sum_i w[i]*x[i] over the values x packs one per slot. The sum
+// ends up in every slot.
+func dot(ctx context.Context, x []byte, w []int64) ([]byte, error) {
+	params := fhe.DefaultParams()
+	g, err := ring.From(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := g.loadEval(ctx, fhe.RotationKeyURLEnv)
+	if err != nil {
+		return nil, err
+	}
+	cts, err := openCiphertexts(params, x)
+	if err != nil {
+		return nil, err
+	}
+	ws := fhe.GetWorkspace(params)
+	defer ws.Put()
+	done := metrics.Time(metrics.Evaluate)
+	d, err := fhe.Dot(ws.Evaluator, params, ws.Encoder, keys, cts[0], w)
+	done()
+	if err != nil {
+		return nil, err
+	}
+	return sealCiphertext(ctx, d)
+}
 
-	rX := &bfv.Ciphertext{}
-	rY := &bfv.Ciphertext{}
+// weightedSum returns sum_i w[i]*xs[i]. With weights of 1 it also sums the
+// bits fhe_eq returns, which are under EqParams.
+func weightedSum(ctx context.Context, xs [][]byte, w []int64) ([]byte, error) {
+	params := fhe.DefaultParams()
+	if len(xs) > 0 {
+		x, err := mac.Open(xs[0])
+		if err != nil {
+			return nil, err
+		}
+		params = fhe.CiphertextParams(x)
+	}
+	cts, err := openCiphertexts(params, xs...)
+	if err != nil {
+		return nil, err
+	}
+	ws := fhe.GetWorkspace(params)
+	defer ws.Put()
+	done := metrics.Time(metrics.Evaluate)
+	d, err := fhe.WeightedSum(ws.Evaluator, params, cts, w)
+	done()
+	if err != nil {
+		return nil, err
+	}
+	return sealCiphertext(ctx, d)
+}
 
-	err := rX.UnmarshalBinary(x)
+// maskedSum returns sum_i masks[i]*values[i] for one-hot masks, the total
+// of each category in its slot.
+func maskedSum(ctx context.Context, masks, values [][]byte) ([]byte, error) {
+	params := fhe.DefaultParams()
+	g, err := ring.From(ctx)
 	if err != nil {
 		return nil, err
 	}
+	keys, err := g.loadEval(ctx, fhe.RelinKeyURLEnv, fhe.RotationKeyURLEnv)
+	if err != nil {
+		return nil, err
+	}
+	cm, err := openCiphertexts(params, masks...)
+	if err != nil {
+		return nil, err
+	}
+	cv, err := openCiphertexts(params, values...)
+	if err != nil {
+		return nil, err
+	}
+	w := fhe.GetWorkspace(params)
+	defer w.Put()
+	done := metrics.Time(metrics.Evaluate)
+	d, err := fhe.MaskedSum(w.Evaluator, params, keys, cm, cv)
+	done()
+	if err != nil {
+		return nil, err
+	}
+	return sealCiphertext(ctx, d)
+}
 
-	err = rY.UnmarshalBinary(y)
+// aggregate returns the sum, sum of squares and count of the ciphertexts in
+// xs, merged with any aggregates in xs so a column can be done in parts.
+func aggregate(ctx context.Context, xs [][]byte) ([]byte, error) {
+	params := fhe.DefaultParams()
+	g, err := ring.From(ctx)
 	if err != nil {
 		return nil, err
 	}
-	XPlusY := evaluator.MulNew(rX, rY)
-	XPlusYBytes, err := XPlusY.MarshalBinary()
+	keys, err := g.loadEval(ctx, fhe.RelinKeyURLEnv)
 	if err != nil {
 		return nil, err
 	}
+	var values []*bfv.Ciphertext
+	var parts []*fhe.Aggregate
+	for i, b := range xs {
+		b, err := mac.Open(b)
+		if err != nil {
+			return nil, fmt.Errorf("element %d: %v", i, err)
+		}
+		if fhe.IsAggregate(b) {
+			a, err := fhe.UnmarshalAggregate(params, b)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %v", i, err)
+			}
+			parts = append(parts, a)
+			continue
+		}
+		ct, err := fhe.UnmarshalCiphertext(params, b)
+		if err != nil {
+			return nil, fmt.Errorf("element %d: %v", i, err)
+		}
+		values = append(values, ct)
+	}
 
-	return XPlusYBytes, nil
+	w := fhe.GetWorkspace(params)
+	defer w.Put()
+	evaluator := w.Evaluator
+	done := metrics.Time(metrics.Evaluate)
+	var agg *fhe.Aggregate
+	if len(values) > 0 {
+		if agg, err = fhe.AggregateOf(evaluator, keys, values); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range parts {
+		if agg == nil {
+			agg = a
+			continue
+		}
+		agg.Merge(evaluator, a)
+	}
+	done()
+	if agg == nil {
+		return nil, errors.New("nothing to aggregate")
+	}
+	if err := rerand.Rerandomize(ctx, agg.Sum); err != nil {
+		return nil, err
+	}
+	if err := rerand.Rerandomize(ctx, agg.SumSq); err != nil {
+		return nil, err
+	}
+	return mac.Marshal(agg)
 }
 
-const ()
+// eq returns an encrypted 1 if x = y and 0 otherwise. Both must be under
+// EqParams; y is nil to compare with the public value c instead.
+func eq(ctx context.Context, x, y []byte, c int64) ([]byte, error) {
+	params := fhe.EqParams()
+	g, err := ring.From(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := g.loadEq(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data := [][]byte{x}
+	if y != nil {
+		data = append(data, y)
+	}
+	cts, err := openCiphertexts(params, data...)
+	if err != nil {
+		return nil, err
+	}
+	w := fhe.GetWorkspace(params)
+	defer w.Put()
+	evaluator := w.Evaluator
+	var d *bfv.Ciphertext
+	done := metrics.Time(metrics.Evaluate)
+	if y != nil {
+		d, err = fhe.Eq(evaluator, params, w.Encoder, keys, cts[0], cts[1])
+	} else {
+		d, err = fhe.EqValue(evaluator, params, w.Encoder, keys, cts[0], c)
+	}
+	done()
+	if err != nil {
+		return nil, err
+	}
+	return sealCiphertext(ctx, d)
+}
 
-var ()
+// lut returns the table in userDefinedContext looked up at x, under
+// whichever parameters x is.
+func lut(ctx context.Context, x []byte) ([]byte, error) {
+	g, err := ring.From(ctx)
+	if err != nil {
+		return nil, err
+	}
+	table, err := g.lookupTable(ctx, bq.UserDefinedContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	x, err = mac.Open(x)
+	if err != nil {
+		return nil, err
+	}
+	params := fhe.CiphertextParams(x)
+	if err := table.CheckDepth(params); err != nil {
+		return nil, err
+	}
+	ct, err := fhe.UnmarshalCiphertext(params, x)
+	if err != nil {
+		return nil, err
+	}
+	w := fhe.GetWorkspace(params)
+	defer w.Put()
+	keys := (*fhe.EvalKeys)(nil)
+	if fhe.IsEqCiphertext(x) {
+		if keys, err = g.loadEq(ctx); err != nil {
+			return nil, err
+		}
+	}
+	done := metrics.Time(metrics.Evaluate)
+	y, err := table.Eval(w.Evaluator, params, w.Encoder, keys, ct)
+	done()
+	if err != nil {
+		return nil, err
+	}
+	return sealCiphertext(ctx, y)
+}
 
-func init() {}
+// lookupTable returns the table given inline or by name in udc. Tables are
+// interpolated once and kept, by their text or their name, maxLUTs of them
+// at most.
+func (g *evalKeys) lookupTable(ctx context.Context, udc map[string]string) (*fhe.LUT, error) {
+	var key string
+	var load func() (map[int64]int64, error)
+	if s := udc[fhe.LUTContextKey]; s != "" {
+		key = "lut " + s
+		load = func() (map[int64]int64, error) { return fhe.ParseLUT([]byte(s)) }
+	} else if name := udc[fhe.LUTNameContextKey]; name != "" {
+		key = "name " + name
+		load = func() (map[int64]int64, error) {
+			tables, err := g.loadLUTConfig(ctx)
+			if err != nil {
+				return nil, err
+			}
+			table, ok := tables[name]
+			if !ok {
+				return nil, fmt.Errorf("no table %q in %s", name, fhe.LUTConfigEnv)
+			}
+			return table, nil
+		}
+	} else {
+		return nil, fmt.Errorf("set %q or %q in user_defined_context", fhe.LUTContextKey, fhe.LUTNameContextKey)
+	}
 
-func FHE_MUL(w http.ResponseWriter, r *http.Request) {
+	if l, ok := g.luts.get(key); ok {
+		return l, nil
+	}
+	table, err := load()
+	if err != nil {
+		return nil, err
+	}
+	// T is the same for both parameter sets
+	l, err := fhe.NewLUT(fhe.DefaultParams(), table)
+	if err != nil {
+		return nil, err
+	}
+	g.luts.put(key, l)
+	return l, nil
+}
 
-	bqReq := &bqRequest{}
-	bqResp := &bqResponse{}
+// maxLUTs caps the interpolated tables an evalKeys keeps. Inline tables are
+// kept by their text, which every query can vary, so past it the least
+// recently used table is dropped and interpolated again if it comes back.
+const maxLUTs = 64
 
-	if err := json.NewDecoder(r.Body).Decode(&bqReq); err != nil {
-		bqResp.ErrorMessage = fmt.Sprintf("External Function error: can't read POST body %v", err)
-	} else {
+// lutCache holds interpolated tables by key, maxLUTs of them at most.
+type lutCache struct {
+	mu   sync.Mutex
+	tick uint64
+	luts map[string]*cachedLUT
+}
+
+type cachedLUT struct {
+	lut  *fhe.LUT
+	used uint64 // tick of the last get or put
+}
+
+func (c *lutCache) get(key string) (*fhe.LUT, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.luts[key]
+	if !ok {
+		return nil, false
+	}
+	c.tick++
+	e.used = c.tick
+	return e.lut, true
+}
+
+func (c *lutCache) put(key string, l *fhe.LUT) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.luts == nil {
+		c.luts = make(map[string]*cachedLUT)
+	}
+	if _, ok := c.luts[key]; !ok && len(c.luts) >= maxLUTs {
+		// a scan of maxLUTs entries costs nothing next to an interpolation
+		oldest := ""
+		for k, e := range c.luts {
+			if oldest == "" || e.used < c.luts[oldest].used {
+				oldest = k
+			}
+		}
+		delete(c.luts, oldest)
+	}
+	c.tick++
+	c.luts[key] = &cachedLUT{lut: l, used: c.tick}
+}
+
+// loadLUTConfig reads the named tables on first use. A failed read is
+// retried on the next call.
+func (g *evalKeys) loadLUTConfig(ctx context.Context) (_ map[string]map[int64]int64, err error) {
+	g.lutMu.Lock()
+	defer g.lutMu.Unlock()
+	if g.lutConfig != nil {
+		return g.lutConfig, nil
+	}
+	defer func() { metrics.KeyLoad("lut_config", err) }()
+	loc := os.Getenv(fhe.LUTConfigEnv)
+	if loc == "" {
+		return nil, fmt.Errorf("%s is not set", fhe.LUTConfigEnv)
+	}
+	// ReadKey reads any file or URL; JSON never passes for base64
+	b, err := fhe.ReadKey(ctx, loc)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := fhe.ParseLUTConfig(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fhe.LUTConfigEnv, err)
+	}
+	g.lutConfig = tables
+	return g.lutConfig, nil
+}
 
-		fmt.Printf("caller %s\n", bqReq.Caller)
-		fmt.Printf("sessionUser %s\n", bqReq.SessionUser)
-		fmt.Printf("userDefinedContext %v\n", bqReq.UserDefinedContext)
+// evalKeys is one generation of the evaluation keys and lookup tables. Each is
+// fetched by the first row that needs it unless the environment pointed at
+// it when the generation loaded. A reload swaps in a new generation and the
+// batches in flight finish with the one they were pinned to.
+type evalKeys struct {
+	// the relinearization and rotation keys for the default parameters
+	evalMu sync.Mutex // a fetch of eval
+	eval   atomic.Pointer[fhe.EvalKeys]
+
+	// the relinearization key for EqParams, about 30MB
+	eqMu   sync.Mutex // a fetch of eqEval
+	eqEval atomic.Pointer[fhe.EvalKeys]
+
+	// interpolated tables for fhe_lut, and the named ones FHE_LUT_CONFIG
+	// holds
+	luts      lutCache
+	lutMu     sync.Mutex
+	lutConfig map[string]map[int64]int64
+}
 
-		wait := new(sync.WaitGroup)
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+var (
+	// verifies inputs and tags outputs; nil when FHE_MAC_KEY is not set
+	mac *fhe.MAC
 
-		objs := make([]string, len(bqReq.Calls))
+	// adds an encryption of zero to results; nil unless FHE_RERANDOMIZE is set
+	rerand *fhe.Rerandomizer
 
-		for i, r := range bqReq.Calls {
-			if len(r) != 2 {
-				bqResp.ErrorMessage = fmt.Sprintf("Invalid number of input fields provided.  expected 2, got  %d", len(r))
+	// the evaluation keys and tables, reloaded without a restart. A batch
+	// pins an empty generation if none was loaded yet, and the rows that
+	// need a key fetch it, so fhe_mul never waits on a key it doesn't use.
+	ring = keyring.New(func(context.Context) (*evalKeys, error) {
+		return &evalKeys{}, nil
+	})
+
+	handler = bq.NewModes("mul", map[string]*bq.Handler{
+		"mul": bq.NewHandler(2, func(ctx context.Context, row []interface{}) (string, error) {
+			x, err := bq.Bytes(row, 0)
+			if err != nil {
+				return "", err
 			}
+			y, err := bq.Bytes(row, 1)
+			if err != nil {
+				return "", err
+			}
+			ec, err := mul(ctx, x, y)
+			if err != nil {
+				return "", err
+			}
+			return base64.StdEncoding.EncodeToString(ec), nil
+		}),
+
+		// fhe_distance2(x1, y1, x2, y2)
+		"distance2": bq.NewHandler(4, func(ctx context.Context, row []interface{}) (string, error) {
+			args := make([][]byte, 4)
+			for i := range args {
+				var err error
+				if args[i], err = bq.Bytes(row, i); err != nil {
+					return "", err
+				}
+			}
+			ec, err := distance2(ctx, args[0], args[1], args[2], args[3])
+			if err != nil {
+				return "", err
+			}
+			return base64.StdEncoding.EncodeToString(ec), nil
+		}),
 
-			xstr, ok := r[0].(string)
-			if !ok {
-				bqResp.ErrorMessage = "Invalid key type. expected string"
-				bqResp.Replies = nil
-				break
+		// fhe_nearest(x, y, xs ARRAY<BYTES>, ys ARRAY<BYTES>)
+		"nearest": bq.NewHandler(4, func(ctx context.Context, row []interface{}) (string, error) {
+			x, err := bq.Bytes(row, 0)
+			if err != nil {
+				return "", err
+			}
+			y, err := bq.Bytes(row, 1)
+			if err != nil {
+				return "", err
+			}
+			xs, err := bq.BytesArray(row, 2)
+			if err != nil {
+				return "", err
 			}
+			ys, err := bq.BytesArray(row, 3)
+			if err != nil {
+				return "", err
+			}
+			ec, err := nearest(ctx, x, y, xs, ys)
+			if err != nil {
+				return "", err
+			}
+			return base64.StdEncoding.EncodeToString(ec), nil
+		}),
 
-			x, err := base64.StdEncoding.DecodeString(xstr)
+		// fhe_dot(x BYTES, w ARRAY<INT64>) for a vector packed one value per slot
+		"dot": bq.NewHandler(2, func(ctx context.Context, row []interface{}) (string, error) {
+			x, err := bq.Bytes(row, 0)
 			if err != nil {
-				bqResp.ErrorMessage = "Invalid key type. expected string"
-				bqResp.Replies = nil
-				break
+				return "", err
 			}
+			w, err := bq.Int64Array(row, 1)
+			if err != nil {
+				return "", err
+			}
+			ec, err := dot(ctx, x, w)
+			if err != nil {
+				return "", err
+			}
+			return base64.StdEncoding.EncodeToString(ec), nil
+		}),
 
-			ystr, ok := r[1].(string)
-			if !ok {
-				bqResp.ErrorMessage = "Invalid cleartext type. expected string"
-				bqResp.Replies = nil
-				break
-			}
-
-			y, err := base64.StdEncoding.DecodeString(ystr)
-			if err != nil {
-				bqResp.ErrorMessage = "Invalid key type. expected string"
-				bqResp.Replies = nil
-				break
-			}
-
-			//  use goroutines heres but keep the order
-			wait.Add(1)
-			go func(j int) {
-				defer wait.Done()
-				for {
-					select {
-					case <-ctx.Done():
-						return
-					default:
-						ec, err := mul(x, y)
-						if err != nil {
-							bqResp.ErrorMessage = fmt.Sprintf("Error encrypting row %d", j)
-							bqResp.Replies = nil
-							cancel()
-							return
-						}
-						objs[j] = base64.StdEncoding.EncodeToString(ec)
-						return
-					}
+		// fhe_aggregate(xs ARRAY<BYTES>) for fhe_decrypt_stats
+		"aggregate": bq.NewHandler(1, func(ctx context.Context, row []interface{}) (string, error) {
+			xs, err := bq.BytesArray(row, 0)
+			if err != nil {
+				return "", err
+			}
+			ec, err := aggregate(ctx, xs)
+			if err != nil {
+				return "", err
+			}
+			return base64.StdEncoding.EncodeToString(ec), nil
+		}),
+
+		// fhe_eq(x BYTES, c INT64|BYTES) under EqParams
+		"eq": bq.NewHandler(2, func(ctx context.Context, row []interface{}) (string, error) {
+			x, err := bq.Bytes(row, 0)
+			if err != nil {
+				return "", err
+			}
+			var y []byte
+			var c float64
+			switch row[1].(type) {
+			case string, []byte:
+				y, err = bq.Bytes(row, 1)
+			default:
+				if c, err = bq.Number(row, 1); err == nil && c != math.Trunc(c) {
+					err = fmt.Errorf("invalid argument 1: %v is not an integer", c)
 				}
-			}(i)
+			}
+			if err != nil {
+				return "", err
+			}
+			ec, err := eq(ctx, x, y, int64(c))
+			if err != nil {
+				return "", err
+			}
+			return base64.StdEncoding.EncodeToString(ec), nil
+		}),
+
+		// fhe_lut(x BYTES) with the table in user_defined_context
+		"lut": bq.NewHandler(1, func(ctx context.Context, row []interface{}) (string, error) {
+			x, err := bq.Bytes(row, 0)
+			if err != nil {
+				return "", err
+			}
+			ec, err := lut(ctx, x)
+			if err != nil {
+				return "", err
+			}
+			return base64.StdEncoding.EncodeToString(ec), nil
+		}),
+
+		// fhe_masked_sum(masks ARRAY<BYTES>, values ARRAY<BYTES>) with
+		// fhe_encrypt_onehot masks
+		"masked_sum": bq.NewHandler(2, func(ctx context.Context, row []interface{}) (string, error) {
+			masks, err := bq.BytesArray(row, 0)
+			if err != nil {
+				return "", err
+			}
+			values, err := bq.BytesArray(row, 1)
+			if err != nil {
+				return "", err
+			}
+			ec, err := maskedSum(ctx, masks, values)
+			if err != nil {
+				return "", err
+			}
+			return base64.StdEncoding.EncodeToString(ec), nil
+		}),
+
+		// fhe_weighted_sum(xs ARRAY<BYTES>, w ARRAY<INT64>)
+		"weighted_sum": bq.NewHandler(2, func(ctx context.Context, row []interface{}) (string, error) {
+			xs, err := bq.BytesArray(row, 0)
+			if err != nil {
+				return "", err
+			}
+			w, err := bq.Int64Array(row, 1)
+			if err != nil {
+				return "", err
+			}
+			ec, err := weightedSum(ctx, xs, w)
+			if err != nil {
+				return "", err
+			}
+			return base64.StdEncoding.EncodeToString(ec), nil
+		}),
+	})
+)
+
+// loadEval fetches the evaluation keys on first use and checks that the
+// variables in need were set. A failed fetch is retried on the next call;
+// only the rows waiting on the keys wait for it.
+func (g *evalKeys) loadEval(ctx context.Context, need ...string) (*fhe.EvalKeys, error) {
+	for _, env := range need {
+		if os.Getenv(env) == "" {
+			return nil, fmt.Errorf("%s is not set", env)
 		}
+	}
+	if keys := g.eval.Load(); hasKeys(keys, need) {
+		return keys, nil
+	}
+	g.evalMu.Lock()
+	defer g.evalMu.Unlock()
+	if keys := g.eval.Load(); hasKeys(keys, need) {
+		return keys, nil
+	}
+	keys, err := fhe.LoadEvalKeys(ctx, fhe.DefaultParams(), os.Getenv)
+	if metrics.KeyLoad("eval", err) != nil {
+		return nil, err
+	}
+	g.eval.Store(keys)
+	return keys, nil
+}
 
-		wait.Wait()
-		if bqResp.ErrorMessage != "" {
-			bqResp.Replies = nil
-		} else {
-			bqResp.Replies = objs
+// hasKeys reports whether keys were fetched with the ones in need.
+func hasKeys(keys *fhe.EvalKeys, need []string) bool {
+	if keys == nil {
+		return false
+	}
+	for _, env := range need {
+		if env == fhe.RelinKeyURLEnv && keys.Relin == nil || env == fhe.RotationKeyURLEnv && keys.Rotation == nil {
+			return false
 		}
 	}
+	return true
+}
 
-	b, err := json.Marshal(bqResp)
+// loadEq fetches the EqParams relinearization key on first use. A failed
+// fetch is retried on the next call; only the rows waiting on the key wait
+// for it.
+func (g *evalKeys) loadEq(ctx context.Context) (*fhe.EvalKeys, error) {
+	if keys := g.eqEval.Load(); keys != nil {
+		return keys, nil
+	}
+	g.eqMu.Lock()
+	defer g.eqMu.Unlock()
+	if keys := g.eqEval.Load(); keys != nil {
+		return keys, nil
+	}
+	keys, err := fhe.LoadEqKeys(ctx, os.Getenv)
+	if metrics.KeyLoad("eq_relin", err) != nil {
+		return nil, err
+	}
+	g.eqEval.Store(keys)
+	return keys, nil
+}
+
+// loadKeys fetches a generation of the keys and tables the environment
+// points at, at startup and on every reload, so that one that fails to load
+// is never swapped in.
+func loadKeys(ctx context.Context) (*evalKeys, error) {
+	g := &evalKeys{}
+	if _, err := g.loadEval(ctx); err != nil {
+		return nil, err
+	}
+	if os.Getenv(fhe.EqRelinKeyURLEnv) != "" {
+		if _, err := g.loadEq(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if os.Getenv(fhe.LUTConfigEnv) != "" {
+		if _, err := g.loadLUTConfig(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// reload swaps in a new generation of keys and tables, then the public keys
+// results are re-randomized under.
+func reload() error {
+	g, err := loadKeys(context.Background())
+	if err != nil {
+		return err
+	}
+	ring.Set(g)
+	return rerand.Reload()
+}
+
+func init() {
+	var err error
+	if err := logging.InitFromEnv(); err != nil {
+		panic(err)
+	}
+	mac, err = fhe.NewMACFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	if mac == nil {
+		slog.Warn("ciphertext integrity checks are disabled", "unset", fhe.MACKeyEnv)
+	}
+	if err := tracing.InitFromEnv("fhe-mul"); err != nil {
+		panic(err)
+	}
+	rerand, err = fhe.NewRerandomizerFromEnv()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("can't convert response to JSON %v", err), http.StatusInternalServerError)
+		panic(err)
+	}
+	if rerand != nil {
+		slog.Info("results are re-randomized", "set", fhe.RerandomizeEnv)
+	}
+}
+
+func FHE_MUL(w http.ResponseWriter, r *http.Request) {
+
+	ctx, err := pin(r.Context())
+	if err != nil {
+		bq.WriteError(w, err)
 		return
 	}
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(b)
+// Batch runs a batch of the gRPC API pinned to the current keys, as
+// FHE_MUL does one from BigQuery.
+func Batch(ctx context.Context, bqReq *bq.Request) *bq.Response {
+	ctx, err := pin(ctx)
+	if err != nil {
+		return bq.ErrorResponse(err)
+	}
+	return handler.Do(ctx, bqReq)
 }
 
-func main() {
+// pin pins a batch to the current evaluation keys and tables and the
+// current re-randomizing key.
+func pin(ctx context.Context) (context.Context, error) {
+	ctx, err := ring.Pin(ctx)
+	if err != nil {
+		return ctx, fmt.Errorf("External Function error: can't load evaluation keys %w", err)
+	}
+	if ctx, err = rerand.Pin(ctx); err != nil {
+		return ctx, fmt.Errorf("External Function error: can't load the re-randomizing key %w", err)
+	}
+	return ctx, nil
+}
 
-	http.HandleFunc("/", FHE_MUL)
+// startup checks the evaluator on throwaway keys and fetches the keys and
+// tables the environment points at, which are otherwise fetched by the
+// first row that needs them.
+func startup() error {
+	if err := fhe.SelfTest(fhe.Mul, func(x, y int64) int64 { return x * y }); err != nil {
+		return err
+	}
+	g, err := loadKeys(context.Background())
+	if err != nil {
+		return err
+	}
+	ring.Set(g)
+	return rerand.Load()
+}
 
-	server := &http.Server{
-		Addr: ":8080",
+// Server is FHE_MUL run as a server by cmd/server, eg on Cloud Run: it loads
+// the keys at startup, serves the probes and the gRPC API, reloads the keys
+// and drains on SIGTERM. Deployed as a function, none of that runs.
+func Server() *service.Service {
+	return &service.Service{
+		Handler: http.HandlerFunc(FHE_MUL),
+		Batch:   Batch,
+		Startup: startup,
+		Reload:  reload,
+		WatchEnv: []string{
+			fhe.RelinKeyURLEnv, fhe.RotationKeyURLEnv, fhe.EqRelinKeyURLEnv,
+			fhe.LUTConfigEnv, fhe.PublicKeyURLEnv, fhe.EqPublicKeyURLEnv,
+		},
 	}
-	http2.ConfigureServer(server, &http2.Server{})
-	log.Println("Starting Server..")
-	err := server.ListenAndServe()
-	log.Fatalf("Unable to start Server %v", err)
 }
@@ -0,0 +1,224 @@
+package mul
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"example.com/fhe"
+	"example.com/fhe/bq"
+	"example.com/fhe/keyring"
+	"github.com/ldsec/lattigo/bfv"
+)
+
+func testCiphertext(t testing.TB, pk *bfv.PublicKey, v uint64) []byte {
+	t.Helper()
+	params := bfv.DefaultParams[bfv.PN12QP109]
+	pt := bfv.NewPlaintext(params)
+	bfv.NewEncoder(params).EncodeUint([]uint64{v}, pt)
+	b, err := bfv.NewEncryptorFromPk(params, pk).EncryptNew(pt).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func FuzzFHE_MUL(f *testing.F) {
+	_, pk := bfv.NewKeyGenerator(bfv.DefaultParams[bfv.PN12QP109]).GenKeyPair()
+	x := testCiphertext(f, pk, 3)
+	y := testCiphertext(f, pk, 2)
+	f.Add(x, y)
+	f.Add(x[:2], y)
+	f.Add([]byte{}, []byte{63, 1})
+
+	f.Fuzz(func(t *testing.T, x []byte, y []byte) {
+		body, err := json.Marshal(&bq.Request{
+			Calls: [][]interface{}{{base64.StdEncoding.EncodeToString(x), base64.StdEncoding.EncodeToString(y)}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		FHE_MUL(rec, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+
+		resp := &bq.Response{}
+		if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+			t.Fatalf("response is not JSON: %v", err)
+		}
+		if resp.ErrorMessage == "" && len(resp.Replies) != 1 {
+			t.Fatalf("got %d replies, want 1", len(resp.Replies))
+		}
+	})
+}
+
+func TestMulWithoutEvalKeys(t *testing.T) {
+	// fhe_mul doesn't relinearize, so a relinearization key that can't be
+	// fetched fails only the modes that use it
+	t.Setenv(fhe.RelinKeyURLEnv, filepath.Join(t.TempDir(), "missing"))
+	// a fresh ring, as before the first batch
+	prev := ring
+	ring = keyring.New(prev.Load)
+	t.Cleanup(func() { ring = prev })
+	params := bfv.DefaultParams[bfv.PN12QP109]
+	sk, pk := bfv.NewKeyGenerator(params).GenKeyPair()
+	body, err := json.Marshal(&bq.Request{
+		Calls: [][]interface{}{{
+			base64.StdEncoding.EncodeToString(testCiphertext(t, pk, 3)),
+			base64.StdEncoding.EncodeToString(testCiphertext(t, pk, 2)),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	FHE_MUL(rec, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	resp := &bq.Response{}
+	if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+		t.Fatalf("response is not JSON: %v", err)
+	}
+	if rec.Code != 200 || len(resp.Replies) != 1 {
+		t.Fatalf("fhe_mul = %d %q, want one reply", rec.Code, resp.ErrorMessage)
+	}
+	b, err := base64.StdEncoding.DecodeString(resp.Replies[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := fhe.UnmarshalCiphertext(params, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := bfv.NewEncoder(params).DecodeUint(bfv.NewDecryptor(params, sk).DecryptNew(ct))[0]; got != 6 {
+		t.Errorf("3 * 2 = %d", got)
+	}
+
+	g, _ := ring.Get(context.Background())
+	if _, err := g.loadEval(context.Background(), fhe.RelinKeyURLEnv); err == nil {
+		t.Errorf("loadEval() fetched a missing %s", fhe.RelinKeyURLEnv)
+	}
+}
+
+func TestLookupTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "luts.json")
+	if err := os.WriteFile(path, []byte(`{"flag": {"1": 1, "2": 0}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(fhe.LUTConfigEnv, path)
+	ctx := context.Background()
+	g, err := loadKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inline, err := g.lookupTable(ctx, map[string]string{fhe.LUTContextKey: `{"1": 1, "2": 0}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, err := g.lookupTable(ctx, map[string]string{fhe.LUTNameContextKey: "flag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(inline.Coeffs) != fmt.Sprint(named.Coeffs) {
+		t.Errorf("inline table %v, named %v", inline.Coeffs, named.Coeffs)
+	}
+	if again, _ := g.lookupTable(ctx, map[string]string{fhe.LUTNameContextKey: "flag"}); again != named {
+		t.Errorf("a named table was interpolated twice")
+	}
+	for _, udc := range []map[string]string{
+		nil,
+		{fhe.LUTNameContextKey: "nope"},
+		{fhe.LUTContextKey: `{"1": 1}`},
+	} {
+		if _, err := g.lookupTable(ctx, udc); err == nil {
+			t.Errorf("lookupTable(%v) succeeded", udc)
+		}
+	}
+}
+
+// Past maxLUTs tables the least recently used is dropped, not kept forever.
+func TestLookupTableBound(t *testing.T) {
+	ctx := context.Background()
+	g, err := loadKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inline := func(i int) map[string]string {
+		return map[string]string{fhe.LUTContextKey: fmt.Sprintf(`{"1": %d, "2": 0}`, i)}
+	}
+	first, err := g.lookupTable(ctx, inline(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= maxLUTs; i++ {
+		if _, err := g.lookupTable(ctx, inline(i)); err != nil {
+			t.Fatal(err)
+		}
+		if i == maxLUTs/2 {
+			// used again, so table 1 is the oldest
+			g.lookupTable(ctx, inline(0))
+		}
+	}
+	if n := len(g.luts.luts); n != maxLUTs {
+		t.Errorf("%d tables kept, want %d", n, maxLUTs)
+	}
+	if again, _ := g.lookupTable(ctx, inline(0)); again != first {
+		t.Errorf("a recently used table was dropped")
+	}
+	if _, ok := g.luts.get("lut " + inline(1)[fhe.LUTContextKey]); ok {
+		t.Errorf("the least recently used table was kept")
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "luts.json")
+	if err := os.WriteFile(path, []byte(`{"flag": {"1": 1, "2": 0}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(fhe.LUTConfigEnv, path)
+	udc := map[string]string{fhe.LUTNameContextKey: "flag"}
+
+	ctx, err := ring.Pin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, _ := ring.From(ctx)
+	before, err := g.lookupTable(ctx, udc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"flag": {"1": 0, "2": 1}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reload(); err != nil {
+		t.Fatal(err)
+	}
+	cur, _ := ring.Get(ctx)
+	after, err := cur.lookupTable(ctx, udc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(after.Coeffs) == fmt.Sprint(before.Coeffs) {
+		t.Errorf("the reloaded table is still %v", before.Coeffs)
+	}
+	// a batch pinned before the reload keeps its table
+	if pinned, _ := g.lookupTable(ctx, udc); pinned != before {
+		t.Errorf("the pinned generation changed its table")
+	}
+
+	// a config that doesn't parse is never swapped in
+	if err := os.WriteFile(path, []byte(`{`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := reload(); err == nil {
+		t.Errorf("reload() passed a broken %s", fhe.LUTConfigEnv)
+	}
+	if g, _ := ring.Get(ctx); g != cur {
+		t.Errorf("a failed reload replaced the keys")
+	}
+}
@@ -0,0 +1,19 @@
+// Command server runs FHE_NEG as a server, with its keys loaded at
+// startup, /healthz and /readyz, key reloads, the gRPC API and a drain on
+// SIGTERM. The Dockerfile builds it for Cloud Run.
+package main
+
+import (
+	"log/slog"
+	"os"
+
+	"example.com/fhe/service"
+	"example.com/neg"
+)
+
+func main() {
+	if err := neg.Server().Run(service.Addr); err != nil {
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
+	}
+}
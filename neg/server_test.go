@@ -0,0 +1,21 @@
+package neg
+
+import (
+	"testing"
+
+	"example.com/fhe"
+	"example.com/fhe/fhetest"
+	"github.com/ldsec/lattigo/bfv"
+)
+
+func FuzzFHE_NEG(f *testing.F) {
+	_, pk := bfv.NewKeyGenerator(fhe.DefaultParams()).GenKeyPair()
+	x := fhetest.Ciphertext(f, pk, 3)
+	for _, seed := range [][]byte{x, x[:2], {63, 1}} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, x []byte) {
+		fhetest.Call(t, FHE_NEG, "", []interface{}{x})
+	})
+}
@@ -3,166 +3,147 @@ package sub
 import (
 	"context"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"sync"
 
+	"example.com/fhe"
+	"example.com/fhe/bq"
+	"example.com/fhe/logging"
+	"example.com/fhe/metrics"
+	"example.com/fhe/service"
+	"example.com/fhe/tracing"
 	"github.com/ldsec/lattigo/bfv"
-	"golang.org/x/net/http2"
 )
 
-type bqRequest struct {
-	RequestId          string            `json:"requestId"`
-	Caller             string            `json:"caller"`
-	SessionUser        string            `json:"sessionUser"`
-	UserDefinedContext map[string]string `json:"userDefinedContext"`
-	Calls              [][]interface{}   `json:"calls"`
-}
-
-type bqResponse struct {
-	Replies      []string `json:"replies,omitempty"`
-	ErrorMessage string   `json:"errorMessage,omitempty"`
-}
+func sub(ctx context.Context, x []byte, y []byte) ([]byte, error) {
 
-func sub(x []byte, y []byte) ([]byte, error) {
-
-	// BFV parameters (128 bit security)
-	params := bfv.DefaultParams[bfv.PN12QP109]
-	//params.T =  0x3ee0001 //65929217₁₀
-
-	evaluator := bfv.NewEvaluator(params)
+	x, err := mac.Open(x)
+	if err != nil {
+		return nil, err
+	}
 
-	rX := &bfv.Ciphertext{}
-	rY := &bfv.Ciphertext{}
+	// BFV parameters (128 bit security), or fhe_eq's for its results
+	params := fhe.CiphertextParams(x)
+	w := fhe.GetWorkspace(params)
+	defer w.Put()
 
-	err := rX.UnmarshalBinary(x)
+	y, err = mac.Open(y)
 	if err != nil {
 		return nil, err
 	}
 
-	err = rY.UnmarshalBinary(y)
+	rX, err := w.UnmarshalCiphertext(x)
 	if err != nil {
 		return nil, err
 	}
-	XPlusY := evaluator.SubNew(rX, rY)
-	XPlusYBytes, err := XPlusY.MarshalBinary()
+
+	rY, err := w.UnmarshalCiphertext(y)
 	if err != nil {
 		return nil, err
 	}
-
-	return XPlusYBytes, nil
+	done := metrics.Time(metrics.Evaluate)
+	XPlusY := w.Sub(rX, rY)
+	done()
+	if err := rerand.Rerandomize(ctx, XPlusY); err != nil {
+		return nil, err
+	}
+	return mac.Marshal(XPlusY)
 }
 
 const ()
 
-var ()
-
-func init() {}
-
-func FHE_SUB(w http.ResponseWriter, r *http.Request) {
-
-	bqReq := &bqRequest{}
-	bqResp := &bqResponse{}
-
-	if err := json.NewDecoder(r.Body).Decode(&bqReq); err != nil {
-		bqResp.ErrorMessage = fmt.Sprintf("External Function error: can't read POST body %v", err)
-	} else {
+var (
+	// verifies inputs and tags outputs; nil when FHE_MAC_KEY is not set
+	mac *fhe.MAC
 
-		fmt.Printf("caller %s\n", bqReq.Caller)
-		fmt.Printf("sessionUser %s\n", bqReq.SessionUser)
-		fmt.Printf("userDefinedContext %v\n", bqReq.UserDefinedContext)
+	// adds an encryption of zero to results; nil unless FHE_RERANDOMIZE is set
+	rerand *fhe.Rerandomizer
 
-		wait := new(sync.WaitGroup)
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-
-		objs := make([]string, len(bqReq.Calls))
-
-		for i, r := range bqReq.Calls {
-			if len(r) != 2 {
-				bqResp.ErrorMessage = fmt.Sprintf("Invalid number of input fields provided.  expected 2, got  %d", len(r))
-			}
-
-			xstr, ok := r[0].(string)
-			if !ok {
-				bqResp.ErrorMessage = "Invalid key type. expected string"
-				bqResp.Replies = nil
-				break
-			}
-
-			x, err := base64.StdEncoding.DecodeString(xstr)
+	handler = bq.NewModes("sub", map[string]*bq.Handler{
+		"sub": bq.NewHandler(2, func(ctx context.Context, row []interface{}) (string, error) {
+			x, err := bq.Bytes(row, 0)
 			if err != nil {
-				bqResp.ErrorMessage = "Invalid key type. expected string"
-				bqResp.Replies = nil
-				break
+				return "", err
 			}
-
-			ystr, ok := r[1].(string)
-			if !ok {
-				bqResp.ErrorMessage = "Invalid cleartext type. expected string"
-				bqResp.Replies = nil
-				break
+			y, err := bq.Bytes(row, 1)
+			if err != nil {
+				return "", err
 			}
-
-			y, err := base64.StdEncoding.DecodeString(ystr)
+			ec, err := sub(ctx, x, y)
 			if err != nil {
-				bqResp.ErrorMessage = "Invalid key type. expected string"
-				bqResp.Replies = nil
-				break
+				return "", err
 			}
+			return base64.StdEncoding.EncodeToString(ec), nil
+		}),
+	})
+)
 
-			//  use goroutines heres but keep the order
-			wait.Add(1)
-			go func(j int) {
-				defer wait.Done()
-				for {
-					select {
-					case <-ctx.Done():
-						return
-					default:
-						ec, err := sub(x, y)
-						if err != nil {
-							bqResp.ErrorMessage = fmt.Sprintf("Error encrypting row %d", j)
-							bqResp.Replies = nil
-							cancel()
-							return
-						}
-						objs[j] = base64.StdEncoding.EncodeToString(ec)
-						return
-					}
-				}
-			}(i)
-		}
-
-		wait.Wait()
-		if bqResp.ErrorMessage != "" {
-			bqResp.Replies = nil
-		} else {
-			bqResp.Replies = objs
-		}
+func init() {
+	var err error
+	if err := logging.InitFromEnv(); err != nil {
+		panic(err)
+	}
+	mac, err = fhe.NewMACFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	if mac == nil {
+		slog.Warn("ciphertext integrity checks are disabled", "unset", fhe.MACKeyEnv)
+	}
+	if err := tracing.InitFromEnv("fhe-sub"); err != nil {
+		panic(err)
+	}
+	rerand, err = fhe.NewRerandomizerFromEnv()
+	if err != nil {
+		panic(err)
 	}
+	if rerand != nil {
+		slog.Info("results are re-randomized", "set", fhe.RerandomizeEnv)
+	}
+}
 
-	b, err := json.Marshal(bqResp)
+func FHE_SUB(w http.ResponseWriter, r *http.Request) {
+	ctx, err := rerand.Pin(r.Context())
 	if err != nil {
-		http.Error(w, fmt.Sprintf("can't convert response to JSON %v", err), http.StatusInternalServerError)
+		bq.WriteError(w, fmt.Errorf("External Function error: can't load the re-randomizing key %w", err))
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(b)
+	handler.ServeHTTP(w, r.WithContext(ctx))
 }
 
-func main() {
+// Batch runs a batch of the gRPC API pinned to the current re-randomizing
+// key, as FHE_SUB does one from BigQuery.
+func Batch(ctx context.Context, bqReq *bq.Request) *bq.Response {
+	ctx, err := rerand.Pin(ctx)
+	if err != nil {
+		return bq.ErrorResponse(fmt.Errorf("External Function error: can't load the re-randomizing key %w", err))
+	}
+	return handler.Do(ctx, bqReq)
+}
 
-	http.HandleFunc("/", FHE_SUB)
+// startup checks the evaluator on throwaway keys, sub holding none of its
+// own, and fetches the key results are re-randomized with.
+func startup() error {
+	err := fhe.SelfTest(func(ev bfv.Evaluator, x, y *bfv.Ciphertext) (*bfv.Ciphertext, error) {
+		return fhe.Sub(ev, x, y), nil
+	}, func(x, y int64) int64 { return x - y })
+	if err != nil {
+		return err
+	}
+	return rerand.Load()
+}
 
-	server := &http.Server{
-		Addr: ":8080",
+// Server is FHE_SUB run as a server by cmd/server, eg on Cloud Run: it loads
+// the keys at startup, serves the probes and the gRPC API, reloads the keys
+// and drains on SIGTERM. Deployed as a function, none of that runs.
+func Server() *service.Service {
+	return &service.Service{
+		Handler: http.HandlerFunc(FHE_SUB),
+		Batch:   Batch,
+		Startup: startup,
+		// the public keys results are re-randomized under
+		Reload:   rerand.Reload,
+		WatchEnv: []string{fhe.PublicKeyURLEnv, fhe.EqPublicKeyURLEnv},
 	}
-	http2.ConfigureServer(server, &http2.Server{})
-	log.Println("Starting Server..")
-	err := server.ListenAndServe()
-	log.Fatalf("Unable to start Server %v", err)
 }
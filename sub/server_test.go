@@ -0,0 +1,26 @@
+package sub
+
+import (
+	"testing"
+
+	"example.com/fhe"
+	"example.com/fhe/fhetest"
+	"github.com/ldsec/lattigo/bfv"
+)
+
+func FuzzFHE_SUB(f *testing.F) {
+	_, pk := bfv.NewKeyGenerator(fhe.DefaultParams()).GenKeyPair()
+	x := fhetest.Ciphertext(f, pk, 3)
+	y := fhetest.Ciphertext(f, pk, 2)
+	for _, seed := range []struct{ x, y []byte }{
+		{x, y},
+		{x[:2], y},
+		{[]byte{}, []byte{63, 1}},
+	} {
+		f.Add(seed.x, seed.y)
+	}
+
+	f.Fuzz(func(t *testing.T, x []byte, y []byte) {
+		fhetest.Call(t, FHE_SUB, "", []interface{}{x, y})
+	})
+}